@@ -0,0 +1,439 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAggregateInterval = 15 * time.Second
+	defaultAggregateTimeout  = 5 * time.Second
+	defaultFleetTop          = 5
+	maxPushBodyBytes         = 32 << 20
+	// Hosts that only push are forgotten after this long without data, and
+	// at most maxPushHosts of them are tracked.
+	forgetPushHostAfter = 24 * time.Hour
+	maxPushHosts        = 1000
+)
+
+// Host statuses in the fleet view.
+const (
+	HostUp          = "up"
+	HostUnreachable = "unreachable"
+)
+
+// HostSummary is one host in the fleet view. Usage fields are percentages;
+// DiskUsage is the fullest filesystem, named by DiskMount.
+type HostSummary struct {
+	Host         string   `json:"host"`
+	URL          string   `json:"url,omitempty"`
+	Source       string   `json:"source"`
+	Status       string   `json:"status"`
+	LastSeen     string   `json:"last_seen,omitempty"`
+	Error        string   `json:"error,omitempty"`
+	CPUUsage     float64  `json:"cpu_usage"`
+	MemoryUsage  float64  `json:"memory_usage"`
+	DiskUsage    float64  `json:"disk_usage"`
+	DiskMount    string   `json:"disk_mount,omitempty"`
+	LoadAverage  *float64 `json:"load_average_1m,omitempty"`
+	ProcessCount int      `json:"process_count"`
+	Uptime       uint64   `json:"uptime"`
+}
+
+// FleetView is served by /fleet.
+type FleetView struct {
+	Timestamp   string                   `json:"timestamp"`
+	Up          int                      `json:"up"`
+	Unreachable int                      `json:"unreachable"`
+	Hosts       []HostSummary            `json:"hosts"`
+	Top         map[string][]HostSummary `json:"top"`
+}
+
+// fleetHost is what the aggregator knows about one host.
+type fleetHost struct {
+	name     string
+	addr     string
+	url      string
+	source   string
+	lastSeen time.Time
+	lastErr  string
+	values   map[string]float64
+	// sampled is the timestamp of the newest pushed sample, which orders
+	// pushes. lastSeen is when data last arrived, so a host whose clock is
+	// off is neither reported stale nor kept alive by it.
+	sampled time.Time
+}
+
+// aggregator collects stats from peer monitors, either by polling their
+// /metrics endpoint or by accepting JSON pushes at /fleet/push.
+type aggregator struct {
+	peers      []string
+	interval   time.Duration
+	staleAfter time.Duration
+	acceptPush bool
+	pushToken  string
+	client     *http.Client
+
+	mu    sync.RWMutex
+	hosts map[string]*fleetHost
+}
+
+var fleet *aggregator
+
+// loadAggregator reads AGGREGATE_PEERS and the other AGGREGATE_* variables.
+// It returns nil unless peers are configured or AGGREGATE_ACCEPT_PUSH=true.
+// Accepting pushes requires AGGREGATE_PUSH_TOKEN, since every pushed host
+// name creates an entry in the fleet.
+func loadAggregator() (*aggregator, error) {
+	a := &aggregator{
+		hosts:      make(map[string]*fleetHost),
+		acceptPush: os.Getenv("AGGREGATE_ACCEPT_PUSH") == "true",
+		pushToken:  os.Getenv("AGGREGATE_PUSH_TOKEN"),
+	}
+	if a.acceptPush && len(a.pushToken) < 16 {
+		return nil, fmt.Errorf("AGGREGATE_PUSH_TOKEN must be set to at least 16 characters when AGGREGATE_ACCEPT_PUSH=true")
+	}
+	for _, peer := range strings.Split(os.Getenv("AGGREGATE_PEERS"), ",") {
+		if peer = strings.TrimSpace(peer); peer == "" {
+			continue
+		}
+		if !strings.Contains(peer, "://") {
+			peer = "http://" + peer
+		}
+		u, err := url.Parse(peer)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("AGGREGATE_PEERS: invalid peer %q", peer)
+		}
+		peer = strings.TrimSuffix(u.String(), "/")
+		a.peers = append(a.peers, peer)
+		a.hosts[peer] = &fleetHost{name: u.Host, addr: u.Host, url: peer, source: "poll"}
+	}
+	if len(a.peers) == 0 && !a.acceptPush {
+		return nil, nil
+	}
+
+	var err error
+	if a.interval, err = envDuration("AGGREGATE_INTERVAL", defaultAggregateInterval); err != nil {
+		return nil, err
+	}
+	timeout, err := envDuration("AGGREGATE_TIMEOUT", defaultAggregateTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if a.staleAfter, err = envDuration("AGGREGATE_STALE_AFTER", 3*a.interval); err != nil {
+		return nil, err
+	}
+	a.client = &http.Client{Timeout: timeout}
+	return a, nil
+}
+
+// run polls every peer on the interval. Peers are polled concurrently so a
+// slow or dead peer only delays itself.
+func (a *aggregator) run() {
+	if len(a.peers) == 0 {
+		return
+	}
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		var wg sync.WaitGroup
+		for _, peer := range a.peers {
+			wg.Add(1)
+			go func(peer string) {
+				defer wg.Done()
+				a.poll(peer)
+			}(peer)
+		}
+		wg.Wait()
+		<-ticker.C
+	}
+}
+
+func (a *aggregator) poll(peer string) {
+	stats, err := a.fetch(peer)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	h := a.hosts[peer]
+	wasUp := h.lastErr == "" && !h.lastSeen.IsZero()
+	if err != nil {
+		if wasUp {
+			log.Printf("Warning: Peer %s (%s) is unreachable: %v", h.name, peer, err)
+		}
+		h.lastErr = err.Error()
+		return
+	}
+	if !wasUp {
+		log.Printf("Peer %s (%s) is up", stats.HostInfo.Hostname, peer)
+	}
+	if stats.HostInfo.Hostname != "" {
+		h.name = stats.HostInfo.Hostname
+	}
+	h.lastSeen = time.Now()
+	h.lastErr = ""
+	h.values = flattenStats(stats)
+}
+
+func (a *aggregator) fetch(peer string) (*SystemStats, error) {
+	req, err := http.NewRequest(http.MethodGet, peer+"/metrics", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("peer returned %s", resp.Status)
+	}
+	stats := &SystemStats{}
+	if err := json.NewDecoder(resp.Body).Decode(stats); err != nil {
+		return nil, fmt.Errorf("error decoding peer stats: %v", err)
+	}
+	return stats, nil
+}
+
+// pushBatch is the body sent by monitors running with PUSH_FORMAT=json.
+type pushBatch struct {
+	Host    string `json:"host"`
+	Samples []struct {
+		Timestamp string             `json:"timestamp"`
+		Values    map[string]float64 `json:"values"`
+	} `json:"samples"`
+}
+
+// accept records a batch received at now. Only the newest sample is kept;
+// buffered batches replayed after an outage don't move a host's state
+// backwards.
+func (a *aggregator) accept(batch *pushBatch, now time.Time) error {
+	if batch.Host == "" {
+		return fmt.Errorf("batch has no host")
+	}
+	var latest time.Time
+	var values map[string]float64
+	for _, s := range batch.Samples {
+		t, err := time.Parse(time.RFC3339Nano, s.Timestamp)
+		if err != nil {
+			return fmt.Errorf("invalid sample timestamp %q", s.Timestamp)
+		}
+		if t.After(latest) {
+			latest, values = t, s.Values
+		}
+	}
+	if values == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.forgetPushHosts(now)
+	key := "push:" + batch.Host
+	h, ok := a.hosts[key]
+	if !ok {
+		if len(a.hosts)-len(a.peers) >= maxPushHosts {
+			return errTooManyHosts
+		}
+		log.Printf("Receiving pushes from %s", batch.Host)
+		h = &fleetHost{name: batch.Host, source: "push"}
+		a.hosts[key] = h
+	}
+	h.lastSeen = now
+	if latest.After(h.sampled) {
+		h.sampled = latest
+		h.values = values
+	}
+	return nil
+}
+
+var errTooManyHosts = fmt.Errorf("too many pushing hosts (limit %d)", maxPushHosts)
+
+// forgetPushHosts drops push-only hosts that have been silent for
+// forgetPushHostAfter. It must be called with a.mu held.
+func (a *aggregator) forgetPushHosts(now time.Time) {
+	for key, h := range a.hosts {
+		if h.source == "push" && now.Sub(h.lastSeen) > forgetPushHostAfter {
+			log.Printf("Forgetting %s, no pushes for %s", h.name, now.Sub(h.lastSeen).Round(time.Second))
+			delete(a.hosts, key)
+		}
+	}
+}
+
+// View summarizes every known host and ranks the reachable ones.
+func (a *aggregator) View(top int) *FleetView {
+	now := time.Now()
+	view := &FleetView{Timestamp: now.UTC().Format(time.RFC3339), Hosts: []HostSummary{}}
+
+	a.mu.RLock()
+	for _, h := range a.hosts {
+		view.Hosts = append(view.Hosts, a.summarize(h, now))
+	}
+	a.mu.RUnlock()
+	sort.Slice(view.Hosts, func(i, j int) bool {
+		if view.Hosts[i].Host != view.Hosts[j].Host {
+			return view.Hosts[i].Host < view.Hosts[j].Host
+		}
+		return view.Hosts[i].URL < view.Hosts[j].URL
+	})
+
+	var up []HostSummary
+	for _, h := range view.Hosts {
+		if h.Status == HostUp {
+			up = append(up, h)
+			view.Up++
+		} else {
+			view.Unreachable++
+		}
+	}
+
+	view.Top = make(map[string][]HostSummary)
+	for key, value := range map[string]func(HostSummary) float64{
+		"cpu":    func(h HostSummary) float64 { return h.CPUUsage },
+		"memory": func(h HostSummary) float64 { return h.MemoryUsage },
+		"disk":   func(h HostSummary) float64 { return h.DiskUsage },
+	} {
+		ranked := append([]HostSummary{}, up...)
+		sort.SliceStable(ranked, func(i, j int) bool { return value(ranked[i]) > value(ranked[j]) })
+		if len(ranked) > top {
+			ranked = ranked[:top]
+		}
+		view.Top[key] = ranked
+	}
+	return view
+}
+
+// Host returns the summary and latest metrics of one host, looked up by
+// hostname or, for polled peers, by the host:port they are polled at.
+func (a *aggregator) Host(name string) (*HostSummary, map[string]float64, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, h := range a.hosts {
+		if h.name == name || (h.addr != "" && h.addr == name) {
+			summary := a.summarize(h, time.Now())
+			return &summary, h.values, true
+		}
+	}
+	return nil, nil, false
+}
+
+// summarize must be called with a.mu held.
+func (a *aggregator) summarize(h *fleetHost, now time.Time) HostSummary {
+	s := HostSummary{Host: h.name, URL: h.url, Source: h.source, Status: HostUp, Error: h.lastErr}
+	if !h.lastSeen.IsZero() {
+		s.LastSeen = h.lastSeen.UTC().Format(time.RFC3339)
+	}
+	switch {
+	case h.lastErr != "":
+		s.Status = HostUnreachable
+	case h.lastSeen.IsZero():
+		s.Status = HostUnreachable
+		s.Error = "not polled yet"
+	case now.Sub(h.lastSeen) > a.staleAfter:
+		s.Status = HostUnreachable
+		s.Error = fmt.Sprintf("no data for %s", now.Sub(h.lastSeen).Round(time.Second))
+	}
+
+	v := h.values
+	s.CPUUsage = v["cpu.usage"]
+	s.MemoryUsage = v["memory.usage_percentage"]
+	s.ProcessCount = int(v["process_count"])
+	s.Uptime = uint64(v["host_info.uptime"])
+	if load, ok := v["cpu.load_average[0]"]; ok {
+		s.LoadAverage = &load
+	}
+	for name, usage := range v {
+		if mount, ok := diskUsageMount(name); ok && (usage > s.DiskUsage || s.DiskMount == "") {
+			s.DiskUsage, s.DiskMount = usage, mount
+		}
+	}
+	return s
+}
+
+// diskUsageMount extracts the mount point from "disk[<mount>].usage_percentage".
+func diskUsageMount(name string) (string, bool) {
+	const prefix, suffix = "disk[", "].usage_percentage"
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return "", false
+	}
+	return name[len(prefix) : len(name)-len(suffix)], true
+}
+
+func handleFleet(w http.ResponseWriter, r *http.Request) {
+	top := defaultFleetTop
+	if v := r.URL.Query().Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, fmt.Sprintf("invalid top %q", v), http.StatusBadRequest)
+			return
+		}
+		top = n
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(fleet.View(top)); err != nil {
+		log.Printf("Error encoding response: %v", err)
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleFleetHost serves /fleet/hosts/{name}.
+func handleFleetHost(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/fleet/hosts/")
+	summary, values, ok := fleet.Host(name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("host %q not found", name), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"summary": summary,
+		"metrics": values,
+	}); err != nil {
+		log.Printf("Error encoding response: %v", err)
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleFleetPush accepts batches from monitors running with
+// PUSH_URL=http://<aggregator>/fleet/push and PUSH_FORMAT=json.
+func handleFleetPush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(fleet.pushToken)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	batch := &pushBatch{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxPushBodyBytes)).Decode(batch); err != nil {
+		http.Error(w, fmt.Sprintf("invalid batch: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := fleet.accept(batch, time.Now()); err == errTooManyHosts {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testAggregator(peers ...string) *aggregator {
+	a := &aggregator{hosts: make(map[string]*fleetHost), interval: 15 * time.Second, staleAfter: 45 * time.Second, acceptPush: true}
+	for _, peer := range peers {
+		a.peers = append(a.peers, peer)
+		a.hosts[peer] = &fleetHost{name: peer, url: "http://" + peer, addr: peer, source: "poll"}
+	}
+	return a
+}
+
+// testBatch returns a batch from host with one sample per timestamp, whose
+// cpu.usage is the sample's index.
+func testBatch(t *testing.T, host string, timestamps ...time.Time) *pushBatch {
+	t.Helper()
+	type sample struct {
+		Timestamp string             `json:"timestamp"`
+		Values    map[string]float64 `json:"values"`
+	}
+	var samples []sample
+	for i, ts := range timestamps {
+		samples = append(samples, sample{ts.UTC().Format(time.RFC3339Nano), map[string]float64{"cpu.usage": float64(i)}})
+	}
+	body, _ := json.Marshal(map[string]interface{}{"host": host, "samples": samples})
+	batch := &pushBatch{}
+	if err := json.Unmarshal(body, batch); err != nil {
+		t.Fatal(err)
+	}
+	return batch
+}
+
+func TestAggregatorAccept(t *testing.T) {
+	a := testAggregator()
+	now := time.Now()
+
+	batch := testBatch(t, "web1", now.Add(-2*time.Second), now.Add(-time.Second), now.Add(-3*time.Second))
+	if err := a.accept(batch, now); err != nil {
+		t.Fatal(err)
+	}
+	h := a.hosts["push:web1"]
+	if h == nil || h.values["cpu.usage"] != 1 || !h.lastSeen.Equal(now) {
+		t.Fatalf("host = %+v, want the newest sample received now", h)
+	}
+
+	// A replayed older batch refreshes liveness but not the values.
+	later := now.Add(time.Minute)
+	if err := a.accept(testBatch(t, "web1", now.Add(-time.Hour)), later); err != nil {
+		t.Fatal(err)
+	}
+	if h.values["cpu.usage"] != 1 || !h.lastSeen.Equal(later) {
+		t.Errorf("host = %+v after replay, want old values seen at %s", h, later)
+	}
+
+	if err := a.accept(&pushBatch{}, now); err == nil {
+		t.Error("expected an error for a batch without host")
+	}
+	bad := testBatch(t, "web2", now)
+	bad.Samples[0].Timestamp = "yesterday"
+	if err := a.accept(bad, now); err == nil {
+		t.Error("expected an error for an invalid timestamp")
+	}
+	if err := a.accept(&pushBatch{Host: "web3"}, now); err != nil || a.hosts["push:web3"] != nil {
+		t.Errorf("empty batch: err = %v, host created = %v", err, a.hosts["push:web3"] != nil)
+	}
+}
+
+func TestAggregatorAcceptLimitsAndForgetsHosts(t *testing.T) {
+	a := testAggregator("peer:9100")
+	now := time.Now()
+	old := now.Add(-forgetPushHostAfter - time.Minute)
+	if err := a.accept(testBatch(t, "gone", now), old); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < maxPushHosts; i++ {
+		if err := a.accept(testBatch(t, fmt.Sprintf("host%d", i), now), now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The silent host is forgotten, which makes room for one more.
+	if err := a.accept(testBatch(t, "new", now), now); err != nil {
+		t.Fatal(err)
+	}
+	if a.hosts["push:gone"] != nil || a.hosts["peer:9100"] == nil {
+		t.Error("expected only the silent push host to be forgotten")
+	}
+	if err := a.accept(testBatch(t, "one-too-many", now), now); err != errTooManyHosts {
+		t.Errorf("err = %v, want %v", err, errTooManyHosts)
+	}
+}
+
+func TestAggregatorStaleness(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		received time.Time
+		sampled  time.Time
+		status   string
+	}{
+		{"fresh", now, now, HostUp},
+		// Liveness follows when data arrived, not the sender's clock.
+		{"sender clock behind", now, now.Add(-time.Hour), HostUp},
+		{"sender clock ahead", now.Add(-time.Minute), now.Add(24 * time.Hour), HostUnreachable},
+		{"silent", now.Add(-time.Minute), now.Add(-time.Minute), HostUnreachable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := testAggregator()
+			if err := a.accept(testBatch(t, "web1", tt.sampled), tt.received); err != nil {
+				t.Fatal(err)
+			}
+			summary, _, ok := a.Host("web1")
+			if !ok {
+				t.Fatal("host not found")
+			}
+			if summary.Status != tt.status {
+				t.Errorf("status = %s (%s), want %s", summary.Status, summary.Error, tt.status)
+			}
+			if want := tt.received.UTC().Format(time.RFC3339); summary.LastSeen != want {
+				t.Errorf("last seen = %s, want %s", summary.LastSeen, want)
+			}
+		})
+	}
+}
+
+func TestAggregatorView(t *testing.T) {
+	a := testAggregator("peer:9100", "down:9100")
+	now := time.Now()
+	a.hosts["peer:9100"].name = "db1"
+	a.hosts["peer:9100"].lastSeen = now
+	a.hosts["peer:9100"].values = map[string]float64{
+		"cpu.usage": 90, "memory.usage_percentage": 10, "process_count": 42,
+		"disk[/].usage_percentage": 50, "disk[/data].usage_percentage": 80,
+	}
+	a.hosts["down:9100"].lastErr = "connection refused"
+	for host, cpu := range map[string]float64{"web1": 10, "web2": 50, "web3": 30} {
+		batch := testBatch(t, host, now)
+		batch.Samples[0].Values = map[string]float64{"cpu.usage": cpu, "memory.usage_percentage": 100 - cpu, "cpu.load_average[0]": 1.5}
+		if err := a.accept(batch, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.accept(testBatch(t, "web0", now), now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	view := a.View(2)
+	if view.Up != 4 || view.Unreachable != 2 {
+		t.Errorf("up = %d, unreachable = %d, want 4 and 2", view.Up, view.Unreachable)
+	}
+	var names []string
+	for _, h := range view.Hosts {
+		names = append(names, h.Host)
+	}
+	if want := []string{"db1", "down:9100", "web0", "web1", "web2", "web3"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("hosts = %v, want %v", names, want)
+	}
+	db := view.Hosts[0]
+	if db.Source != "poll" || db.DiskUsage != 80 || db.DiskMount != "/data" || db.ProcessCount != 42 || db.LoadAverage != nil {
+		t.Errorf("db1 = %+v", db)
+	}
+	if web := view.Hosts[3]; web.Source != "push" || web.LoadAverage == nil || *web.LoadAverage != 1.5 {
+		t.Errorf("web1 = %+v", web)
+	}
+
+	top := func(key string) []string {
+		var hosts []string
+		for _, h := range view.Top[key] {
+			hosts = append(hosts, h.Host)
+		}
+		return hosts
+	}
+	if got := top("cpu"); !reflect.DeepEqual(got, []string{"db1", "web2"}) {
+		t.Errorf("top cpu = %v", got)
+	}
+	if got := top("memory"); !reflect.DeepEqual(got, []string{"web1", "web3"}) {
+		t.Errorf("top memory = %v", got)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Alert states reported by /alerts.
+const (
+	AlertPending  = "pending"
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// resolvedRetention is how long resolved alerts stay visible at /alerts.
+const resolvedRetention = 15 * time.Minute
+
+// missingMetricExpiry is how long an alert's metric may be absent from
+// samples, e.g. because its collector timed out or a mount went away,
+// before the alert is given up on.
+const missingMetricExpiry = 5 * time.Minute
+
+// AlertRule is a threshold rule such as "memory.usage_percentage > 90 for 5m".
+type AlertRule struct {
+	Name        string            `json:"name"`
+	Expr        string            `json:"expr"`
+	Severity    string            `json:"severity,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+
+	metric    string
+	op        string
+	threshold float64
+	duration  time.Duration
+}
+
+// AlertRuleFile is the on-disk format loaded from ALERT_RULES_FILE.
+type AlertRuleFile struct {
+	Webhooks []string    `json:"webhooks"`
+	Rules    []AlertRule `json:"rules"`
+}
+
+type Alert struct {
+	Rule        string            `json:"rule"`
+	Metric      string            `json:"metric"`
+	Expr        string            `json:"expr"`
+	Severity    string            `json:"severity,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	State       string            `json:"state"`
+	Value       float64           `json:"value"`
+	ActiveSince string            `json:"active_since"`
+	FiredAt     string            `json:"fired_at,omitempty"`
+	ResolvedAt  string            `json:"resolved_at,omitempty"`
+	// ResolvedReason is "metric missing" when the alert expired because its
+	// metric stopped being reported rather than because it recovered.
+	ResolvedReason string `json:"resolved_reason,omitempty"`
+
+	activeSince time.Time
+	lastSeen    time.Time
+	resolvedAt  time.Time
+}
+
+var ruleExpr = regexp.MustCompile(`^\s*(\S+?)\s*(>=|<=|==|!=|>|<)\s*(\S+)\s*(?:for\s+(\S+))?\s*$`)
+
+// parse validates the rule and fills in its parsed expression.
+func (r *AlertRule) parse() error {
+	if r.Name == "" {
+		return fmt.Errorf("rule name is required")
+	}
+	m := ruleExpr.FindStringSubmatch(r.Expr)
+	if m == nil {
+		return fmt.Errorf("rule %s: invalid expression %q, expected \"<metric> <op> <value> [for <duration>]\"", r.Name, r.Expr)
+	}
+	threshold, err := strconv.ParseFloat(m[3], 64)
+	if err != nil {
+		return fmt.Errorf("rule %s: invalid threshold %q", r.Name, m[3])
+	}
+	var duration time.Duration
+	if m[4] != "" {
+		if duration, err = time.ParseDuration(m[4]); err != nil || duration < 0 {
+			return fmt.Errorf("rule %s: invalid duration %q", r.Name, m[4])
+		}
+	}
+	if strings.Count(m[1], "[*]") > 1 {
+		return fmt.Errorf("rule %s: only one [*] wildcard is supported", r.Name)
+	}
+	r.metric, r.op, r.threshold, r.duration = m[1], m[2], threshold, duration
+	return nil
+}
+
+// matches reports whether a flattened metric name is selected by the rule.
+// A "[*]" in the rule metric matches any single index, e.g. disk[*].used.
+func (r *AlertRule) matches(name string) bool {
+	prefix, suffix, wildcard := strings.Cut(r.metric, "[*]")
+	if !wildcard {
+		return name == r.metric
+	}
+	if !strings.HasPrefix(name, prefix+"[") || !strings.HasSuffix(name, "]"+suffix) {
+		return false
+	}
+	return len(name) >= len(prefix)+len(suffix)+2
+}
+
+func (r *AlertRule) holds(v float64) bool {
+	switch r.op {
+	case ">":
+		return v > r.threshold
+	case ">=":
+		return v >= r.threshold
+	case "<":
+		return v < r.threshold
+	case "<=":
+		return v <= r.threshold
+	case "==":
+		return v == r.threshold
+	case "!=":
+		return v != r.threshold
+	}
+	return false
+}
+
+// loadAlertRules reads and validates a rule file.
+func loadAlertRules(path string) (*AlertRuleFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading alert rules: %v", err)
+	}
+	var file AlertRuleFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing alert rules: %v", err)
+	}
+
+	names := make(map[string]bool)
+	for i := range file.Rules {
+		if err := file.Rules[i].parse(); err != nil {
+			return nil, err
+		}
+		if names[file.Rules[i].Name] {
+			return nil, fmt.Errorf("duplicate rule name %s", file.Rules[i].Name)
+		}
+		names[file.Rules[i].Name] = true
+	}
+	for _, hook := range file.Webhooks {
+		if err := validateWebhook(hook); err != nil {
+			return nil, err
+		}
+	}
+	return &file, nil
+}
+
+func validateWebhook(hook string) error {
+	u, err := url.Parse(hook)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL %q", hook)
+	}
+	return nil
+}
+
+// alertManager evaluates rules against every sample and tracks alert state.
+type alertManager struct {
+	rules    []AlertRule
+	webhooks []string
+	client   *http.Client
+
+	mu     sync.RWMutex
+	alerts map[string]*Alert
+}
+
+func newAlertManager(rules []AlertRule, webhooks []string) *alertManager {
+	return &alertManager{
+		rules:    rules,
+		webhooks: webhooks,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		alerts:   make(map[string]*Alert),
+	}
+}
+
+// Evaluate is registered as a sampler handler.
+func (m *alertManager) Evaluate(sample *Sample) {
+	now := sample.Time
+	var changed []Alert
+
+	m.mu.Lock()
+	// present holds every rule/metric pair reported in this sample, holds
+	// the ones whose condition is true.
+	present := make(map[string]bool)
+	holds := make(map[string]bool)
+	for i := range m.rules {
+		rule := &m.rules[i]
+		for name, v := range sample.Values {
+			if !rule.matches(name) {
+				continue
+			}
+			key := rule.Name + "/" + name
+			present[key] = true
+			if !rule.holds(v) {
+				continue
+			}
+			holds[key] = true
+
+			alert, ok := m.alerts[key]
+			if !ok || alert.State == AlertResolved {
+				alert = &Alert{
+					Rule:        rule.Name,
+					Metric:      name,
+					Expr:        rule.Expr,
+					Severity:    rule.Severity,
+					Description: rule.Description,
+					Labels:      rule.Labels,
+					State:       AlertPending,
+					activeSince: now,
+					ActiveSince: now.UTC().Format(time.RFC3339),
+				}
+				m.alerts[key] = alert
+			}
+			alert.Value = v
+			alert.lastSeen = now
+			if alert.State == AlertPending && now.Sub(alert.activeSince) >= rule.duration {
+				alert.State = AlertFiring
+				alert.FiredAt = now.UTC().Format(time.RFC3339)
+				changed = append(changed, *alert)
+			}
+		}
+	}
+
+	for key, alert := range m.alerts {
+		if holds[key] {
+			continue
+		}
+		// A missing metric says nothing about the condition, so the alert
+		// keeps its state until the metric has been gone for a while.
+		var reason string
+		if !present[key] && alert.State != AlertResolved {
+			if now.Sub(alert.lastSeen) < missingMetricExpiry {
+				continue
+			}
+			reason = "metric missing"
+		}
+		switch alert.State {
+		case AlertPending:
+			delete(m.alerts, key)
+		case AlertFiring:
+			alert.State = AlertResolved
+			alert.resolvedAt = now
+			alert.ResolvedAt = now.UTC().Format(time.RFC3339)
+			alert.ResolvedReason = reason
+			changed = append(changed, *alert)
+		case AlertResolved:
+			if now.Sub(alert.resolvedAt) > resolvedRetention {
+				delete(m.alerts, key)
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	if len(changed) > 0 {
+		go m.notify(changed)
+	}
+}
+
+// Alerts returns the tracked alerts, optionally filtered by state.
+func (m *alertManager) Alerts(state string) []Alert {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	alerts := make([]Alert, 0, len(m.alerts))
+	for _, alert := range m.alerts {
+		if state == "" || alert.State == state {
+			alerts = append(alerts, *alert)
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Rule != alerts[j].Rule {
+			return alerts[i].Rule < alerts[j].Rule
+		}
+		return alerts[i].Metric < alerts[j].Metric
+	})
+	return alerts
+}
+
+// notify posts alert transitions to every configured webhook.
+func (m *alertManager) notify(alerts []Alert) {
+	body, err := json.Marshal(map[string]interface{}{
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"alerts":    alerts,
+	})
+	if err != nil {
+		log.Printf("Error encoding alert notification: %v", err)
+		return
+	}
+
+	for _, hook := range m.webhooks {
+		resp, err := m.client.Post(hook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Warning: Could not notify webhook %s: %v", hook, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("Warning: Webhook %s returned %s", hook, resp.Status)
+		}
+	}
+}
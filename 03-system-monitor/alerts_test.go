@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlertSurvivesMissingMetric(t *testing.T) {
+	rule := AlertRule{Name: "HighMemory", Expr: "memory.usage_percentage > 90"}
+	if err := rule.parse(); err != nil {
+		t.Fatal(err)
+	}
+	m := newAlertManager([]AlertRule{rule}, nil)
+	start := time.Now()
+	state := func() string {
+		alerts := m.Alerts("")
+		if len(alerts) == 0 {
+			return ""
+		}
+		return alerts[0].State
+	}
+
+	m.Evaluate(&Sample{Time: start, Values: map[string]float64{"memory.usage_percentage": 95}})
+	if got := state(); got != AlertFiring {
+		t.Fatalf("state = %q, want firing", got)
+	}
+
+	// The memory collector timed out: the alert must not resolve.
+	m.Evaluate(&Sample{Time: start.Add(time.Second), Values: map[string]float64{"cpu.usage": 10}})
+	if got := state(); got != AlertFiring {
+		t.Fatalf("state after a missing sample = %q, want firing", got)
+	}
+
+	// Gone for longer than missingMetricExpiry: the alert expires.
+	m.Evaluate(&Sample{Time: start.Add(missingMetricExpiry + time.Second), Values: map[string]float64{}})
+	alerts := m.Alerts(AlertResolved)
+	if len(alerts) != 1 || alerts[0].ResolvedReason != "metric missing" {
+		t.Fatalf("alerts = %+v, want one resolved with reason \"metric missing\"", alerts)
+	}
+}
+
+func TestAlertResolvesWhenConditionStops(t *testing.T) {
+	rule := AlertRule{Name: "RootDiskFull", Expr: "disk[*].usage_percentage > 85"}
+	if err := rule.parse(); err != nil {
+		t.Fatal(err)
+	}
+	m := newAlertManager([]AlertRule{rule}, nil)
+	start := time.Now()
+
+	m.Evaluate(&Sample{Time: start, Values: map[string]float64{"disk[/].usage_percentage": 90}})
+	m.Evaluate(&Sample{Time: start.Add(time.Second), Values: map[string]float64{"disk[/].usage_percentage": 50}})
+	alerts := m.Alerts(AlertResolved)
+	if len(alerts) != 1 || alerts[0].ResolvedReason != "" {
+		t.Fatalf("alerts = %+v, want one resolved without a reason", alerts)
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bufio"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nearLimitPercentage is the share of a cgroup limit above which the
+// resource is reported in ContainerStats.NearLimits.
+const nearLimitPercentage = 90
+
+// cgroupV1Unlimited is the value cgroup v1 reports for "no limit", rounded
+// down to the page size. That is 4 KiB on most hosts but 64 KiB on some arm64
+// kernels, so anything at or above the 64 KiB-aligned value is treated as
+// unlimited.
+const cgroupV1Unlimited = math.MaxInt64 &^ (64<<10 - 1)
+
+// ContainerStats is the resource usage of one container cgroup.
+type ContainerStats struct {
+	ID         string       `json:"id,omitempty"`
+	Cgroup     string       `json:"cgroup"`
+	Version    int          `json:"cgroup_version"`
+	CPU        CgroupCPU    `json:"cpu"`
+	Memory     CgroupMemory `json:"memory"`
+	IO         CgroupIO     `json:"io"`
+	PIDs       CgroupPIDs   `json:"pids"`
+	Processes  []int32      `json:"processes,omitempty"`
+	NearLimits []string     `json:"near_limits,omitempty"`
+}
+
+type CgroupCPU struct {
+	UsageSeconds     float64 `json:"usage_seconds"`
+	UsagePercent     float64 `json:"usage_percent"`
+	LimitCores       float64 `json:"limit_cores,omitempty"`
+	LimitPercentage  float64 `json:"limit_percentage,omitempty"`
+	ThrottledPeriods uint64  `json:"throttled_periods"`
+	ThrottledSeconds float64 `json:"throttled_seconds"`
+}
+
+type CgroupMemory struct {
+	// Usage excludes the inactive page cache.
+	Usage           uint64  `json:"usage"`
+	Limit           uint64  `json:"limit,omitempty"`
+	LimitPercentage float64 `json:"limit_percentage,omitempty"`
+	Cache           uint64  `json:"cache"`
+	RSS             uint64  `json:"rss"`
+	Swap            uint64  `json:"swap"`
+}
+
+type CgroupIO struct {
+	ReadBytes      uint64  `json:"read_bytes"`
+	WriteBytes     uint64  `json:"write_bytes"`
+	ReadOps        uint64  `json:"read_ops"`
+	WriteOps       uint64  `json:"write_ops"`
+	ReadBytesRate  float64 `json:"read_bytes_per_sec"`
+	WriteBytesRate float64 `json:"write_bytes_per_sec"`
+}
+
+type CgroupPIDs struct {
+	Current         uint64  `json:"current"`
+	Limit           uint64  `json:"limit,omitempty"`
+	LimitPercentage float64 `json:"limit_percentage,omitempty"`
+}
+
+// containerIDPattern matches the 64 character IDs used by Docker,
+// containerd and podman in cgroup paths such as /docker/<id> or
+// /system.slice/docker-<id>.scope.
+var containerIDPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// cgroupRates tracks cgroup CPU and I/O counters between collections.
+var cgroupRates = newCounterRates()
+
+// cgroupRoot returns the mounted cgroup filesystem. CGROUP_ROOT overrides it,
+// which is mainly useful to point the monitor at a fixture directory.
+func cgroupRoot() string {
+	if root := os.Getenv("CGROUP_ROOT"); root != "" {
+		return root
+	}
+	return sysPath("fs", "cgroup")
+}
+
+// containerIDFromCgroup extracts the container ID from a cgroup path.
+func containerIDFromCgroup(path string) string {
+	ids := containerIDPattern.FindAllString(path, -1)
+	if len(ids) == 0 {
+		return ""
+	}
+	return ids[len(ids)-1]
+}
+
+// cgroupHierarchy knows where each controller lives for one cgroup version.
+type cgroupHierarchy struct {
+	version     int
+	root        string
+	controllers map[string]string
+}
+
+func detectCgroupHierarchy() *cgroupHierarchy {
+	root := cgroupRoot()
+	if fileExists(filepath.Join(root, "cgroup.controllers")) {
+		return &cgroupHierarchy{version: 2, root: root}
+	}
+
+	h := &cgroupHierarchy{version: 1, root: root, controllers: make(map[string]string)}
+	candidates := map[string][]string{
+		"memory":  {"memory"},
+		"cpu":     {"cpu", "cpu,cpuacct", "cpuacct,cpu"},
+		"cpuacct": {"cpuacct", "cpu,cpuacct", "cpuacct,cpu"},
+		"blkio":   {"blkio"},
+		"pids":    {"pids"},
+	}
+	for controller, dirs := range candidates {
+		for _, dir := range dirs {
+			if fileExists(filepath.Join(root, dir)) {
+				h.controllers[controller] = filepath.Join(root, dir)
+				break
+			}
+		}
+	}
+	if _, ok := h.controllers["memory"]; !ok {
+		return nil
+	}
+	return h
+}
+
+// walkRoot is the tree searched for cgroups: the unified hierarchy on v2,
+// the memory controller on v1.
+func (h *cgroupHierarchy) walkRoot() string {
+	if h.version == 2 {
+		return h.root
+	}
+	return h.controllers["memory"]
+}
+
+// dir returns the directory of cgroup path for a controller.
+func (h *cgroupHierarchy) dir(controller, path string) string {
+	if h.version == 2 {
+		return filepath.Join(h.root, path)
+	}
+	base, ok := h.controllers[controller]
+	if !ok {
+		return ""
+	}
+	return filepath.Join(base, path)
+}
+
+// getContainerStats reports every container cgroup, or every cgroup when all
+// is set.
+func getContainerStats(all bool) ([]ContainerStats, error) {
+	h := detectCgroupHierarchy()
+	if h == nil {
+		return nil, nil
+	}
+
+	now := time.Now()
+	var stats []ContainerStats
+	root := h.walkRoot()
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Cgroups come and go while we walk; skip what disappeared.
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		cgroup := "/" + strings.TrimPrefix(filepath.ToSlash(rel), ".")
+		cgroup = strings.TrimSuffix(cgroup, "/")
+		if cgroup == "" {
+			cgroup = "/"
+		}
+
+		id := containerIDFromCgroup(cgroup)
+		if id == "" && !all {
+			return nil
+		}
+		stats = append(stats, h.read(cgroup, id, now))
+		if id != "" {
+			// Nested cgroups belong to the same container.
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Without all, only container cgroups were visited, so only their
+	// readings can be stale.
+	cgroupRates.Prune(now, func(key string) bool {
+		if all {
+			return false
+		}
+		cgroup := strings.TrimPrefix(strings.TrimPrefix(key, "cgroup/cpu/"), "cgroup/io/")
+		return containerIDFromCgroup(cgroup) == ""
+	})
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Cgroup < stats[j].Cgroup })
+	return stats, nil
+}
+
+// read collects the statistics of a single cgroup.
+func (h *cgroupHierarchy) read(cgroup, id string, now time.Time) ContainerStats {
+	s := ContainerStats{ID: id, Cgroup: cgroup, Version: h.version}
+	if h.version == 2 {
+		h.readV2(&s)
+	} else {
+		h.readV1(&s)
+	}
+
+	cpuRates := cgroupRates.Rates("cgroup/cpu/"+cgroup, now, uint64(s.CPU.UsageSeconds*1e6))
+	s.CPU.UsagePercent = cpuRates[0] / 1e6 * 100
+	if s.CPU.LimitCores > 0 {
+		s.CPU.LimitPercentage = s.CPU.UsagePercent / s.CPU.LimitCores
+	}
+	ioRates := cgroupRates.Rates("cgroup/io/"+cgroup, now, s.IO.ReadBytes, s.IO.WriteBytes)
+	s.IO.ReadBytesRate, s.IO.WriteBytesRate = ioRates[0], ioRates[1]
+
+	if s.Memory.Limit > 0 {
+		s.Memory.LimitPercentage = float64(s.Memory.Usage) / float64(s.Memory.Limit) * 100
+	}
+	if s.PIDs.Limit > 0 {
+		s.PIDs.LimitPercentage = float64(s.PIDs.Current) / float64(s.PIDs.Limit) * 100
+	}
+
+	if s.CPU.LimitPercentage >= nearLimitPercentage {
+		s.NearLimits = append(s.NearLimits, "cpu")
+	}
+	if s.Memory.LimitPercentage >= nearLimitPercentage {
+		s.NearLimits = append(s.NearLimits, "memory")
+	}
+	if s.PIDs.LimitPercentage >= nearLimitPercentage {
+		s.NearLimits = append(s.NearLimits, "pids")
+	}
+	return s
+}
+
+// workingSet subtracts the inactive page cache, which the kernel reclaims
+// before it hits the limit, from a cgroup's memory usage, so file I/O alone
+// does not make a container look close to its memory limit.
+func workingSet(usage, inactiveFile uint64) uint64 {
+	if inactiveFile > usage {
+		return 0
+	}
+	return usage - inactiveFile
+}
+
+func (h *cgroupHierarchy) readV2(s *ContainerStats) {
+	dir := h.dir("", s.Cgroup)
+
+	cpuStat := readKeyValues(filepath.Join(dir, "cpu.stat"))
+	s.CPU.UsageSeconds = float64(cpuStat["usage_usec"]) / 1e6
+	s.CPU.ThrottledPeriods = cpuStat["nr_throttled"]
+	s.CPU.ThrottledSeconds = float64(cpuStat["throttled_usec"]) / 1e6
+	if fields := strings.Fields(readString(filepath.Join(dir, "cpu.max"))); len(fields) == 2 && fields[0] != "max" {
+		quota, _ := strconv.ParseFloat(fields[0], 64)
+		period, _ := strconv.ParseFloat(fields[1], 64)
+		if period > 0 {
+			s.CPU.LimitCores = quota / period
+		}
+	}
+
+	memStat := readKeyValues(filepath.Join(dir, "memory.stat"))
+	s.Memory.Usage = workingSet(readUint(filepath.Join(dir, "memory.current")), memStat["inactive_file"])
+	s.Memory.Limit = readUint(filepath.Join(dir, "memory.max"))
+	s.Memory.Swap = readUint(filepath.Join(dir, "memory.swap.current"))
+	s.Memory.Cache = memStat["file"]
+	s.Memory.RSS = memStat["anon"]
+
+	// io.stat has one line per device: "8:0 rbytes=1 wbytes=2 rios=3 wios=4 ..."
+	for _, line := range readLines(filepath.Join(dir, "io.stat")) {
+		for _, field := range strings.Fields(line)[1:] {
+			key, value, _ := strings.Cut(field, "=")
+			n, _ := strconv.ParseUint(value, 10, 64)
+			switch key {
+			case "rbytes":
+				s.IO.ReadBytes += n
+			case "wbytes":
+				s.IO.WriteBytes += n
+			case "rios":
+				s.IO.ReadOps += n
+			case "wios":
+				s.IO.WriteOps += n
+			}
+		}
+	}
+
+	s.PIDs.Current = readUint(filepath.Join(dir, "pids.current"))
+	s.PIDs.Limit = readUint(filepath.Join(dir, "pids.max"))
+	s.Processes = readPIDs(filepath.Join(dir, "cgroup.procs"))
+}
+
+func (h *cgroupHierarchy) readV1(s *ContainerStats) {
+	if dir := h.dir("cpuacct", s.Cgroup); dir != "" {
+		s.CPU.UsageSeconds = float64(readUint(filepath.Join(dir, "cpuacct.usage"))) / 1e9
+	}
+	if dir := h.dir("cpu", s.Cgroup); dir != "" {
+		cpuStat := readKeyValues(filepath.Join(dir, "cpu.stat"))
+		s.CPU.ThrottledPeriods = cpuStat["nr_throttled"]
+		s.CPU.ThrottledSeconds = float64(cpuStat["throttled_time"]) / 1e9
+		quota, _ := strconv.ParseFloat(readString(filepath.Join(dir, "cpu.cfs_quota_us")), 64)
+		period, _ := strconv.ParseFloat(readString(filepath.Join(dir, "cpu.cfs_period_us")), 64)
+		if quota > 0 && period > 0 {
+			s.CPU.LimitCores = quota / period
+		}
+	}
+
+	dir := h.dir("memory", s.Cgroup)
+	memStat := readKeyValues(filepath.Join(dir, "memory.stat"))
+	s.Memory.Usage = workingSet(readUint(filepath.Join(dir, "memory.usage_in_bytes")), memStat["total_inactive_file"])
+	if limit := readUint(filepath.Join(dir, "memory.limit_in_bytes")); limit < cgroupV1Unlimited {
+		s.Memory.Limit = limit
+	}
+	s.Memory.Cache = memStat["cache"]
+	s.Memory.RSS = memStat["rss"]
+	s.Memory.Swap = memStat["swap"]
+	s.Processes = readPIDs(filepath.Join(dir, "cgroup.procs"))
+
+	if dir := h.dir("blkio", s.Cgroup); dir != "" {
+		// Lines look like "8:0 Read 1234"; the last line is a "Total".
+		sum := func(file string) (read, write uint64) {
+			for _, line := range readLines(filepath.Join(dir, file)) {
+				fields := strings.Fields(line)
+				if len(fields) != 3 {
+					continue
+				}
+				n, _ := strconv.ParseUint(fields[2], 10, 64)
+				switch fields[1] {
+				case "Read":
+					read += n
+				case "Write":
+					write += n
+				}
+			}
+			return read, write
+		}
+		s.IO.ReadBytes, s.IO.WriteBytes = sum("blkio.throttle.io_service_bytes")
+		s.IO.ReadOps, s.IO.WriteOps = sum("blkio.throttle.io_serviced")
+	}
+
+	if dir := h.dir("pids", s.Cgroup); dir != "" {
+		s.PIDs.Current = readUint(filepath.Join(dir, "pids.current"))
+		s.PIDs.Limit = readUint(filepath.Join(dir, "pids.max"))
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func readString(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// readUint reads a single number from a cgroup file. "max" and missing files
+// read as zero, which callers treat as unlimited or unknown.
+func readUint(path string) uint64 {
+	n, _ := strconv.ParseUint(readString(path), 10, 64)
+	return n
+}
+
+func readLines(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// readKeyValues parses "key value" lines such as cpu.stat and memory.stat.
+func readKeyValues(path string) map[string]uint64 {
+	values := make(map[string]uint64)
+	for _, line := range readLines(path) {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if n, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = n
+		}
+	}
+	return values
+}
+
+func readPIDs(path string) []int32 {
+	var pids []int32
+	for _, line := range readLines(path) {
+		if n, err := strconv.ParseInt(line, 10, 32); err == nil {
+			pids = append(pids, int32(n))
+		}
+	}
+	return pids
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var (
+	fixtureIDA = strings.Repeat("a", 64)
+	fixtureIDB = strings.Repeat("b", 63) + "c"
+)
+
+func TestContainerIDFromCgroup(t *testing.T) {
+	id := "3f1c0e6a9b2d4c5e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e"
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/docker/" + id, id},
+		{"/system.slice/docker-" + id + ".scope", id},
+		{"/kubepods/burstable/pod1234/" + id, id},
+		{"/machine.slice/libpod-" + id + ".scope/container", id},
+		{"/user.slice/user-1000.slice/session-2.scope", ""},
+		{"/docker/" + id[:12], ""},
+		{"/", ""},
+	}
+	for _, tt := range tests {
+		if got := containerIDFromCgroup(tt.path); got != tt.want {
+			t.Errorf("containerIDFromCgroup(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func containerStatsByID(t *testing.T, root string, all bool) map[string]ContainerStats {
+	t.Helper()
+	t.Setenv("CGROUP_ROOT", root)
+	stats, err := getContainerStats(all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byCgroup := make(map[string]ContainerStats)
+	for _, s := range stats {
+		byCgroup[s.Cgroup] = s
+	}
+	return byCgroup
+}
+
+func TestCgroupV2Fixture(t *testing.T) {
+	stats := containerStatsByID(t, "testdata/cgroup/v2", false)
+	if len(stats) != 1 {
+		t.Fatalf("got %d containers, want 1: %v", len(stats), stats)
+	}
+	s, ok := stats["/system.slice/docker-"+fixtureIDA+".scope"]
+	if !ok {
+		t.Fatalf("container cgroup not found: %v", stats)
+	}
+
+	want := ContainerStats{
+		ID:      fixtureIDA,
+		Cgroup:  "/system.slice/docker-" + fixtureIDA + ".scope",
+		Version: 2,
+		CPU: CgroupCPU{
+			UsageSeconds:     2.5,
+			LimitCores:       1.5,
+			ThrottledPeriods: 7,
+			ThrottledSeconds: 0.35,
+		},
+		// memory.current is at the limit, but half of it is inactive page
+		// cache, so the container is not near its memory limit.
+		Memory: CgroupMemory{
+			Usage:           268435456,
+			Limit:           536870912,
+			LimitPercentage: 50,
+			Cache:           300000000,
+			RSS:             200000000,
+			Swap:            4096,
+		},
+		IO:         CgroupIO{ReadBytes: 1500, WriteBytes: 2000, ReadOps: 15, WriteOps: 20},
+		PIDs:       CgroupPIDs{Current: 95, Limit: 100, LimitPercentage: 95},
+		Processes:  []int32{1234, 1240},
+		NearLimits: []string{"pids"},
+	}
+	// Rates depend on earlier collections.
+	s.CPU.UsagePercent, s.IO.ReadBytesRate, s.IO.WriteBytesRate = 0, 0, 0
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("got  %+v\nwant %+v", s, want)
+	}
+}
+
+func TestCgroupV2FixtureAll(t *testing.T) {
+	stats := containerStatsByID(t, "testdata/cgroup/v2", true)
+	for _, cgroup := range []string{"/", "/system.slice", "/user.slice", "/system.slice/docker-" + fixtureIDA + ".scope"} {
+		if _, ok := stats[cgroup]; !ok {
+			t.Errorf("cgroup %s missing", cgroup)
+		}
+	}
+	// Cgroups nested in a container belong to it.
+	if _, ok := stats["/system.slice/docker-"+fixtureIDA+".scope/nested"]; ok {
+		t.Error("nested container cgroup reported separately")
+	}
+	// "max" limits read as unlimited.
+	if user := stats["/user.slice"]; user.Memory.Limit != 0 || user.PIDs.Limit != 0 || user.Memory.Usage != 1048576 {
+		t.Errorf("/user.slice = %+v", user)
+	}
+}
+
+func TestCgroupV1Fixture(t *testing.T) {
+	stats := containerStatsByID(t, "testdata/cgroup/v1", false)
+	if len(stats) != 2 {
+		t.Fatalf("got %d containers, want 2: %v", len(stats), stats)
+	}
+
+	a := stats["/docker/"+fixtureIDA]
+	if a.ID != fixtureIDA || a.Version != 1 {
+		t.Errorf("ID = %q, version = %d", a.ID, a.Version)
+	}
+	if a.CPU.UsageSeconds != 3 || a.CPU.LimitCores != 0.5 || a.CPU.ThrottledPeriods != 2 || a.CPU.ThrottledSeconds != 0.5 {
+		t.Errorf("cpu = %+v", a.CPU)
+	}
+	// The v1 "no limit" value must not be reported as a limit.
+	if a.Memory.Usage != 104857600 || a.Memory.Limit != 0 || a.Memory.LimitPercentage != 0 || a.Memory.RSS != 100000000 || a.Memory.Cache != 4096 {
+		t.Errorf("memory = %+v", a.Memory)
+	}
+	if a.IO.ReadBytes != 4096 || a.IO.WriteBytes != 8192 || a.IO.ReadOps != 1 || a.IO.WriteOps != 2 {
+		t.Errorf("io = %+v", a.IO)
+	}
+	if a.PIDs.Current != 3 || a.PIDs.Limit != 0 || !reflect.DeepEqual(a.Processes, []int32{42}) {
+		t.Errorf("pids = %+v, processes = %v", a.PIDs, a.Processes)
+	}
+
+	b := stats["/docker/"+fixtureIDB]
+	// Without the inactive page cache the container is still near its limit.
+	if b.Memory.Usage != 1000000000 || b.Memory.Limit != 1073741824 || b.Memory.Swap != 8192 || !reflect.DeepEqual(b.NearLimits, []string{"memory"}) {
+		t.Errorf("memory = %+v, near limits = %v", b.Memory, b.NearLimits)
+	}
+	// A quota of -1 means no CPU limit.
+	if b.CPU.UsageSeconds != 1 || b.CPU.LimitCores != 0 {
+		t.Errorf("cpu = %+v", b.CPU)
+	}
+}
+
+func TestCgroupV1MemoryLimit(t *testing.T) {
+	tests := []struct {
+		limit string
+		want  uint64
+	}{
+		{"9223372036854771712", 0}, // no limit, 4 KiB pages
+		{"9223372036854710272", 0}, // no limit, 64 KiB pages
+		{"1073741824", 1073741824},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		root := t.TempDir()
+		dir := filepath.Join(root, "memory", "docker", fixtureIDA)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if tt.limit != "" {
+			if err := os.WriteFile(filepath.Join(dir, "memory.limit_in_bytes"), []byte(tt.limit+"\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s := containerStatsByID(t, root, false)["/docker/"+fixtureIDA]
+		if s.Memory.Limit != tt.want {
+			t.Errorf("memory.limit_in_bytes %q: limit = %d, want %d", tt.limit, s.Memory.Limit, tt.want)
+		}
+	}
+}
@@ -0,0 +1,532 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+	"gopkg.in/yaml.v3"
+)
+
+const cliUsage = `Usage: system-monitor [command] [flags]
+
+Without a command, system-monitor runs the HTTP server.
+
+Commands:
+  snapshot   Collect metrics once and print them
+  top        Show the busiest processes, refreshing until interrupted
+  query      Read an endpoint of a running monitor
+  help       Show this help
+
+Run "system-monitor <command> -h" for the flags of a command.
+`
+
+// Output formats of the CLI commands.
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatYAML  = "yaml"
+)
+
+// runCLI runs a CLI command and returns the process exit code: 0 on
+// success, 1 on failure and 2 for invalid usage.
+func runCLI(args []string) int {
+	var err error
+	switch args[0] {
+	case "snapshot":
+		err = runSnapshot(args[1:])
+	case "top":
+		err = runTop(args[1:])
+	case "query":
+		err = runQuery(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(cliUsage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], cliUsage)
+		return 2
+	}
+	switch err {
+	case nil:
+		return 0
+	case flag.ErrHelp:
+		return 0
+	case errUsage:
+		return 2
+	}
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	return 1
+}
+
+// errUsage is returned once a flag error has been reported.
+var errUsage = fmt.Errorf("invalid usage")
+
+// parseFlags parses a command's flags, printing errors and usage to stderr.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(os.Stderr)
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return errUsage
+	}
+	return nil
+}
+
+func validateFormat(format string) error {
+	switch format {
+	case FormatTable, FormatJSON, FormatYAML:
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "invalid format %q, expected table, json or yaml\n", format)
+	return errUsage
+}
+
+// runSnapshot collects metrics locally, the same way the server does.
+func runSnapshot(args []string) error {
+	fs := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	format := fs.String("format", FormatTable, "output format: table, json or yaml")
+	interval := fs.Duration("interval", time.Second, "time between two collections used for CPU usage and rates; 0 collects once")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := validateFormat(*format); err != nil {
+		return err
+	}
+	if err := loadCollectionConfig(); err != nil {
+		return err
+	}
+
+	if *interval > 0 {
+		// CPU usage and counter rates are measured since the previous
+		// collection, so a first one is needed for them to mean anything.
+		if _, err := getSystemStats(); err != nil {
+			return err
+		}
+		time.Sleep(*interval)
+	}
+	stats, err := getSystemStats()
+	if err != nil {
+		return err
+	}
+	return writeOutput(os.Stdout, *format, stats, func(w io.Writer) { writeStatsTable(w, stats) })
+}
+
+// runQuery reads an endpoint of a remote monitor and prints it.
+func runQuery(args []string) error {
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	host := fs.String("host", "localhost:8080", "monitor to query, as host:port or URL")
+	format := fs.String("format", "", "output format: table, json or yaml (default table for /metrics and /processes, json otherwise)")
+	timeout := fs.Duration("timeout", 10*time.Second, "request timeout")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: system-monitor query [flags] [path]\n\nPath defaults to /metrics, e.g. /processes?sort=memory or /alerts.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	path := "/metrics"
+	if fs.NArg() > 0 {
+		// Flags may also follow the path.
+		path = fs.Arg(0)
+		if err := parseFlags(fs, fs.Args()[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() > 0 {
+			fs.Usage()
+			return errUsage
+		}
+	}
+	endpoint, _, _ := strings.Cut(path, "?")
+	tabular := endpoint == "/metrics" || endpoint == "/processes"
+	if *format == "" {
+		*format = FormatJSON
+		if tabular {
+			*format = FormatTable
+		}
+	}
+	if err := validateFormat(*format); err != nil {
+		return err
+	}
+	if *format == FormatTable && !tabular {
+		fmt.Fprintf(os.Stderr, "table format is only available for /metrics and /processes\n")
+		return errUsage
+	}
+
+	client := newMonitorClient(*host, *timeout)
+	data, err := client.get(path)
+	if err != nil {
+		return err
+	}
+	switch {
+	case *format != FormatTable:
+		return writeRawOutput(os.Stdout, *format, data)
+	case endpoint == "/metrics":
+		var stats SystemStats
+		if err := json.Unmarshal(data, &stats); err != nil {
+			return fmt.Errorf("error decoding %s: %v", path, err)
+		}
+		writeStatsTable(os.Stdout, &stats)
+	default:
+		var processes []ProcessStats
+		if err := json.Unmarshal(data, &processes); err != nil {
+			return fmt.Errorf("error decoding %s: %v", path, err)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		writeProcessTable(tw, processes)
+		tw.Flush()
+	}
+	return nil
+}
+
+// runTop shows the busiest processes of this host, or of a remote monitor
+// with -host, refreshing on an interval.
+func runTop(args []string) error {
+	fs := flag.NewFlagSet("top", flag.ContinueOnError)
+	host := fs.String("host", "", "monitor to read, as host:port or URL (default: this host)")
+	interval := fs.Duration("interval", 2*time.Second, "refresh interval")
+	limit := fs.Int("limit", 20, "number of processes to show")
+	sortBy := fs.String("sort", "cpu", "sort key: cpu, memory, rss, pid, name or create_time")
+	iterations := fs.Int("n", 0, "number of refreshes before exiting; 0 runs until interrupted")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if _, ok := processLess[*sortBy]; !ok {
+		fmt.Fprintf(os.Stderr, "invalid sort key %q\n", *sortBy)
+		return errUsage
+	}
+	if *limit < 1 || *limit > maxProcessLimit {
+		fmt.Fprintf(os.Stderr, "limit must be between 1 and %d\n", maxProcessLimit)
+		return errUsage
+	}
+	if *interval <= 0 {
+		fmt.Fprintf(os.Stderr, "interval must be positive\n")
+		return errUsage
+	}
+
+	var view func() (*SystemStats, []ProcessStats, error)
+	if *host != "" {
+		client := newMonitorClient(*host, *interval+5*time.Second)
+		view = func() (*SystemStats, []ProcessStats, error) {
+			return client.top(*sortBy, *limit)
+		}
+	} else {
+		if err := loadCollectionConfig(); err != nil {
+			return err
+		}
+		// CPU usage is measured since the previous call, so start the clock
+		// a moment before the first view.
+		if _, _, err := localTop(*sortBy, *limit); err != nil {
+			return err
+		}
+		time.Sleep(500 * time.Millisecond)
+		view = func() (*SystemStats, []ProcessStats, error) {
+			return localTop(*sortBy, *limit)
+		}
+	}
+
+	// Clearing the screen only makes sense on a terminal; piped output gets
+	// one view after the other.
+	clear := ""
+	if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		clear = "\033[H\033[2J"
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for i := 1; ; i++ {
+		stats, processes, err := view()
+		if err != nil {
+			return err
+		}
+		if clear != "" {
+			fmt.Print(clear)
+		} else if i > 1 {
+			fmt.Println()
+		}
+		writeTopView(os.Stdout, stats, processes, *interval)
+		if *iterations > 0 && i >= *iterations {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-interrupt:
+			return nil
+		}
+	}
+}
+
+// topCPU remembers the CPU time of every process between refreshes of top.
+var topCPU = newCounterRates()
+
+// localTop reads the summary collectors directly rather than running every
+// collector on each refresh. Process CPU usage is measured since the previous
+// refresh, not averaged over each process's lifetime, so top shows what is
+// busy now.
+func localTop(sortBy string, limit int) (*SystemStats, []ProcessStats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultCollectorTimeout)
+	defer cancel()
+	stats := &SystemStats{Timestamp: time.Now().UTC().Format(time.RFC3339)}
+	for _, collect := range []func(context.Context, *SystemStats) error{collectHost, collectCPU, collectMemory} {
+		if err := collect(ctx, stats); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	procs, err := process.Processes()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	var processes []ProcessStats
+	for _, p := range procs {
+		s, err := getProcessStats(p)
+		if err != nil {
+			continue
+		}
+		times, err := p.Times()
+		if err != nil {
+			continue
+		}
+		s.CPUPercent = topCPU.cpuPercent(s.PID, s.CreateTime, now, times.User+times.System)
+		processes = append(processes, s)
+	}
+	topCPU.Prune(now, nil)
+
+	q := defaultProcessQuery(limit)
+	q.SortBy = sortBy
+	selected, total := q.Apply(processes)
+	stats.ProcessCount = total
+	return stats, selected, nil
+}
+
+// monitorClient reads the API of a running monitor.
+type monitorClient struct {
+	base   string
+	client *http.Client
+}
+
+func newMonitorClient(host string, timeout time.Duration) *monitorClient {
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return &monitorClient{base: strings.TrimSuffix(host, "/"), client: &http.Client{Timeout: timeout}}
+}
+
+func (c *monitorClient) get(path string) ([]byte, error) {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	req, err := http.NewRequest(http.MethodGet, c.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s: %s", c.base+path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+func (c *monitorClient) top(sortBy string, limit int) (*SystemStats, []ProcessStats, error) {
+	data, err := c.get("/metrics")
+	if err != nil {
+		return nil, nil, err
+	}
+	var stats SystemStats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return nil, nil, fmt.Errorf("error decoding /metrics: %v", err)
+	}
+	query := url.Values{"sort": {sortBy}, "limit": {strconv.Itoa(limit)}}
+	if data, err = c.get("/processes?" + query.Encode()); err != nil {
+		return nil, nil, err
+	}
+	var processes []ProcessStats
+	if err := json.Unmarshal(data, &processes); err != nil {
+		return nil, nil, fmt.Errorf("error decoding /processes: %v", err)
+	}
+	return &stats, processes, nil
+}
+
+// writeOutput writes v as JSON or YAML, or calls table for the table format.
+func writeOutput(w io.Writer, format string, v interface{}, table func(io.Writer)) error {
+	if format == FormatTable {
+		table(w)
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeRawOutput(w, format, data)
+}
+
+// writeRawOutput writes a JSON document as indented JSON or as YAML.
+func writeRawOutput(w io.Writer, format string, data []byte) error {
+	if format == FormatYAML {
+		out, err := jsonToYAML(data)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(out)
+		return err
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// jsonToYAML converts a JSON document to block-style YAML, keeping the key
+// order and therefore the field names of the JSON API.
+func jsonToYAML(data []byte) ([]byte, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	var reset func(n *yaml.Node)
+	reset = func(n *yaml.Node) {
+		n.Style = 0
+		for _, child := range n.Content {
+			reset(child)
+		}
+	}
+	reset(&node)
+	return yaml.Marshal(&node)
+}
+
+// writeStatsTable renders SystemStats for a terminal.
+func writeStatsTable(w io.Writer, stats *SystemStats) {
+	h := stats.HostInfo
+	fmt.Fprintf(w, "Host:       %s (%s %s %s, kernel %s), up %s\n", h.Hostname, h.OS, h.Platform, h.PlatformVersion,
+		h.KernelVersion, formatUptime(h.Uptime))
+	writeSummary(w, stats)
+	fmt.Fprintf(w, "Processes:  %d\n", stats.ProcessCount)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if len(stats.Disk) > 0 {
+		fmt.Fprintln(tw, "\nMOUNT\tDEVICE\tTYPE\tSIZE\tUSED\tUSE%\tFULL IN")
+		for _, d := range stats.Disk {
+			fullIn := "-"
+			if d.SecondsUntilFull != nil {
+				fullIn = formatUptime(uint64(*d.SecondsUntilFull))
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%.1f\t%s\n", d.MountPoint, d.Device, d.FSType,
+				formatBytes(d.Total), formatBytes(d.Used), d.UsagePerc, fullIn)
+		}
+	}
+	if len(stats.DiskIO) > 0 {
+		fmt.Fprintln(tw, "\nDEVICE\tREAD/s\tWRITE/s\tIOPS\tUTIL%")
+		for _, d := range stats.DiskIO {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%.0f\t%.1f\n", d.Device, formatBytes(uint64(d.ReadBytesRate)),
+				formatBytes(uint64(d.WriteBytesRate)), d.ReadIOPS+d.WriteIOPS, d.Utilization)
+		}
+	}
+	if stats.Network != nil && len(stats.Network.Interfaces) > 0 {
+		fmt.Fprintln(tw, "\nINTERFACE\tRX/s\tTX/s\tRX\tTX\tERR/s\tDROP/s")
+		for _, i := range stats.Network.Interfaces {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%.0f\t%.0f\n", i.Name, formatBytes(uint64(i.BytesRecvRate)),
+				formatBytes(uint64(i.BytesSentRate)), formatBytes(i.BytesRecv), formatBytes(i.BytesSent), i.ErrRate, i.DropRate)
+		}
+	}
+	if len(stats.Containers) > 0 {
+		fmt.Fprintln(tw, "\nCONTAINER\tCPU%\tMEMORY\tLIMIT\tPIDS\tNEAR LIMITS")
+		for _, c := range stats.Containers {
+			name := c.Cgroup
+			if c.ID != "" {
+				name = c.ID[:12]
+			}
+			limit := "-"
+			if c.Memory.Limit > 0 {
+				limit = formatBytes(c.Memory.Limit)
+			}
+			fmt.Fprintf(tw, "%s\t%.1f\t%s\t%s\t%d\t%s\n", name, c.CPU.UsagePercent, formatBytes(c.Memory.Usage), limit,
+				c.PIDs.Current, strings.Join(c.NearLimits, ","))
+		}
+	}
+	if len(stats.TopProcesses) > 0 {
+		fmt.Fprintln(tw)
+		writeProcessTable(tw, stats.TopProcesses)
+	}
+	var failed []string
+	for name, status := range stats.Collectors {
+		if status.Error != "" {
+			failed = append(failed, name+": "+status.Error)
+		}
+	}
+	tw.Flush()
+	for _, f := range failed {
+		fmt.Fprintf(w, "Warning: collector %s\n", f)
+	}
+}
+
+// writeSummary writes the CPU and memory lines shared by snapshot and top.
+func writeSummary(w io.Writer, stats *SystemStats) {
+	load := make([]string, len(stats.CPU.LoadAverage))
+	for i, l := range stats.CPU.LoadAverage {
+		load[i] = fmt.Sprintf("%.2f", l)
+	}
+	fmt.Fprintf(w, "CPU:        %.1f%% of %d cores, load %s\n", stats.CPU.Usage, stats.CPU.CoreCount, strings.Join(load, " "))
+	m := stats.Memory
+	fmt.Fprintf(w, "Memory:     %s / %s (%.1f%%), swap %s / %s\n", formatBytes(m.Used), formatBytes(m.Total), m.UsagePerc,
+		formatBytes(m.SwapUsed), formatBytes(m.SwapTotal))
+}
+
+func writeProcessTable(tw *tabwriter.Writer, processes []ProcessStats) {
+	fmt.Fprintln(tw, "PID\tUSER\tCPU%\tMEM%\tRSS\tSTATUS\tNAME")
+	for _, p := range processes {
+		fmt.Fprintf(tw, "%d\t%s\t%.1f\t%.1f\t%s\t%s\t%s\n", p.PID, p.Username, p.CPUPercent, p.MemoryPerc,
+			formatBytes(p.MemoryUsage), p.Status, p.Name)
+	}
+}
+
+func writeTopView(w io.Writer, stats *SystemStats, processes []ProcessStats, interval time.Duration) {
+	fmt.Fprintf(w, "%s - %s, up %s, %d processes, refreshing every %s\n", stats.HostInfo.Hostname, stats.Timestamp,
+		formatUptime(stats.HostInfo.Uptime), stats.ProcessCount, interval)
+	writeSummary(w, stats)
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	writeProcessTable(tw, processes)
+	tw.Flush()
+}
+
+// formatUptime renders seconds as e.g. "3d4h", "5h12m" or "42s".
+func formatUptime(seconds uint64) string {
+	d := time.Duration(seconds) * time.Second
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd%dh", d/(24*time.Hour), d%(24*time.Hour)/time.Hour)
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh%dm", d/time.Hour, d%time.Hour/time.Minute)
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm%ds", d/time.Minute, d%time.Minute/time.Second)
+	}
+	return fmt.Sprintf("%ds", d/time.Second)
+}
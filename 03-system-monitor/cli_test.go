@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONToYAMLKeepsKeyOrder(t *testing.T) {
+	out, err := jsonToYAML([]byte(`{"zeta":1,"alpha":{"name":"a b","list":[1,2]},"empty":""}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "zeta: 1\nalpha:\n    name: a b\n    list:\n        - 1\n        - 2\nempty: \"\"\n"
+	if string(out) != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestFormatUptime(t *testing.T) {
+	tests := map[uint64]string{
+		42:               "42s",
+		5*60 + 3:         "5m3s",
+		5*3600 + 12*60:   "5h12m",
+		3*86400 + 4*3600: "3d4h",
+	}
+	for seconds, want := range tests {
+		if got := formatUptime(seconds); got != want {
+			t.Errorf("formatUptime(%d) = %q, want %q", seconds, got, want)
+		}
+	}
+}
+
+func TestMonitorClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metrics":
+			w.Write([]byte(`{"timestamp":"2024-01-01T00:00:00Z","cpu":{"usage":12.5,"core_count":4}}`))
+		case "/processes":
+			if r.URL.Query().Get("sort") != "memory" || r.URL.Query().Get("limit") != "5" {
+				http.Error(w, "unexpected query "+r.URL.RawQuery, http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`[{"pid":1,"name":"init"}]`))
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	// Hosts without a scheme default to http.
+	client := newMonitorClient(strings.TrimPrefix(srv.URL, "http://"), time.Second)
+	stats, processes, err := client.top("memory", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.CPU.Usage != 12.5 || stats.CPU.CoreCount != 4 {
+		t.Errorf("unexpected stats %+v", stats.CPU)
+	}
+	if len(processes) != 1 || processes[0].PID != 1 || processes[0].Name != "init" {
+		t.Errorf("unexpected processes %+v", processes)
+	}
+
+	if _, err := client.get("/missing"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected a 404 error, got %v", err)
+	}
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+const defaultCollectorTimeout = 5 * time.Second
+
+// Collector gathers one part of SystemStats. Each collector writes only its
+// own fields, so collectors can run concurrently and fail independently.
+type Collector interface {
+	Name() string
+	Collect(ctx context.Context, stats *SystemStats) error
+}
+
+// CollectorStatus reports how a collector fared in one collection.
+type CollectorStatus struct {
+	Duration float64 `json:"duration_seconds"`
+	Error    string  `json:"error,omitempty"`
+	TimedOut bool    `json:"timed_out,omitempty"`
+}
+
+type collectorFunc struct {
+	name string
+	fn   func(ctx context.Context, stats *SystemStats) error
+}
+
+func (c collectorFunc) Name() string { return c.name }
+
+func (c collectorFunc) Collect(ctx context.Context, stats *SystemStats) error {
+	return c.fn(ctx, stats)
+}
+
+// allCollectors lists the built-in collectors in the order they are reported.
+var allCollectors = []Collector{
+	collectorFunc{"host", collectHost},
+	collectorFunc{"cpu", collectCPU},
+	collectorFunc{"mem", collectMemory},
+	collectorFunc{"disk", collectDisk},
+	collectorFunc{"diskio", collectDiskIO},
+	collectorFunc{"net", collectNetwork},
+	collectorFunc{"cgroup", collectContainers},
+	collectorFunc{"pressure", collectPressure},
+	collectorFunc{"process", collectProcesses},
+}
+
+type enabledCollector struct {
+	Collector
+	timeout time.Duration
+	state   *collectorState
+}
+
+// collectorState tracks the Collect call in flight, including one that was
+// abandoned after timing out. Concurrent collections (the sampler and an
+// HTTP request, say) share it rather than running the collector twice.
+type collectorState struct {
+	mu       sync.Mutex
+	inflight *collectorRun
+	// failing is whether the last collection failed.
+	failing bool
+}
+
+type collectorRun struct {
+	started time.Time
+	done    chan struct{}
+	partial *SystemStats
+	err     error
+}
+
+// setFailing records whether the collector failed and reports whether that
+// changed, so a collector that keeps failing on every sample is logged when
+// it starts and stops failing rather than every time.
+func (c enabledCollector) setFailing(failing bool) bool {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	changed := c.state.failing != failing
+	c.state.failing = failing
+	return changed
+}
+
+// start returns the run in flight, starting one if there is none. It
+// returns nil if the run in flight has already outlived its timeout, so
+// callers don't keep waiting on a collector that is stuck.
+func (c enabledCollector) start() *collectorRun {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	if run := c.state.inflight; run != nil {
+		if time.Since(run.started) > c.timeout {
+			return nil
+		}
+		return run
+	}
+
+	run := &collectorRun{started: time.Now(), done: make(chan struct{}), partial: &SystemStats{}}
+	c.state.inflight = run
+	go func() {
+		// The run gets a context of its own since callers joining it may
+		// give up at different times.
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		defer cancel()
+		run.err = c.Collect(ctx, run.partial)
+
+		c.state.mu.Lock()
+		c.state.inflight = nil
+		c.state.mu.Unlock()
+		close(run.done)
+	}()
+	return run
+}
+
+// collectorSet is the configured list of collectors.
+type collectorSet struct {
+	collectors []enabledCollector
+}
+
+var collectors = &collectorSet{}
+
+// loadCollectors enables collectors according to COLLECTORS (default: all)
+// and COLLECTORS_DISABLED. COLLECTOR_TIMEOUT sets the default timeout and
+// COLLECTOR_TIMEOUT_<NAME> overrides it for a single collector.
+func loadCollectors() (*collectorSet, error) {
+	known := make(map[string]bool)
+	for _, c := range allCollectors {
+		known[c.Name()] = true
+	}
+	parseList := func(key string) (map[string]bool, error) {
+		names := make(map[string]bool)
+		for _, name := range strings.Split(os.Getenv(key), ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			if !known[name] {
+				return nil, fmt.Errorf("%s: unknown collector %q", key, name)
+			}
+			names[name] = true
+		}
+		return names, nil
+	}
+
+	enabled, err := parseList("COLLECTORS")
+	if err != nil {
+		return nil, err
+	}
+	disabled, err := parseList("COLLECTORS_DISABLED")
+	if err != nil {
+		return nil, err
+	}
+	defaultTimeout, err := envDuration("COLLECTOR_TIMEOUT", defaultCollectorTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &collectorSet{}
+	for _, c := range allCollectors {
+		if (len(enabled) > 0 && !enabled[c.Name()]) || disabled[c.Name()] {
+			continue
+		}
+		timeout, err := envDuration("COLLECTOR_TIMEOUT_"+strings.ToUpper(c.Name()), defaultTimeout)
+		if err != nil {
+			return nil, err
+		}
+		set.collectors = append(set.collectors, enabledCollector{Collector: c, timeout: timeout, state: &collectorState{}})
+	}
+	return set, nil
+}
+
+// Names returns the enabled collector names.
+func (s *collectorSet) Names() []string {
+	var names []string
+	for _, c := range s.collectors {
+		names = append(names, c.Name())
+	}
+	return names
+}
+
+// Collect runs every enabled collector concurrently, each with its own
+// timeout. A collector that errors or times out is reported in
+// stats.Collectors without affecting the others. Collectors write into a
+// private copy that is merged only if they finish in time, so one that is
+// stuck (e.g. on a hung NFS mount) cannot race with the response. A
+// collector already running for another caller is joined, not rerun.
+func (s *collectorSet) Collect(stats *SystemStats) error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	stats.Collectors = make(map[string]CollectorStatus, len(s.collectors))
+	failed := 0
+
+	for _, c := range s.collectors {
+		wg.Add(1)
+		go func(c enabledCollector) {
+			defer wg.Done()
+
+			start := time.Now()
+			var status CollectorStatus
+			var partial *SystemStats
+			if run := c.start(); run == nil {
+				// Don't pile up goroutines behind a collector that is stuck.
+				status.Error = "previous collection still in progress"
+			} else {
+				timer := time.NewTimer(c.timeout - time.Since(run.started))
+				defer timer.Stop()
+				select {
+				case <-run.done:
+					partial = run.partial
+					if run.err != nil {
+						status.Error = run.err.Error()
+					}
+				case <-timer.C:
+					status.Error = fmt.Sprintf("timed out after %s", c.timeout)
+					status.TimedOut = true
+				}
+			}
+			status.Duration = time.Since(start).Seconds()
+
+			mu.Lock()
+			defer mu.Unlock()
+			stats.Collectors[c.Name()] = status
+			if status.Error != "" {
+				if c.setFailing(true) {
+					log.Printf("Warning: Collector %s failed: %s (further failures are not logged until it recovers)", c.Name(), status.Error)
+				}
+				failed++
+				return
+			}
+			if c.setFailing(false) {
+				log.Printf("Collector %s recovered", c.Name())
+			}
+			mergeStats(stats, partial)
+		}(c)
+	}
+	wg.Wait()
+
+	if len(s.collectors) > 0 && failed == len(s.collectors) {
+		return fmt.Errorf("all collectors failed")
+	}
+	return nil
+}
+
+// mergeStats copies every non-zero top-level field of src into dst.
+func mergeStats(dst, src *SystemStats) {
+	d := reflect.ValueOf(dst).Elem()
+	sv := reflect.ValueOf(src).Elem()
+	for i := 0; i < sv.NumField(); i++ {
+		if f := sv.Field(i); !f.IsZero() {
+			d.Field(i).Set(f)
+		}
+	}
+}
+
+func collectHost(ctx context.Context, stats *SystemStats) error {
+	hostInfo, err := host.InfoWithContext(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting host info: %v", err)
+	}
+	stats.HostInfo = HostInfo{
+		Hostname:        hostHostname(hostInfo.Hostname),
+		OS:              hostInfo.OS,
+		Platform:        hostInfo.Platform,
+		PlatformVersion: hostInfo.PlatformVersion,
+		KernelVersion:   hostInfo.KernelVersion,
+		KernelArch:      hostInfo.KernelArch,
+		Uptime:          hostInfo.Uptime,
+		BootTime:        time.Unix(int64(hostInfo.BootTime), 0).UTC().Format(time.RFC3339),
+
+		VirtualizationSystem: hostInfo.VirtualizationSystem,
+		VirtualizationRole:   hostInfo.VirtualizationRole,
+	}
+	return nil
+}
+
+func collectCPU(ctx context.Context, stats *SystemStats) error {
+	cpuPercent, err := cpu.PercentWithContext(ctx, 0, false)
+	if err != nil {
+		return fmt.Errorf("error getting CPU stats: %v", err)
+	}
+
+	perCPU, err := cpu.PercentWithContext(ctx, 0, true)
+	if err != nil {
+		log.Printf("Warning: Could not get per-CPU stats: %v", err)
+	}
+
+	loadAvg, err := load.AvgWithContext(ctx)
+	if err != nil {
+		log.Printf("Warning: Could not get load average: %v", err)
+	}
+
+	stats.CPU = CPUStats{
+		Usage:     cpuPercent[0],
+		CoreCount: runtime.NumCPU(),
+		PerCPU:    perCPU,
+	}
+
+	if loadAvg != nil {
+		stats.CPU.LoadAverage = []float64{loadAvg.Load1, loadAvg.Load5, loadAvg.Load15}
+	}
+	return nil
+}
+
+func collectMemory(ctx context.Context, stats *SystemStats) error {
+	virtualMem, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting memory stats: %v", err)
+	}
+
+	swapMem, err := mem.SwapMemoryWithContext(ctx)
+	if err != nil {
+		log.Printf("Warning: Could not get swap memory stats: %v", err)
+	}
+
+	stats.Memory = MemoryStats{
+		Total:     virtualMem.Total,
+		Used:      virtualMem.Used,
+		Free:      virtualMem.Free,
+		UsagePerc: virtualMem.UsedPercent,
+	}
+
+	if swapMem != nil {
+		stats.Memory.SwapTotal = swapMem.Total
+		stats.Memory.SwapUsed = swapMem.Used
+		stats.Memory.SwapFree = swapMem.Free
+		stats.Memory.SwapUsagePerc = swapMem.UsedPercent
+	}
+	return nil
+}
+
+func collectDisk(ctx context.Context, stats *SystemStats) error {
+	partitions, err := hostPartitions()
+	if err != nil {
+		return fmt.Errorf("error getting disk partitions: %v", err)
+	}
+
+	// Each mount is queried concurrently with its own timeout so a hung
+	// network filesystem only drops that mount.
+	results := make([]*DiskStats, len(partitions))
+	var wg sync.WaitGroup
+	for i, partition := range partitions {
+		wg.Add(1)
+		go func(i int, partition disk.PartitionStat) {
+			defer wg.Done()
+			usage, err := diskUsage(ctx, partition.Mountpoint)
+			if err != nil {
+				log.Printf("Warning: Could not get disk usage for %s: %v", partition.Mountpoint, err)
+				return
+			}
+			results[i] = &DiskStats{
+				Device:      partition.Device,
+				MountPoint:  partition.Mountpoint,
+				FSType:      partition.Fstype,
+				Total:       usage.Total,
+				Used:        usage.Used,
+				Free:        usage.Free,
+				UsagePerc:   usage.UsedPercent,
+				InodesTotal: usage.InodesTotal,
+				InodesUsed:  usage.InodesUsed,
+				InodesFree:  usage.InodesFree,
+			}
+		}(i, partition)
+	}
+	wg.Wait()
+
+	now := time.Now()
+	for _, d := range results {
+		if d == nil {
+			continue
+		}
+		forecastFull(d, now)
+		stats.Disk = append(stats.Disk, *d)
+	}
+	return nil
+}
+
+func collectDiskIO(ctx context.Context, stats *SystemStats) error {
+	partitions, err := hostPartitions()
+	if err != nil {
+		return fmt.Errorf("error getting disk partitions: %v", err)
+	}
+	stats.DiskIO, err = getDiskIOStats(partitions)
+	return err
+}
+
+func collectNetwork(ctx context.Context, stats *SystemStats) error {
+	network, err := getNetworkStats()
+	if err != nil {
+		return err
+	}
+	stats.Network = network
+	return nil
+}
+
+func collectContainers(ctx context.Context, stats *SystemStats) error {
+	containers, err := getContainerStats(false)
+	if err != nil {
+		return fmt.Errorf("error getting container stats: %v", err)
+	}
+	stats.Containers = containers
+	return nil
+}
+
+func collectPressure(ctx context.Context, stats *SystemStats) error {
+	pressure, err := getPressureStats()
+	if err != nil {
+		return fmt.Errorf("error getting pressure stats: %v", err)
+	}
+	stats.Pressure = pressure
+
+	oom, err := getOOMStats()
+	if err != nil {
+		log.Printf("Warning: Could not get OOM stats: %v", err)
+	} else {
+		stats.OOM = oom
+	}
+	return nil
+}
+
+func collectProcesses(ctx context.Context, stats *SystemStats) error {
+	pids, err := process.PidsWithContext(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting processes: %v", err)
+	}
+	stats.ProcessCount = len(pids)
+
+	topProcesses, err := getTopProcesses(5)
+	if err != nil {
+		return fmt.Errorf("error getting top processes: %v", err)
+	}
+	stats.TopProcesses = topProcesses
+	return nil
+}
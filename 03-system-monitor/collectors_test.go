@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCollectorFailuresLoggedOnStateChange(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	})
+
+	var fail bool
+	set := &collectorSet{collectors: []enabledCollector{{
+		Collector: collectorFunc{"flaky", func(ctx context.Context, stats *SystemStats) error {
+			if fail {
+				return fmt.Errorf("psi not supported")
+			}
+			return nil
+		}},
+		timeout: time.Second,
+		state:   &collectorState{},
+	}}}
+
+	for i, failing := range []bool{false, true, true, true, false, false, true} {
+		fail = failing
+		var stats SystemStats
+		set.Collect(&stats)
+		if got := stats.Collectors["flaky"].Error != ""; got != failing {
+			t.Fatalf("collection %d: failed = %v, want %v", i, got, failing)
+		}
+	}
+
+	want := "Warning: Collector flaky failed: psi not supported (further failures are not logged until it recovers)\n" +
+		"Collector flaky recovered\n" +
+		"Warning: Collector flaky failed: psi not supported (further failures are not logged until it recovers)\n"
+	if buf.String() != want {
+		t.Errorf("got log\n%s\nwant\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// controlSignals are the signals the control API may send.
+var controlSignals = map[string]syscall.Signal{
+	"TERM": syscall.SIGTERM,
+	"KILL": syscall.SIGKILL,
+	"HUP":  syscall.SIGHUP,
+	"STOP": syscall.SIGSTOP,
+	"CONT": syscall.SIGCONT,
+}
+
+// maxControlRequestBytes bounds control request bodies.
+const maxControlRequestBytes = 4096
+
+// ControlConfig enables the process control API. It is off unless
+// CONTROL_API=true and a CONTROL_TOKEN is set.
+type ControlConfig struct {
+	Token    string
+	DenyPIDs map[int32]bool
+	AuditLog string
+
+	selfPID   int32
+	auditMu   sync.Mutex
+	auditFile *os.File
+}
+
+var control *ControlConfig
+
+// loadControlConfig reads CONTROL_API, CONTROL_TOKEN, CONTROL_DENY_PIDS and
+// CONTROL_AUDIT_LOG. It returns nil if the control API is disabled.
+func loadControlConfig() (*ControlConfig, error) {
+	if os.Getenv("CONTROL_API") != "true" {
+		return nil, nil
+	}
+	c := &ControlConfig{
+		Token:    os.Getenv("CONTROL_TOKEN"),
+		DenyPIDs: map[int32]bool{1: true},
+		AuditLog: os.Getenv("CONTROL_AUDIT_LOG"),
+		selfPID:  int32(os.Getpid()),
+	}
+	if len(c.Token) < 16 {
+		return nil, fmt.Errorf("CONTROL_TOKEN must be set to at least 16 characters")
+	}
+	c.DenyPIDs[c.selfPID] = true
+	for _, v := range strings.Split(os.Getenv("CONTROL_DENY_PIDS"), ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		pid, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || pid <= 0 {
+			return nil, fmt.Errorf("CONTROL_DENY_PIDS: invalid PID %q", v)
+		}
+		c.DenyPIDs[int32(pid)] = true
+	}
+	if c.AuditLog != "" {
+		f, err := os.OpenFile(c.AuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("error opening CONTROL_AUDIT_LOG: %v", err)
+		}
+		c.auditFile = f
+	}
+	return c, nil
+}
+
+// AuditEntry records one control request, whether or not it succeeded.
+type AuditEntry struct {
+	Time    string           `json:"time"`
+	Remote  string           `json:"remote"`
+	Action  string           `json:"action"`
+	PID     int32            `json:"pid"`
+	Process string           `json:"process,omitempty"`
+	Signal  string           `json:"signal,omitempty"`
+	Nice    *int             `json:"nice,omitempty"`
+	Targets []int32          `json:"targets,omitempty"`
+	Result  string           `json:"result"`
+	Error   string           `json:"error,omitempty"`
+	Failed  map[int32]string `json:"failed,omitempty"`
+}
+
+// audit logs the entry and appends it as a JSON line to the audit log.
+func (c *ControlConfig) audit(e *AuditEntry) {
+	e.Time = time.Now().UTC().Format(time.RFC3339)
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Error encoding audit entry: %v", err)
+		return
+	}
+	log.Printf("Audit: %s", line)
+	if c.auditFile == nil {
+		return
+	}
+	c.auditMu.Lock()
+	defer c.auditMu.Unlock()
+	if _, err := c.auditFile.Write(append(line, '\n')); err != nil {
+		log.Printf("Error writing audit log: %v", err)
+	}
+}
+
+// denied returns an error if pid is protected.
+func (c *ControlConfig) denied(pid int32) error {
+	switch {
+	case pid == 1:
+		return fmt.Errorf("PID 1 is protected")
+	case pid == c.selfPID:
+		return fmt.Errorf("PID %d is the monitor itself", pid)
+	case c.DenyPIDs[pid]:
+		return fmt.Errorf("PID %d is on the deny list", pid)
+	}
+	return nil
+}
+
+// ControlResult is the response to a control request.
+type ControlResult struct {
+	PID     int32            `json:"pid"`
+	Process string           `json:"process"`
+	Action  string           `json:"action"`
+	Signal  string           `json:"signal,omitempty"`
+	Nice    *int             `json:"nice,omitempty"`
+	Targets []int32          `json:"targets,omitempty"`
+	Failed  map[int32]string `json:"failed,omitempty"`
+}
+
+type controlRequest struct {
+	Signal string `json:"signal"`
+	Nice   *int   `json:"nice"`
+}
+
+// controlError carries the HTTP status for a failed control request.
+type controlError struct {
+	status int
+	err    error
+}
+
+func (e *controlError) Error() string { return e.err.Error() }
+
+func controlErrorf(status int, format string, args ...interface{}) error {
+	return &controlError{status: status, err: fmt.Errorf(format, args...)}
+}
+
+// controlActions are the actions below /processes/{pid}/.
+var controlActions = map[string]bool{"signal": true, "renice": true, "kill-tree": true}
+
+// handleProcessControl serves POST /processes/{pid}/{signal,renice,kill-tree}.
+// Every request for one of the actions is audited, whatever its outcome.
+func handleProcessControl(w http.ResponseWriter, r *http.Request, pidStr, action string) {
+	if control == nil {
+		http.Error(w, "process control is disabled, set CONTROL_API=true and CONTROL_TOKEN to enable", http.StatusForbidden)
+		return
+	}
+	if !controlActions[action] {
+		http.NotFound(w, r)
+		return
+	}
+	entry := &AuditEntry{Remote: r.RemoteAddr, Action: action}
+	reject := func(status int, result, message string) {
+		entry.Result, entry.Error = result, message
+		control.audit(entry)
+		http.Error(w, message, status)
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		reject(http.StatusMethodNotAllowed, "rejected", "method not allowed")
+		return
+	}
+	// The token must be sent as a bearer token; a bare token is refused.
+	auth := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(auth, "Bearer ")
+	if token == auth || subtle.ConstantTimeCompare([]byte(token), []byte(control.Token)) != 1 {
+		// Failed authentication is audited too, without trusting the body.
+		reject(http.StatusUnauthorized, "unauthorized", "unauthorized")
+		return
+	}
+
+	pid, err := strconv.ParseInt(pidStr, 10, 32)
+	if err != nil || pid <= 0 {
+		reject(http.StatusNotFound, "error", fmt.Sprintf("invalid PID %q", pidStr))
+		return
+	}
+	entry.PID = int32(pid)
+	var req controlRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxControlRequestBytes)).Decode(&req); err != nil && err != io.EOF {
+		reject(http.StatusBadRequest, "error", fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	entry.Signal, entry.Nice = req.Signal, req.Nice
+	var result *ControlResult
+	switch action {
+	case "signal":
+		result, err = signalProcess(int32(pid), req.Signal)
+	case "renice":
+		result, err = reniceProcess(int32(pid), req.Nice)
+	case "kill-tree":
+		if req.Signal == "" {
+			req.Signal = "TERM"
+			entry.Signal = req.Signal
+		}
+		result, err = signalProcessTree(int32(pid), req.Signal)
+	}
+
+	if result != nil {
+		entry.Process, entry.Targets, entry.Failed = result.Process, result.Targets, result.Failed
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		var ce *controlError
+		if errors.As(err, &ce) {
+			status = ce.status
+		}
+		reject(status, "error", err.Error())
+		return
+	}
+	entry.Result = "ok"
+	if len(result.Failed) > 0 {
+		entry.Result = "partial"
+	}
+	control.audit(entry)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("Error encoding response: %v", err)
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// lookupControlTarget checks that pid exists and may be controlled.
+func lookupControlTarget(pid int32) (string, error) {
+	if err := control.denied(pid); err != nil {
+		return "", &controlError{status: http.StatusForbidden, err: err}
+	}
+	p, err := process.NewProcess(pid)
+	if err != nil {
+		return "", controlErrorf(http.StatusNotFound, "process %d not found", pid)
+	}
+	name, _ := p.Name()
+	return name, nil
+}
+
+func parseSignal(name string) (syscall.Signal, error) {
+	sig, ok := controlSignals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return 0, controlErrorf(http.StatusBadRequest, "signal must be one of TERM, KILL, HUP, STOP or CONT")
+	}
+	return sig, nil
+}
+
+// syscallError maps errors from kill(2) and setpriority(2) to HTTP statuses.
+func syscallError(pid int32, err error) error {
+	switch {
+	case errors.Is(err, syscall.ESRCH):
+		return controlErrorf(http.StatusNotFound, "process %d not found", pid)
+	case errors.Is(err, syscall.EPERM), errors.Is(err, syscall.EACCES):
+		return controlErrorf(http.StatusForbidden, "permission denied for process %d", pid)
+	}
+	return err
+}
+
+func signalProcess(pid int32, signal string) (*ControlResult, error) {
+	sig, err := parseSignal(signal)
+	if err != nil {
+		return nil, err
+	}
+	name, err := lookupControlTarget(pid)
+	if err != nil {
+		return nil, err
+	}
+	result := &ControlResult{PID: pid, Process: name, Action: "signal", Signal: signal}
+	if err := syscall.Kill(int(pid), sig); err != nil {
+		return result, syscallError(pid, err)
+	}
+	return result, nil
+}
+
+func reniceProcess(pid int32, nice *int) (*ControlResult, error) {
+	if nice == nil || *nice < -20 || *nice > 19 {
+		return nil, controlErrorf(http.StatusBadRequest, "nice must be between -20 and 19")
+	}
+	name, err := lookupControlTarget(pid)
+	if err != nil {
+		return nil, err
+	}
+	result := &ControlResult{PID: pid, Process: name, Action: "renice", Nice: nice}
+	if err := syscall.Setpriority(syscall.PRIO_PROCESS, int(pid), *nice); err != nil {
+		return result, syscallError(pid, err)
+	}
+	return result, nil
+}
+
+// maxTreeStopRounds bounds how often a subtree is re-listed while waiting
+// for it to stop changing.
+const maxTreeStopRounds = 10
+
+// signalProcessTree signals pid and all of its descendants. The tree is
+// stopped first and re-listed until no new children appear, so nothing can
+// fork or restart children mid-way; it is then signalled deepest first and
+// finally continued. The request is refused, and anything already stopped
+// is continued, if any process in the tree is protected.
+func signalProcessTree(pid int32, signal string) (*ControlResult, error) {
+	sig, err := parseSignal(signal)
+	if err != nil {
+		return nil, err
+	}
+	name, err := lookupControlTarget(pid)
+	if err != nil {
+		return nil, err
+	}
+	result := &ControlResult{PID: pid, Process: name, Action: "kill-tree", Signal: signal}
+
+	// CONT only resumes processes, so there is nothing to freeze first.
+	freeze := sig != syscall.SIGCONT
+	stopped := make(map[int32]bool)
+	resume := func() {
+		for p := range stopped {
+			syscall.Kill(int(p), syscall.SIGCONT)
+		}
+	}
+
+	var tree []int32
+	for round := 0; ; round++ {
+		if tree, err = processSubtree(pid); err != nil {
+			resume()
+			return nil, err
+		}
+		for _, p := range tree {
+			if err := control.denied(p); err != nil {
+				resume()
+				result.Targets = tree
+				return result, &controlError{status: http.StatusForbidden, err: fmt.Errorf("subtree of %d contains a protected process: %v", pid, err)}
+			}
+		}
+		if !freeze {
+			break
+		}
+		added := false
+		for _, p := range tree {
+			if !stopped[p] {
+				syscall.Kill(int(p), syscall.SIGSTOP)
+				stopped[p] = true
+				added = true
+			}
+		}
+		if !added {
+			break
+		}
+		if round == maxTreeStopRounds {
+			resume()
+			return result, controlErrorf(http.StatusConflict, "subtree of %d kept changing while being stopped", pid)
+		}
+	}
+	result.Targets = tree
+
+	for i := len(tree) - 1; i >= 0; i-- {
+		p := tree[i]
+		if err := syscall.Kill(int(p), sig); err != nil && !errors.Is(err, syscall.ESRCH) {
+			if result.Failed == nil {
+				result.Failed = make(map[int32]string)
+			}
+			result.Failed[p] = err.Error()
+		}
+	}
+	if sig != syscall.SIGSTOP && sig != syscall.SIGKILL {
+		// Stopped processes only act on TERM or HUP once continued.
+		resume()
+	}
+	return result, nil
+}
+
+// processSubtree returns pid and its descendants, parents before children.
+func processSubtree(pid int32) ([]int32, error) {
+	procs, err := process.Processes()
+	if err != nil {
+		return nil, fmt.Errorf("error listing processes: %v", err)
+	}
+	children := make(map[int32][]int32)
+	for _, p := range procs {
+		ppid, err := p.Ppid()
+		if err != nil {
+			continue
+		}
+		children[ppid] = append(children[ppid], p.Pid)
+	}
+	return subtree(pid, children), nil
+}
+
+// subtree walks children breadth first from pid, with siblings sorted by
+// PID. PIDs reused during the listing can form a PPID cycle, so every PID
+// is visited once.
+func subtree(pid int32, children map[int32][]int32) []int32 {
+	tree := []int32{pid}
+	visited := map[int32]bool{pid: true}
+	for i := 0; i < len(tree); i++ {
+		kids := children[tree[i]]
+		sort.Slice(kids, func(a, b int) bool { return kids[a] < kids[b] })
+		for _, kid := range kids {
+			if !visited[kid] {
+				visited[kid] = true
+				tree = append(tree, kid)
+			}
+		}
+	}
+	return tree
+}
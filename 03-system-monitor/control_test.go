@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestControlDenied(t *testing.T) {
+	c := &ControlConfig{selfPID: 500, DenyPIDs: map[int32]bool{1: true, 500: true, 42: true}}
+	tests := map[int32]string{
+		1:   "PID 1 is protected",
+		500: "PID 500 is the monitor itself",
+		42:  "PID 42 is on the deny list",
+		7:   "",
+	}
+	for pid, want := range tests {
+		err := c.denied(pid)
+		if (err == nil) != (want == "") || (err != nil && err.Error() != want) {
+			t.Errorf("denied(%d) = %v, want %q", pid, err, want)
+		}
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	tests := map[string]syscall.Signal{
+		"TERM":    syscall.SIGTERM,
+		"kill":    syscall.SIGKILL,
+		"SIGHUP":  syscall.SIGHUP,
+		"sigStop": syscall.SIGSTOP,
+		"Cont":    syscall.SIGCONT,
+	}
+	for name, want := range tests {
+		if sig, err := parseSignal(name); err != nil || sig != want {
+			t.Errorf("parseSignal(%q) = %v, %v, want %v", name, sig, err, want)
+		}
+	}
+	for _, name := range []string{"", "INT", "USR1", "9", "SIGSIGTERM", "TERM "} {
+		if _, err := parseSignal(name); err == nil {
+			t.Errorf("parseSignal(%q): expected an error", name)
+		}
+	}
+}
+
+func TestSubtree(t *testing.T) {
+	children := map[int32][]int32{
+		10: {30, 20},
+		20: {50, 40},
+		30: {35},
+		40: {10}, // PID reuse making 10 its own descendant
+		60: {70},
+	}
+	tests := []struct {
+		pid  int32
+		want []int32
+	}{
+		// Parents come before their children and siblings are sorted.
+		{10, []int32{10, 20, 30, 40, 50, 35}},
+		{30, []int32{30, 35}},
+		{35, []int32{35}},
+		{20, []int32{20, 40, 50, 10, 30, 35}},
+	}
+	for _, tt := range tests {
+		if got := subtree(tt.pid, children); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("subtree(%d) = %v, want %v", tt.pid, got, tt.want)
+		}
+	}
+}
+
+// startSleep starts a child process for the control API to act on.
+func startSleep(t *testing.T) *exec.Cmd {
+	t.Helper()
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start sleep: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	return cmd
+}
+
+func TestHandleProcessControl(t *testing.T) {
+	const token = "0123456789abcdef"
+	handler := http.HandlerFunc(handleProcess)
+
+	saved := control
+	t.Cleanup(func() { control = saved })
+	control = nil
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/processes/123/signal", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("disabled: status = %d, want 403", rec.Code)
+	}
+
+	auditLog, err := os.CreateTemp(t.TempDir(), "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { auditLog.Close() })
+	control = &ControlConfig{
+		Token:     token,
+		DenyPIDs:  map[int32]bool{1: true, int32(os.Getpid()): true},
+		selfPID:   int32(os.Getpid()),
+		auditFile: auditLog,
+	}
+	child := strconv.Itoa(startSleep(t).Process.Pid)
+	reniced := startSleep(t).Process.Pid
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		auth   string
+		body   string
+		status int
+		result string
+	}{
+		{"GET", http.MethodGet, "/processes/" + child + "/signal", "Bearer " + token, "", http.StatusMethodNotAllowed, "rejected"},
+		{"bare token", http.MethodPost, "/processes/" + child + "/signal", token, `{"signal":"TERM"}`, http.StatusUnauthorized, "unauthorized"},
+		{"wrong token", http.MethodPost, "/processes/" + child + "/signal", "Bearer " + token + "x", `{"signal":"TERM"}`, http.StatusUnauthorized, "unauthorized"},
+		{"no token", http.MethodPost, "/processes/" + child + "/signal", "", `{"signal":"TERM"}`, http.StatusUnauthorized, "unauthorized"},
+		{"invalid PID", http.MethodPost, "/processes/abc/signal", "Bearer " + token, `{"signal":"TERM"}`, http.StatusNotFound, "error"},
+		{"invalid body", http.MethodPost, "/processes/" + child + "/signal", "Bearer " + token, `{"signal":`, http.StatusBadRequest, "error"},
+		{"invalid signal", http.MethodPost, "/processes/" + child + "/signal", "Bearer " + token, `{"signal":"INT"}`, http.StatusBadRequest, "error"},
+		{"protected PID", http.MethodPost, "/processes/1/signal", "Bearer " + token, `{"signal":"TERM"}`, http.StatusForbidden, "error"},
+		{"missing process", http.MethodPost, "/processes/2147483647/signal", "Bearer " + token, `{"signal":"TERM"}`, http.StatusNotFound, "error"},
+		{"invalid nice", http.MethodPost, "/processes/" + child + "/renice", "Bearer " + token, `{"nice":20}`, http.StatusBadRequest, "error"},
+		{"renice", http.MethodPost, "/processes/" + strconv.Itoa(reniced) + "/renice", "Bearer " + token, `{"nice":10}`, http.StatusOK, "ok"},
+		{"signal", http.MethodPost, "/processes/" + child + "/signal", "Bearer " + token, `{"signal":"term"}`, http.StatusOK, "ok"},
+	}
+	audited := 0
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d (%s), want %d", rec.Code, strings.TrimSpace(rec.Body.String()), tt.status)
+			}
+
+			entries := readAuditLog(t, auditLog.Name())
+			audited++
+			if len(entries) != audited {
+				t.Fatalf("%d audit entries, want %d", len(entries), audited)
+			}
+			entry := entries[len(entries)-1]
+			if entry.Result != tt.result || entry.Remote == "" || entry.Action == "" || entry.Time == "" {
+				t.Errorf("audit entry = %+v, want result %q", entry, tt.result)
+			}
+			if tt.result == "unauthorized" && entry.Signal != "" {
+				t.Errorf("unauthenticated body was audited: %+v", entry)
+			}
+		})
+	}
+
+	// The getpriority system call returns 20 - nice.
+	if nice, err := syscall.Getpriority(syscall.PRIO_PROCESS, reniced); err != nil || 20-nice != 10 {
+		t.Errorf("nice of reniced process = %d, %v, want 10", 20-nice, err)
+	}
+
+	// Paths that are not control actions are not audited.
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/processes/"+child+"/unknown", nil))
+	if rec.Code != http.StatusNotFound || len(readAuditLog(t, auditLog.Name())) != audited {
+		t.Errorf("unknown action: status = %d, audited", rec.Code)
+	}
+}
+
+func readAuditLog(t *testing.T, path string) []AuditEntry {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []AuditEntry
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var e AuditEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			t.Fatalf("invalid audit line %s: %v", line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
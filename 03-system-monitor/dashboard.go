@@ -0,0 +1,24 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+	"strings"
+)
+
+// dashboardHTML is a self-contained page (no external scripts, styles or
+// fonts) so the dashboard also works on air-gapped hosts.
+//
+//go:embed dashboard/index.html
+var dashboardHTML []byte
+
+// wantsHTML reports whether the client is a browser rather than a script.
+func wantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(dashboardHTML)
+}
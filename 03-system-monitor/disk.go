@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// DiskFilter selects which mounts are reported. Every rule is a list of
+// globs; a mount is reported if it matches all include lists that are set
+// and none of the exclude lists. A mount point pattern ending in "/**" also
+// matches everything below it.
+type DiskFilter struct {
+	IncludeFSTypes     []string
+	ExcludeFSTypes     []string
+	IncludeDevices     []string
+	ExcludeDevices     []string
+	IncludeMountPoints []string
+	ExcludeMountPoints []string
+}
+
+// defaultDiskExcludeFSTypes applies when DISK_EXCLUDE_FSTYPES is unset.
+var defaultDiskExcludeFSTypes = []string{"tmpfs", "ramfs"}
+
+var (
+	diskFilter       = &DiskFilter{ExcludeFSTypes: defaultDiskExcludeFSTypes}
+	diskUsageTimeout = 2 * time.Second
+	diskForecasts    = newDiskForecaster(6 * time.Hour)
+)
+
+// loadDiskFilter reads the DISK_{INCLUDE,EXCLUDE}_{FSTYPES,DEVICES,MOUNTPOINTS}
+// variables.
+func loadDiskFilter() (*DiskFilter, error) {
+	f := &DiskFilter{}
+	for key, target := range map[string]*[]string{
+		"DISK_INCLUDE_FSTYPES":     &f.IncludeFSTypes,
+		"DISK_EXCLUDE_FSTYPES":     &f.ExcludeFSTypes,
+		"DISK_INCLUDE_DEVICES":     &f.IncludeDevices,
+		"DISK_EXCLUDE_DEVICES":     &f.ExcludeDevices,
+		"DISK_INCLUDE_MOUNTPOINTS": &f.IncludeMountPoints,
+		"DISK_EXCLUDE_MOUNTPOINTS": &f.ExcludeMountPoints,
+	} {
+		value, ok := os.LookupEnv(key)
+		if !ok {
+			continue
+		}
+		for _, pattern := range strings.Split(value, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern == "" {
+				continue
+			}
+			if _, err := path.Match(strings.TrimSuffix(pattern, "/**"), ""); err != nil {
+				return nil, fmt.Errorf("%s: invalid pattern %q", key, pattern)
+			}
+			*target = append(*target, pattern)
+		}
+	}
+	if _, ok := os.LookupEnv("DISK_EXCLUDE_FSTYPES"); !ok {
+		f.ExcludeFSTypes = defaultDiskExcludeFSTypes
+	}
+	return f, nil
+}
+
+// Match reports whether a partition should be reported.
+func (f *DiskFilter) Match(p disk.PartitionStat) bool {
+	return matchRule(p.Fstype, f.IncludeFSTypes, f.ExcludeFSTypes) &&
+		matchRule(p.Device, f.IncludeDevices, f.ExcludeDevices) &&
+		matchRule(p.Mountpoint, f.IncludeMountPoints, f.ExcludeMountPoints)
+}
+
+func matchRule(value string, include, exclude []string) bool {
+	if len(include) > 0 && !matchAny(value, include) {
+		return false
+	}
+	return !matchAny(value, exclude)
+}
+
+func matchAny(value string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if prefix := strings.TrimSuffix(pattern, "/**"); prefix != pattern {
+			if ok, _ := path.Match(prefix, value); ok {
+				return true
+			}
+			if strings.HasPrefix(value, prefix+"/") || prefix == "" {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// inflightUsage remembers mounts whose statfs has not returned yet, so a hung
+// network mount costs one goroutine rather than one per collection.
+var inflightUsage = struct {
+	sync.Mutex
+	mounts map[string]bool
+}{mounts: make(map[string]bool)}
+
+// diskUsage calls disk.Usage for one mount with a timeout.
+func diskUsage(ctx context.Context, mountpoint string) (*disk.UsageStat, error) {
+	inflightUsage.Lock()
+	if inflightUsage.mounts[mountpoint] {
+		inflightUsage.Unlock()
+		return nil, fmt.Errorf("previous usage call still in progress")
+	}
+	inflightUsage.mounts[mountpoint] = true
+	inflightUsage.Unlock()
+
+	type result struct {
+		usage *disk.UsageStat
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		usage, err := disk.Usage(localMountPoint(mountpoint))
+		inflightUsage.Lock()
+		delete(inflightUsage.mounts, mountpoint)
+		inflightUsage.Unlock()
+		done <- result{usage, err}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, diskUsageTimeout)
+	defer cancel()
+	select {
+	case r := <-done:
+		return r.usage, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("usage timed out after %s", diskUsageTimeout)
+	}
+}
+
+// diskForecaster estimates when each mount will fill up from the growth of
+// its used space over a sliding window.
+type diskForecaster struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	samples map[string][]usageSample
+}
+
+type usageSample struct {
+	time time.Time
+	used float64
+}
+
+func newDiskForecaster(window time.Duration) *diskForecaster {
+	return &diskForecaster{window: window, samples: make(map[string][]usageSample)}
+}
+
+// forecastSamples bounds the samples kept per mount regardless of how often
+// the disk collector runs.
+const forecastSamples = 120
+
+// Observe records the used bytes of a mount and returns the growth rate in
+// bytes per second, computed by least squares over the window. ok is false
+// until the samples span at least a tenth of the window.
+func (f *diskForecaster) Observe(mountpoint string, now time.Time, used uint64) (rate float64, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	samples := f.samples[mountpoint]
+	spacing := f.window / forecastSamples
+	if n := len(samples); n == 0 || now.Sub(samples[n-1].time) >= spacing {
+		samples = append(samples, usageSample{time: now, used: float64(used)})
+	}
+	for len(samples) > 0 && now.Sub(samples[0].time) > f.window {
+		samples = samples[1:]
+	}
+	f.samples[mountpoint] = samples
+
+	if len(samples) < 3 || samples[len(samples)-1].time.Sub(samples[0].time) < f.window/10 {
+		return 0, false
+	}
+
+	var sumX, sumY, sumXY, sumXX float64
+	n := float64(len(samples))
+	for _, s := range samples {
+		x := s.time.Sub(samples[0].time).Seconds()
+		sumX += x
+		sumY += s.used
+		sumXY += x * s.used
+		sumXX += x * x
+	}
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0, false
+	}
+	return (n*sumXY - sumX*sumY) / denominator, true
+}
+
+// forecastFull fills in the growth rate and, if the mount is growing, when it
+// will be full.
+func forecastFull(d *DiskStats, now time.Time) {
+	rate, ok := diskForecasts.Observe(d.MountPoint, now, d.Used)
+	if !ok {
+		return
+	}
+	d.GrowthRate = rate
+	if rate <= 0 {
+		return
+	}
+	seconds := float64(d.Free) / rate
+	if seconds > float64(math.MaxInt64/int64(time.Second)) {
+		return
+	}
+	d.SecondsUntilFull = &seconds
+	d.FullAt = now.Add(time.Duration(seconds * float64(time.Second))).UTC().Format(time.RFC3339)
+}
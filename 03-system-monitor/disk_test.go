@@ -0,0 +1,150 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+func TestDiskFilterMatch(t *testing.T) {
+	root := disk.PartitionStat{Device: "/dev/sda1", Mountpoint: "/", Fstype: "ext4"}
+	home := disk.PartitionStat{Device: "/dev/sda2", Mountpoint: "/home", Fstype: "ext4"}
+	docker := disk.PartitionStat{Device: "overlay", Mountpoint: "/var/lib/docker/overlay2/abc/merged", Fstype: "overlay"}
+	dockerDir := disk.PartitionStat{Device: "/dev/sdb1", Mountpoint: "/var/lib/docker", Fstype: "xfs"}
+	run := disk.PartitionStat{Device: "tmpfs", Mountpoint: "/run", Fstype: "tmpfs"}
+	nfs := disk.PartitionStat{Device: "nas:/export", Mountpoint: "/mnt/nas", Fstype: "nfs4"}
+	all := []disk.PartitionStat{root, home, docker, dockerDir, run, nfs}
+
+	tests := []struct {
+		name   string
+		filter DiskFilter
+		want   []disk.PartitionStat
+	}{
+		{"empty filter", DiskFilter{}, all},
+		{"default excludes", DiskFilter{ExcludeFSTypes: defaultDiskExcludeFSTypes}, []disk.PartitionStat{root, home, docker, dockerDir, nfs}},
+		{"fstype glob", DiskFilter{ExcludeFSTypes: []string{"nfs*", "overlay"}}, []disk.PartitionStat{root, home, dockerDir, run}},
+		{"include devices", DiskFilter{IncludeDevices: []string{"/dev/sd*"}}, []disk.PartitionStat{root, home, dockerDir}},
+		// "/**" matches the directory itself and everything below it.
+		{"exclude subtree", DiskFilter{ExcludeMountPoints: []string{"/var/lib/docker/**"}}, []disk.PartitionStat{root, home, run, nfs}},
+		// A single "*" does not cross path separators.
+		{"exclude one level", DiskFilter{ExcludeMountPoints: []string{"/var/lib/*"}}, []disk.PartitionStat{root, home, docker, run, nfs}},
+		{"include everything", DiskFilter{IncludeMountPoints: []string{"/**"}}, all},
+		// Excludes win over includes, and every include list must match.
+		{"exclude beats include", DiskFilter{IncludeMountPoints: []string{"/var/**", "/home"}, ExcludeMountPoints: []string{"/var/lib/docker/overlay2/**"}}, []disk.PartitionStat{home, dockerDir}},
+		{"includes combine", DiskFilter{IncludeFSTypes: []string{"ext4", "xfs"}, IncludeMountPoints: []string{"/var/**"}}, []disk.PartitionStat{dockerDir}},
+		{"glob in subtree prefix", DiskFilter{IncludeMountPoints: []string{"/mnt/n*/**"}}, []disk.PartitionStat{nfs}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []disk.PartitionStat
+			for _, p := range all {
+				if tt.filter.Match(p) {
+					got = append(got, p)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v\nwant %v", mountPoints(got), mountPoints(tt.want))
+			}
+		})
+	}
+}
+
+func mountPoints(partitions []disk.PartitionStat) []string {
+	var mounts []string
+	for _, p := range partitions {
+		mounts = append(mounts, p.Mountpoint)
+	}
+	return mounts
+}
+
+func TestLoadDiskFilter(t *testing.T) {
+	f, err := loadDiskFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(f.ExcludeFSTypes, defaultDiskExcludeFSTypes) {
+		t.Errorf("default ExcludeFSTypes = %v", f.ExcludeFSTypes)
+	}
+
+	t.Setenv("DISK_EXCLUDE_FSTYPES", "")
+	t.Setenv("DISK_EXCLUDE_MOUNTPOINTS", " /boot/** , ,/snap/*")
+	f, err = loadDiskFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.ExcludeFSTypes) != 0 || !reflect.DeepEqual(f.ExcludeMountPoints, []string{"/boot/**", "/snap/*"}) {
+		t.Errorf("got %+v", f)
+	}
+
+	t.Setenv("DISK_INCLUDE_DEVICES", "/dev/[sd")
+	if _, err := loadDiskFilter(); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
+
+func TestDiskForecasterObserve(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	const gib = 1 << 30
+
+	tests := []struct {
+		name string
+		used func(minute int) uint64
+		rate float64
+	}{
+		{"growth", func(m int) uint64 { return 10*gib + uint64(m)*60*1000 }, 1000},
+		{"flat", func(m int) uint64 { return 10 * gib }, 0},
+		{"shrinking", func(m int) uint64 { return 10*gib - uint64(m)*60*500 }, -500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newDiskForecaster(time.Hour)
+			var rate float64
+			var ok bool
+			for m := 0; m <= 90; m++ {
+				rate, ok = f.Observe("/", start.Add(time.Duration(m)*time.Minute), tt.used(m))
+				// No forecast until the samples span a tenth of the window.
+				if m < 6 && ok {
+					t.Fatalf("forecast after %d minutes", m)
+				}
+			}
+			if !ok || math.Abs(rate-tt.rate) > 1e-6 {
+				t.Errorf("rate = %v (ok %v), want %v", rate, ok, tt.rate)
+			}
+			// Samples older than the window are dropped.
+			if n := len(f.samples["/"]); n != 61 {
+				t.Errorf("kept %d samples, want 61", n)
+			}
+		})
+	}
+}
+
+func TestForecastFull(t *testing.T) {
+	saved := diskForecasts
+	t.Cleanup(func() { diskForecasts = saved })
+	diskForecasts = newDiskForecaster(time.Hour)
+
+	start := time.Unix(1700000000, 0)
+	var d DiskStats
+	for m := 0; m <= 10; m++ {
+		d = DiskStats{MountPoint: "/data", Used: uint64(m) * 60 * 1000, Free: 3600 * 1000}
+		forecastFull(&d, start.Add(time.Duration(m)*time.Minute))
+	}
+	if d.SecondsUntilFull == nil || math.Abs(*d.SecondsUntilFull-3600) > 1e-6 {
+		t.Fatalf("SecondsUntilFull = %v, want 3600", d.SecondsUntilFull)
+	}
+	if want := start.Add(70 * time.Minute).UTC().Format(time.RFC3339); d.FullAt != want {
+		t.Errorf("FullAt = %s, want %s", d.FullAt, want)
+	}
+
+	// A shrinking mount reports its rate but no time until full.
+	for m := 0; m <= 10; m++ {
+		d = DiskStats{MountPoint: "/scratch", Used: uint64(100-m) * 60 * 1000, Free: uint64(m) * 60 * 1000}
+		forecastFull(&d, start.Add(time.Duration(m)*time.Minute))
+	}
+	if d.GrowthRate >= 0 || d.SecondsUntilFull != nil || d.FullAt != "" {
+		t.Errorf("shrinking mount: %+v", d)
+	}
+}
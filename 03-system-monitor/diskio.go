@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// DiskIOStats holds the I/O counters of one block device and the rates
+// derived from them since the previous collection.
+type DiskIOStats struct {
+	Device      string   `json:"device"`
+	MountPoints []string `json:"mount_points,omitempty"`
+	ReadCount   uint64   `json:"read_count"`
+	WriteCount  uint64   `json:"write_count"`
+	ReadBytes   uint64   `json:"read_bytes"`
+	WriteBytes  uint64   `json:"write_bytes"`
+	ReadTime    uint64   `json:"read_time_ms"`
+	WriteTime   uint64   `json:"write_time_ms"`
+	IOTime      uint64   `json:"io_time_ms"`
+	QueueDepth  uint64   `json:"queue_depth"`
+
+	ReadIOPS       float64 `json:"read_iops"`
+	WriteIOPS      float64 `json:"write_iops"`
+	ReadBytesRate  float64 `json:"read_bytes_per_sec"`
+	WriteBytesRate float64 `json:"write_bytes_per_sec"`
+	Utilization    float64 `json:"utilization_percentage"`
+	AvgLatency     float64 `json:"avg_latency_ms"`
+	AvgQueueSize   float64 `json:"avg_queue_size"`
+}
+
+// diskRates tracks block device counters between collections.
+var diskRates = newCounterRates()
+
+// getDiskIOStats reads per-device I/O counters and joins them to the mount
+// points of partitions, resolving device-mapper symlinks where possible.
+func getDiskIOStats(partitions []disk.PartitionStat) ([]DiskIOStats, error) {
+	counters, err := disk.IOCounters()
+	if err != nil {
+		return nil, fmt.Errorf("error getting disk I/O counters: %v", err)
+	}
+
+	mounts := make(map[string][]string)
+	for _, p := range partitions {
+		name := blockDeviceName(p.Device)
+		mounts[name] = append(mounts[name], p.Mountpoint)
+	}
+
+	now := time.Now()
+	stats := make([]DiskIOStats, 0, len(counters))
+	for name, c := range counters {
+		// rates: reads, writes, read bytes, write bytes, io time, read+write
+		// time and weighted io time, the last three in milliseconds.
+		rates := diskRates.Rates("disk/"+name, now,
+			c.ReadCount, c.WriteCount, c.ReadBytes, c.WriteBytes,
+			c.IoTime, c.ReadTime+c.WriteTime, c.WeightedIO)
+
+		s := DiskIOStats{
+			Device:         name,
+			MountPoints:    mounts[name],
+			ReadCount:      c.ReadCount,
+			WriteCount:     c.WriteCount,
+			ReadBytes:      c.ReadBytes,
+			WriteBytes:     c.WriteBytes,
+			ReadTime:       c.ReadTime,
+			WriteTime:      c.WriteTime,
+			IOTime:         c.IoTime,
+			QueueDepth:     c.IopsInProgress,
+			ReadIOPS:       rates[0],
+			WriteIOPS:      rates[1],
+			ReadBytesRate:  rates[2],
+			WriteBytesRate: rates[3],
+			Utilization:    rates[4] / 10,
+			AvgQueueSize:   rates[6] / 1000,
+		}
+		if s.Utilization > 100 {
+			s.Utilization = 100
+		}
+		if ops := rates[0] + rates[1]; ops > 0 {
+			s.AvgLatency = rates[5] / ops
+		}
+		stats = append(stats, s)
+	}
+	diskRates.Prune(now, nil)
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Device < stats[j].Device })
+	return stats, nil
+}
+
+// blockDeviceName maps a partition device such as /dev/sda1 or
+// /dev/mapper/vg-root to the kernel name used in /proc/diskstats.
+func blockDeviceName(device string) string {
+	if !strings.HasPrefix(device, "/dev/") {
+		return device
+	}
+	if resolved, err := filepath.EvalSymlinks(localMountPoint(device)); err == nil {
+		device = resolved
+	}
+	return filepath.Base(device)
+}
@@ -2,7 +2,10 @@ module system-monitor
 
 go 1.19
 
-require github.com/shirou/gopsutil/v3 v3.23.10
+require (
+	github.com/shirou/gopsutil/v3 v3.23.10
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/go-ole/go-ole v1.2.6 // indirect
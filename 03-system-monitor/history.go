@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HistoryTier describes one level of the history ring buffer: samples are
+// folded into buckets of Resolution width and kept for Retention.
+type HistoryTier struct {
+	Resolution time.Duration
+	Retention  time.Duration
+}
+
+// HistorySeries is the response body of /metrics/history for one metric.
+type HistorySeries struct {
+	Metric string         `json:"metric"`
+	From   string         `json:"from"`
+	To     string         `json:"to"`
+	Step   float64        `json:"step_seconds"`
+	Points []HistoryPoint `json:"points"`
+}
+
+type HistoryPoint struct {
+	Timestamp string  `json:"timestamp"`
+	Min       float64 `json:"min"`
+	Avg       float64 `json:"avg"`
+	Max       float64 `json:"max"`
+	Count     int     `json:"count"`
+}
+
+type aggregate struct {
+	min, max, sum float64
+	count         int
+}
+
+func (a *aggregate) add(v float64) {
+	if a.count == 0 || v < a.min {
+		a.min = v
+	}
+	if a.count == 0 || v > a.max {
+		a.max = v
+	}
+	a.sum += v
+	a.count++
+}
+
+func (a *aggregate) merge(o *aggregate) {
+	if o.count == 0 {
+		return
+	}
+	if a.count == 0 || o.min < a.min {
+		a.min = o.min
+	}
+	if a.count == 0 || o.max > a.max {
+		a.max = o.max
+	}
+	a.sum += o.sum
+	a.count += o.count
+}
+
+type bucket struct {
+	start  time.Time
+	values map[string]*aggregate
+}
+
+// tierRing is a fixed-size ring of buckets for a single HistoryTier.
+type tierRing struct {
+	HistoryTier
+	buckets []bucket
+	head    int
+	size    int
+}
+
+func newTierRing(tier HistoryTier) *tierRing {
+	capacity := int(tier.Retention / tier.Resolution)
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &tierRing{HistoryTier: tier, buckets: make([]bucket, capacity)}
+}
+
+func (r *tierRing) add(t time.Time, values map[string]float64) {
+	start := t.Truncate(r.Resolution)
+	if r.size == 0 || start.After(r.buckets[r.head].start) {
+		if r.size > 0 {
+			r.head = (r.head + 1) % len(r.buckets)
+		}
+		if r.size < len(r.buckets) {
+			r.size++
+		}
+		r.buckets[r.head] = bucket{start: start, values: make(map[string]*aggregate, len(values))}
+	}
+
+	// Samples that arrive out of order are folded into the newest bucket.
+	b := &r.buckets[r.head]
+	for name, v := range values {
+		agg, ok := b.values[name]
+		if !ok {
+			agg = &aggregate{}
+			b.values[name] = agg
+		}
+		agg.add(v)
+	}
+}
+
+// each calls fn for every retained bucket from oldest to newest.
+func (r *tierRing) each(fn func(b *bucket)) {
+	for i := r.size - 1; i >= 0; i-- {
+		idx := (r.head - i + len(r.buckets)) % len(r.buckets)
+		fn(&r.buckets[idx])
+	}
+}
+
+// history keeps recent samples in memory at several resolutions so spikes
+// can be diagnosed after the fact without an external TSDB.
+type history struct {
+	mu    sync.RWMutex
+	tiers []*tierRing
+}
+
+func newHistory(tiers []HistoryTier) *history {
+	h := &history{}
+	for _, tier := range tiers {
+		h.tiers = append(h.tiers, newTierRing(tier))
+	}
+	return h
+}
+
+// Add records one sample in every tier.
+func (h *history) Add(t time.Time, values map[string]float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, r := range h.tiers {
+		r.add(t, values)
+	}
+}
+
+// Metrics returns the names of all metrics present in the finest tier.
+func (h *history) Metrics() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	seen := make(map[string]float64)
+	if len(h.tiers) > 0 {
+		h.tiers[0].each(func(b *bucket) {
+			for name := range b.values {
+				seen[name] = 0
+			}
+		})
+	}
+	return sortedKeys(seen)
+}
+
+// Query returns min/avg/max points for metric between from and to. It reads
+// from the finest tier whose retention still covers from, and re-aggregates
+// into step-sized buckets when step is coarser than that tier's resolution.
+func (h *history) Query(metric string, from, to time.Time, step time.Duration) (*HistorySeries, error) {
+	if !from.Before(to) {
+		return nil, fmt.Errorf("from must be before to")
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if len(h.tiers) == 0 {
+		return nil, fmt.Errorf("history is disabled")
+	}
+
+	ring := h.tiers[len(h.tiers)-1]
+	now := time.Now()
+	for _, r := range h.tiers {
+		if !from.Before(now.Add(-r.Retention)) {
+			ring = r
+			break
+		}
+	}
+
+	if step < ring.Resolution {
+		step = ring.Resolution
+	}
+
+	var starts []time.Time
+	merged := make(map[time.Time]*aggregate)
+	ring.each(func(b *bucket) {
+		if b.start.Before(from.Truncate(ring.Resolution)) || b.start.After(to) {
+			return
+		}
+		agg, ok := b.values[metric]
+		if !ok {
+			return
+		}
+		key := b.start.Truncate(step)
+		target, ok := merged[key]
+		if !ok {
+			target = &aggregate{}
+			merged[key] = target
+			starts = append(starts, key)
+		}
+		target.merge(agg)
+	})
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	series := &HistorySeries{
+		Metric: metric,
+		From:   from.UTC().Format(time.RFC3339),
+		To:     to.UTC().Format(time.RFC3339),
+		Step:   step.Seconds(),
+		Points: make([]HistoryPoint, 0, len(starts)),
+	}
+	for _, start := range starts {
+		agg := merged[start]
+		series.Points = append(series.Points, HistoryPoint{
+			Timestamp: start.UTC().Format(time.RFC3339),
+			Min:       agg.min,
+			Avg:       agg.sum / float64(agg.count),
+			Max:       agg.max,
+			Count:     agg.count,
+		})
+	}
+	return series, nil
+}
+
+// parseHistoryTiers parses a tier list such as "1s:10m,1m:24h".
+func parseHistoryTiers(spec string) ([]HistoryTier, error) {
+	var tiers []HistoryTier
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := strings.SplitN(part, ":", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid history tier %q, expected resolution:retention", part)
+		}
+		resolution, err := time.ParseDuration(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid resolution in history tier %q: %v", part, err)
+		}
+		retention, err := time.ParseDuration(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid retention in history tier %q: %v", part, err)
+		}
+		if resolution <= 0 || retention < resolution {
+			return nil, fmt.Errorf("invalid history tier %q, retention must be at least one resolution", part)
+		}
+		if n := len(tiers); n > 0 && resolution <= tiers[n-1].Resolution {
+			return nil, fmt.Errorf("history tiers must be ordered from finest to coarsest resolution")
+		}
+		tiers = append(tiers, HistoryTier{Resolution: resolution, Retention: retention})
+	}
+	return tiers, nil
+}
+
+// parseHistoryTime accepts RFC3339 timestamps, unix seconds, or a negative
+// duration relative to now such as "-15m".
+func parseHistoryTime(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(secs*float64(time.Second))), nil
+	}
+	if d, err := time.ParseDuration(value); err == nil && d <= 0 {
+		return now.Add(d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
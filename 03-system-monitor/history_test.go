@@ -0,0 +1,164 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// filledHistory returns a history with a 1s tier kept for 40s and a 10s
+// tier kept for 2m, fed one sample per second over the minute before end,
+// where the value of the cpu metric is the sample's index.
+func filledHistory(end time.Time) *history {
+	h := newHistory([]HistoryTier{
+		{Resolution: time.Second, Retention: 40 * time.Second},
+		{Resolution: 10 * time.Second, Retention: 2 * time.Minute},
+	})
+	for i := 0; i < 60; i++ {
+		h.Add(end.Add(time.Duration(i-60)*time.Second), map[string]float64{"cpu": float64(i)})
+	}
+	return h
+}
+
+func TestHistoryQuery(t *testing.T) {
+	// Query picks its tier relative to the wall clock, so the samples end
+	// at most 20s before now.
+	end := time.Now().Truncate(20 * time.Second)
+	h := filledHistory(end)
+	point := func(offset time.Duration, min, avg, max float64, count int) HistoryPoint {
+		return HistoryPoint{Timestamp: end.Add(offset).UTC().Format(time.RFC3339), Min: min, Avg: avg, Max: max, Count: count}
+	}
+
+	tests := []struct {
+		name     string
+		from     time.Duration
+		step     time.Duration
+		wantStep float64
+		want     []HistoryPoint
+	}{
+		{
+			name: "fine tier re-aggregated", from: -20 * time.Second, step: 5 * time.Second, wantStep: 5,
+			want: []HistoryPoint{
+				point(-20*time.Second, 40, 42, 44, 5),
+				point(-15*time.Second, 45, 47, 49, 5),
+				point(-10*time.Second, 50, 52, 54, 5),
+				point(-5*time.Second, 55, 57, 59, 5),
+			},
+		},
+		{
+			name: "step below resolution", from: -3 * time.Second, step: time.Millisecond, wantStep: 1,
+			want: []HistoryPoint{
+				point(-3*time.Second, 57, 57, 57, 1),
+				point(-2*time.Second, 58, 58, 58, 1),
+				point(-1*time.Second, 59, 59, 59, 1),
+			},
+		},
+		{
+			// The fine tier has already dropped these samples.
+			name: "coarse tier beyond fine retention", from: -60 * time.Second, step: 20 * time.Second, wantStep: 20,
+			want: []HistoryPoint{
+				point(-60*time.Second, 0, 9.5, 19, 20),
+				point(-40*time.Second, 20, 29.5, 39, 20),
+				point(-20*time.Second, 40, 49.5, 59, 20),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Steps are aligned to the epoch, so every case starts on one.
+			if end.Add(tt.from).Truncate(tt.step) != end.Add(tt.from) {
+				t.Fatalf("from is not aligned to the step")
+			}
+			series, err := h.Query("cpu", end.Add(tt.from), end, tt.step)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if series.Step != tt.wantStep {
+				t.Errorf("step = %v, want %v", series.Step, tt.wantStep)
+			}
+			if !reflect.DeepEqual(series.Points, tt.want) {
+				t.Errorf("got %+v\nwant %+v", series.Points, tt.want)
+			}
+		})
+	}
+
+	if series, err := h.Query("missing", end.Add(-time.Minute), end, 0); err != nil || len(series.Points) != 0 {
+		t.Errorf("unknown metric: got %+v, %v", series, err)
+	}
+	if _, err := h.Query("cpu", end, end.Add(-time.Minute), 0); err == nil {
+		t.Error("expected an error for from after to")
+	}
+	if got := h.Metrics(); !reflect.DeepEqual(got, []string{"cpu"}) {
+		t.Errorf("Metrics() = %v", got)
+	}
+}
+
+func TestTierRingWrapsAndFoldsLateSamples(t *testing.T) {
+	r := newTierRing(HistoryTier{Resolution: time.Second, Retention: 3 * time.Second})
+	base := time.Unix(1700000000, 0)
+	for i := 0; i < 5; i++ {
+		r.add(base.Add(time.Duration(i)*time.Second), map[string]float64{"m": float64(i)})
+	}
+	// A sample older than the newest bucket is folded into it.
+	r.add(base, map[string]float64{"m": 10})
+
+	var got []HistoryPoint
+	r.each(func(b *bucket) {
+		agg := b.values["m"]
+		got = append(got, HistoryPoint{Timestamp: b.start.UTC().Format(time.RFC3339), Min: agg.min, Max: agg.max, Count: agg.count})
+	})
+	at := func(i int) string { return base.Add(time.Duration(i) * time.Second).UTC().Format(time.RFC3339) }
+	want := []HistoryPoint{
+		{Timestamp: at(2), Min: 2, Max: 2, Count: 1},
+		{Timestamp: at(3), Min: 3, Max: 3, Count: 1},
+		{Timestamp: at(4), Min: 4, Max: 10, Count: 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestParseHistoryTiers(t *testing.T) {
+	tiers, err := parseHistoryTiers("1s:10m, 1m:24h,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []HistoryTier{{time.Second, 10 * time.Minute}, {time.Minute, 24 * time.Hour}}
+	if !reflect.DeepEqual(tiers, want) {
+		t.Errorf("got %v, want %v", tiers, want)
+	}
+	for _, spec := range []string{"1s", "x:10m", "1s:x", "10s:1s", "0s:1m", "1m:1h,1s:10m"} {
+		if _, err := parseHistoryTiers(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestParseHistoryTime(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"2024-01-01T00:00:00Z", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"1700000000", time.Unix(1700000000, 0)},
+		{"1700000000.5", time.Unix(1700000000, 500000000)},
+		{"-15m", now.Add(-15 * time.Minute)},
+		{"0s", now},
+	}
+	for _, tt := range tests {
+		got, err := parseHistoryTime(tt.value, now)
+		if err != nil {
+			t.Errorf("%q: %v", tt.value, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.value, got, tt.want)
+		}
+	}
+	for _, value := range []string{"15m", "yesterday", ""} {
+		if _, err := parseHistoryTime(value, now); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
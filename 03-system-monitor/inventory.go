@@ -0,0 +1,701 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+// defaultInventoryInterval is how often the inventory is collected in the
+// background so changes are noticed even when nobody asks for it.
+const defaultInventoryInterval = 10 * time.Minute
+
+// maxInventoryChanges is how many recent inventory changes are kept.
+const maxInventoryChanges = 200
+
+// Inventory describes the hardware and software of the host. Unlike
+// SystemStats it changes rarely; differences between two inventories are
+// reported as changes.
+type Inventory struct {
+	Timestamp      string            `json:"timestamp"`
+	Host           HostInventory     `json:"host"`
+	Virtualization Virtualization    `json:"virtualization"`
+	CPU            CPUInventory      `json:"cpu"`
+	Memory         MemoryInventory   `json:"memory"`
+	Sensors        []Sensor          `json:"sensors"`
+	Users          []LoggedInUser    `json:"users"`
+	KernelModules  []KernelModule    `json:"kernel_modules"`
+	Errors         map[string]string `json:"errors,omitempty"`
+	Changes        []InventoryChange `json:"changes"`
+}
+
+type HostInventory struct {
+	Hostname        string `json:"hostname"`
+	HostID          string `json:"host_id,omitempty"`
+	OS              string `json:"os"`
+	Platform        string `json:"platform"`
+	PlatformFamily  string `json:"platform_family,omitempty"`
+	PlatformVersion string `json:"platform_version"`
+	KernelVersion   string `json:"kernel_version"`
+	KernelArch      string `json:"kernel_arch"`
+	BootTime        string `json:"boot_time"`
+	Uptime          uint64 `json:"uptime"`
+	// Firmware details from /sys/class/dmi/id, where the platform has them.
+	SystemVendor string `json:"system_vendor,omitempty"`
+	ProductName  string `json:"product_name,omitempty"`
+	BoardName    string `json:"board_name,omitempty"`
+	BIOSVendor   string `json:"bios_vendor,omitempty"`
+	BIOSVersion  string `json:"bios_version,omitempty"`
+	BIOSDate     string `json:"bios_date,omitempty"`
+}
+
+// Virtualization tells whether the host is a virtual machine or itself a
+// container, and whether the monitor runs in a container.
+type Virtualization struct {
+	System    string `json:"system,omitempty"`
+	Role      string `json:"role,omitempty"`
+	VM        bool   `json:"vm"`
+	Container bool   `json:"container"`
+	// MonitorContainer is the runtime the monitor itself runs in, if any.
+	MonitorContainer string `json:"monitor_container,omitempty"`
+}
+
+type CPUInventory struct {
+	ModelName     string    `json:"model_name"`
+	Vendor        string    `json:"vendor,omitempty"`
+	Family        string    `json:"family,omitempty"`
+	Model         string    `json:"model,omitempty"`
+	Stepping      int32     `json:"stepping,omitempty"`
+	Microcode     string    `json:"microcode,omitempty"`
+	Sockets       int       `json:"sockets"`
+	PhysicalCores int       `json:"physical_cores"`
+	LogicalCores  int       `json:"logical_cores"`
+	CacheSize     uint64    `json:"cache_size,omitempty"`
+	MinMHz        float64   `json:"min_mhz,omitempty"`
+	MaxMHz        float64   `json:"max_mhz,omitempty"`
+	CurrentMHz    []float64 `json:"current_mhz,omitempty"`
+	Governor      string    `json:"governor,omitempty"`
+	Flags         []string  `json:"flags"`
+}
+
+type MemoryInventory struct {
+	Total          uint64     `json:"total"`
+	SwapTotal      uint64     `json:"swap_total"`
+	HugePagesTotal uint64     `json:"huge_pages_total"`
+	HugePageSize   uint64     `json:"huge_page_size"`
+	NUMANodes      []NUMANode `json:"numa_nodes,omitempty"`
+	// Slots and Modules come from the SMBIOS tables, which are usually
+	// only readable by root.
+	Slots   int            `json:"slots,omitempty"`
+	Modules []MemoryModule `json:"modules,omitempty"`
+}
+
+type NUMANode struct {
+	Node  int    `json:"node"`
+	Total uint64 `json:"total"`
+	CPUs  string `json:"cpus"`
+}
+
+// MemoryModule is one populated memory slot.
+type MemoryModule struct {
+	Locator      string `json:"locator"`
+	Bank         string `json:"bank,omitempty"`
+	Type         string `json:"type,omitempty"`
+	Size         uint64 `json:"size"`
+	SpeedMTs     uint16 `json:"speed_mts,omitempty"`
+	Manufacturer string `json:"manufacturer,omitempty"`
+	PartNumber   string `json:"part_number,omitempty"`
+}
+
+// Sensor is a temperature sensor in degrees Celsius.
+type Sensor struct {
+	Key         string  `json:"key"`
+	Temperature float64 `json:"temperature"`
+	High        float64 `json:"high,omitempty"`
+	Critical    float64 `json:"critical,omitempty"`
+}
+
+type LoggedInUser struct {
+	User     string `json:"user"`
+	Terminal string `json:"terminal"`
+	Host     string `json:"host,omitempty"`
+	Started  string `json:"started"`
+}
+
+type KernelModule struct {
+	Name      string   `json:"name"`
+	Size      uint64   `json:"size"`
+	Instances int      `json:"instances"`
+	UsedBy    []string `json:"used_by,omitempty"`
+	State     string   `json:"state"`
+}
+
+// InventoryChange is a difference between two inventories, e.g. a loaded
+// kernel module, a new kernel version after a reboot or a user logging in.
+type InventoryChange struct {
+	Time    string `json:"time"`
+	Section string `json:"section"`
+	Item    string `json:"item"`
+	Change  string `json:"change"`
+	Old     string `json:"old,omitempty"`
+	New     string `json:"new,omitempty"`
+}
+
+// Inventory change kinds.
+const (
+	InventoryAdded   = "added"
+	InventoryRemoved = "removed"
+	InventoryChanged = "changed"
+)
+
+// inventoryTracker collects inventories and keeps the changes between them.
+type inventoryTracker struct {
+	interval time.Duration
+
+	// mu is held for a whole collection so changes are found in order.
+	mu      sync.Mutex
+	last    *Inventory
+	changes []InventoryChange
+}
+
+var inventory *inventoryTracker
+
+func loadInventoryTracker() (*inventoryTracker, error) {
+	interval, err := envDuration("INVENTORY_INTERVAL", defaultInventoryInterval)
+	if err != nil {
+		return nil, err
+	}
+	return &inventoryTracker{interval: interval}, nil
+}
+
+// run collects the inventory on every interval.
+func (t *inventoryTracker) run() {
+	for {
+		if _, err := t.Collect(context.Background()); err != nil {
+			log.Printf("Warning: Could not collect inventory: %v", err)
+		}
+		time.Sleep(t.interval)
+	}
+}
+
+// Collect gathers a new inventory, records how it differs from the previous
+// one and returns it with the recent changes.
+func (t *inventoryTracker) Collect(ctx context.Context) (*Inventory, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	inv, err := collectInventory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if t.last != nil {
+		changes := diffInventory(t.last, inv, inv.Timestamp)
+		for _, c := range changes {
+			log.Printf("Inventory change: %s %s %s%s", c.Section, c.Item, c.Change, describeChange(c))
+		}
+		t.changes = append(t.changes, changes...)
+		if len(t.changes) > maxInventoryChanges {
+			t.changes = t.changes[len(t.changes)-maxInventoryChanges:]
+		}
+	}
+	t.last = inv
+
+	result := *inv
+	result.Changes = append([]InventoryChange{}, t.changes...)
+	return &result, nil
+}
+
+func describeChange(c InventoryChange) string {
+	switch {
+	case c.Old != "" && c.New != "":
+		return fmt.Sprintf(" (%s -> %s)", c.Old, c.New)
+	case c.New != "":
+		return " (" + c.New + ")"
+	case c.Old != "":
+		return " (" + c.Old + ")"
+	}
+	return ""
+}
+
+// collectInventory gathers every section. Only a failing host section is
+// an error; other sections report their errors in Inventory.Errors.
+func collectInventory(ctx context.Context) (*Inventory, error) {
+	info, err := host.InfoWithContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting host info: %v", err)
+	}
+	inv := &Inventory{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Host: HostInventory{
+			Hostname:        hostHostname(info.Hostname),
+			HostID:          info.HostID,
+			OS:              info.OS,
+			Platform:        info.Platform,
+			PlatformFamily:  info.PlatformFamily,
+			PlatformVersion: info.PlatformVersion,
+			KernelVersion:   info.KernelVersion,
+			KernelArch:      info.KernelArch,
+			BootTime:        time.Unix(int64(info.BootTime), 0).UTC().Format(time.RFC3339),
+			Uptime:          info.Uptime,
+			SystemVendor:    readDMI("sys_vendor"),
+			ProductName:     readDMI("product_name"),
+			BoardName:       readDMI("board_name"),
+			BIOSVendor:      readDMI("bios_vendor"),
+			BIOSVersion:     readDMI("bios_version"),
+			BIOSDate:        readDMI("bios_date"),
+		},
+		Sensors:       []Sensor{},
+		Users:         []LoggedInUser{},
+		KernelModules: []KernelModule{},
+		Errors:        make(map[string]string),
+	}
+
+	if inv.CPU, err = collectCPUInventory(ctx); err != nil {
+		inv.Errors["cpu"] = err.Error()
+	}
+	if inv.Memory, err = collectMemoryInventory(ctx); err != nil {
+		inv.Errors["memory"] = err.Error()
+	}
+	inv.Virtualization = detectVirtualization(info, inv)
+
+	temps, err := host.SensorsTemperaturesWithContext(ctx)
+	// Unreadable sensors are reported as warnings next to the readable ones.
+	if err != nil && len(temps) == 0 {
+		inv.Errors["sensors"] = err.Error()
+	}
+	for _, t := range temps {
+		inv.Sensors = append(inv.Sensors, Sensor{Key: t.SensorKey, Temperature: t.Temperature, High: t.High, Critical: t.Critical})
+	}
+	sort.Slice(inv.Sensors, func(i, j int) bool { return inv.Sensors[i].Key < inv.Sensors[j].Key })
+
+	if users, err := readUtmp(runPath("utmp")); err != nil && !os.IsNotExist(err) {
+		inv.Errors["users"] = err.Error()
+	} else if users != nil {
+		inv.Users = users
+	}
+
+	if modules, err := readKernelModules(procPath("modules")); err != nil && !os.IsNotExist(err) {
+		inv.Errors["kernel_modules"] = err.Error()
+	} else if modules != nil {
+		inv.KernelModules = modules
+	}
+
+	if len(inv.Errors) == 0 {
+		inv.Errors = nil
+	}
+	return inv, nil
+}
+
+func readDMI(name string) string {
+	return readString(sysPath("class", "dmi", "id", name))
+}
+
+func collectCPUInventory(ctx context.Context) (CPUInventory, error) {
+	infos, err := cpu.InfoWithContext(ctx)
+	if err != nil {
+		return CPUInventory{}, fmt.Errorf("error getting CPU info: %v", err)
+	}
+	c := CPUInventory{LogicalCores: len(infos), Flags: []string{}}
+	if len(infos) > 0 {
+		first := infos[0]
+		c.ModelName, c.Vendor, c.Family, c.Model = first.ModelName, first.VendorID, first.Family, first.Model
+		c.Stepping, c.Microcode = first.Stepping, first.Microcode
+		c.CacheSize = uint64(first.CacheSize) * 1024
+		c.Flags = append(c.Flags, first.Flags...)
+		sort.Strings(c.Flags)
+	}
+
+	sockets := make(map[string]bool)
+	cores := make(map[string]bool)
+	for _, info := range infos {
+		sockets[info.PhysicalID] = true
+		cores[info.PhysicalID+"/"+info.CoreID] = true
+	}
+	c.Sockets, c.PhysicalCores = len(sockets), len(cores)
+	if c.PhysicalCores <= 1 && c.LogicalCores > 1 {
+		// Without topology in /proc/cpuinfo, e.g. on ARM, ask sysfs.
+		if n, err := cpu.CountsWithContext(ctx, false); err == nil && n > 0 {
+			c.PhysicalCores = n
+		}
+	}
+
+	// Frequencies are in kHz in sysfs.
+	c.MinMHz = float64(readUint(sysPath("devices", "system", "cpu", "cpu0", "cpufreq", "cpuinfo_min_freq"))) / 1000
+	c.MaxMHz = float64(readUint(sysPath("devices", "system", "cpu", "cpu0", "cpufreq", "cpuinfo_max_freq"))) / 1000
+	c.Governor = readString(sysPath("devices", "system", "cpu", "cpu0", "cpufreq", "scaling_governor"))
+	for _, info := range infos {
+		khz := readUint(sysPath("devices", "system", "cpu", fmt.Sprintf("cpu%d", info.CPU), "cpufreq", "scaling_cur_freq"))
+		if khz == 0 {
+			break
+		}
+		c.CurrentMHz = append(c.CurrentMHz, float64(khz)/1000)
+	}
+	return c, nil
+}
+
+func collectMemoryInventory(ctx context.Context) (MemoryInventory, error) {
+	vm, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return MemoryInventory{}, fmt.Errorf("error getting memory info: %v", err)
+	}
+	m := MemoryInventory{
+		Total:          vm.Total,
+		SwapTotal:      vm.SwapTotal,
+		HugePagesTotal: vm.HugePagesTotal,
+		HugePageSize:   vm.HugePageSize,
+		NUMANodes:      readNUMANodes(sysPath("devices", "system", "node")),
+	}
+	m.Slots, m.Modules = readMemoryModules(sysPath("firmware", "dmi", "entries"))
+	return m, nil
+}
+
+// readNUMANodes reads node*/meminfo and node*/cpulist below dir.
+func readNUMANodes(dir string) []NUMANode {
+	paths, _ := filepath.Glob(filepath.Join(dir, "node[0-9]*"))
+	var nodes []NUMANode
+	for _, path := range paths {
+		n, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(path), "node"))
+		if err != nil {
+			continue
+		}
+		node := NUMANode{Node: n, CPUs: readString(filepath.Join(path, "cpulist"))}
+		// Lines look like "Node 0 MemTotal:       16318480 kB".
+		for _, line := range readLines(filepath.Join(path, "meminfo")) {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[2] == "MemTotal:" {
+				kb, _ := strconv.ParseUint(fields[3], 10, 64)
+				node.Total = kb * 1024
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Node < nodes[j].Node })
+	return nodes
+}
+
+// smbiosMemoryTypes names the memory types of SMBIOS type 17 entries.
+var smbiosMemoryTypes = map[byte]string{
+	0x03: "DRAM", 0x07: "RAM", 0x0F: "SDRAM", 0x12: "DDR", 0x13: "DDR2",
+	0x14: "DDR2 FB-DIMM", 0x18: "DDR3", 0x1A: "DDR4", 0x1B: "LPDDR",
+	0x1C: "LPDDR2", 0x1D: "LPDDR3", 0x1E: "LPDDR4", 0x20: "HBM",
+	0x21: "HBM2", 0x22: "DDR5", 0x23: "LPDDR5",
+}
+
+// readMemoryModules reads the SMBIOS memory device entries (type 17) below
+// dir and returns the number of slots and the populated ones.
+func readMemoryModules(dir string) (int, []MemoryModule) {
+	paths, _ := filepath.Glob(filepath.Join(dir, "17-*", "raw"))
+	slots := 0
+	var modules []MemoryModule
+	for _, path := range paths {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		module, ok := parseMemoryDevice(raw)
+		if !ok {
+			continue
+		}
+		slots++
+		if module.Size > 0 {
+			modules = append(modules, module)
+		}
+	}
+	sort.Slice(modules, func(i, j int) bool { return modules[i].Locator < modules[j].Locator })
+	return slots, modules
+}
+
+// parseMemoryDevice decodes an SMBIOS memory device structure: a formatted
+// area followed by the NUL-separated strings it refers to by index. A
+// zero Size means the slot is empty.
+func parseMemoryDevice(raw []byte) (MemoryModule, bool) {
+	if len(raw) < 0x15 || raw[0] != 17 || int(raw[1]) > len(raw) || raw[1] < 0x15 {
+		return MemoryModule{}, false
+	}
+	length := int(raw[1])
+	strs := strings.Split(string(raw[length:]), "\x00")
+	str := func(offset int) string {
+		if offset >= length || raw[offset] == 0 || int(raw[offset]) > len(strs) {
+			return ""
+		}
+		return strings.TrimSpace(strs[raw[offset]-1])
+	}
+
+	m := MemoryModule{
+		Locator:      str(0x10),
+		Bank:         str(0x11),
+		Type:         smbiosMemoryTypes[raw[0x12]],
+		Manufacturer: str(0x17),
+		PartNumber:   str(0x1A),
+	}
+	switch size := binary.LittleEndian.Uint16(raw[0x0C:]); {
+	case size == 0 || size == 0xFFFF:
+	case size == 0x7FFF && length >= 0x20:
+		m.Size = uint64(binary.LittleEndian.Uint32(raw[0x1C:])&0x7FFFFFFF) << 20
+	case size&0x8000 != 0:
+		m.Size = uint64(size&0x7FFF) << 10
+	default:
+		m.Size = uint64(size) << 20
+	}
+	if length >= 0x17 {
+		m.SpeedMTs = binary.LittleEndian.Uint16(raw[0x15:])
+	}
+	return m, true
+}
+
+// readKernelModules parses /proc/modules, whose lines look like
+// "nf_nat 49152 3 nft_chain_nat,xt_MASQUERADE, Live 0x0000000000000000".
+func readKernelModules(path string) ([]KernelModule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	modules := []KernelModule{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		m := KernelModule{Name: fields[0], State: fields[4]}
+		m.Size, _ = strconv.ParseUint(fields[1], 10, 64)
+		m.Instances, _ = strconv.Atoi(fields[2])
+		if fields[3] != "-" {
+			for _, user := range strings.Split(fields[3], ",") {
+				if user != "" {
+					m.UsedBy = append(m.UsedBy, user)
+				}
+			}
+		}
+		modules = append(modules, m)
+	}
+	sort.Slice(modules, func(i, j int) bool { return modules[i].Name < modules[j].Name })
+	return modules, nil
+}
+
+// utmp record layout of glibc on 64-bit and 32-bit Linux alike.
+const (
+	utmpRecordSize  = 384
+	utmpUserProcess = 7
+)
+
+// readUtmp returns the logged-in users from a utmp file. The host's
+// /run/utmp is read directly rather than through gopsutil, which looks for
+// it below /var, a symlink that resolves inside the monitor's container.
+func readUtmp(path string) ([]LoggedInUser, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cstring := func(b []byte) string {
+		if i := strings.IndexByte(string(b), 0); i >= 0 {
+			b = b[:i]
+		}
+		return string(b)
+	}
+	users := []LoggedInUser{}
+	for off := 0; off+utmpRecordSize <= len(data); off += utmpRecordSize {
+		rec := data[off : off+utmpRecordSize]
+		if binary.LittleEndian.Uint16(rec[0:]) != utmpUserProcess {
+			continue
+		}
+		users = append(users, LoggedInUser{
+			User:     cstring(rec[44:76]),
+			Terminal: cstring(rec[8:40]),
+			Host:     cstring(rec[76:332]),
+			Started:  time.Unix(int64(int32(binary.LittleEndian.Uint32(rec[340:]))), 0).UTC().Format(time.RFC3339),
+		})
+	}
+	return users, nil
+}
+
+// containerSystems are virtualization systems gopsutil reports for
+// containers rather than virtual machines.
+var containerSystems = map[string]bool{
+	"docker": true, "lxc": true, "openvz": true, "podman": true,
+	"rkt": true, "systemd-nspawn": true, "linux-vserver": true,
+}
+
+// vmProducts maps firmware vendor and product names to hypervisors.
+var vmProducts = []struct{ pattern, system string }{
+	{"QEMU", "kvm"}, {"KVM", "kvm"}, {"Amazon EC2", "kvm"}, {"Google Compute Engine", "kvm"},
+	{"VMware", "vmware"}, {"VirtualBox", "vbox"}, {"Microsoft Corporation Virtual Machine", "hyperv"},
+}
+
+// detectVirtualization combines gopsutil's detection, which reads the
+// host's /proc, with the CPU's hypervisor flag and the firmware vendor.
+func detectVirtualization(info *host.InfoStat, inv *Inventory) Virtualization {
+	v := Virtualization{System: info.VirtualizationSystem, Role: info.VirtualizationRole}
+	if v.Role == "guest" {
+		v.Container = containerSystems[v.System]
+		v.VM = !v.Container
+	}
+	for _, flag := range inv.CPU.Flags {
+		if flag == "hypervisor" {
+			v.VM = true
+		}
+	}
+	firmware := inv.Host.SystemVendor + " " + inv.Host.ProductName
+	for _, p := range vmProducts {
+		if strings.Contains(firmware, p.pattern) {
+			v.VM = true
+			if v.System == "" {
+				v.System = p.system
+			}
+			break
+		}
+	}
+	if v.VM && v.Role == "" {
+		v.Role = "guest"
+	}
+	v.MonitorContainer = monitorContainerRuntime()
+	return v
+}
+
+// monitorContainerRuntime tells whether the monitor itself runs in a
+// container, looking at its own filesystem and cgroup rather than the host's.
+func monitorContainerRuntime() string {
+	if fileExists("/.dockerenv") {
+		return "docker"
+	}
+	if fileExists("/run/.containerenv") {
+		return "podman"
+	}
+	cgroup := readString("/proc/self/cgroup")
+	for _, runtime := range []string{"kubepods", "docker", "libpod", "containerd", "lxc"} {
+		if strings.Contains(cgroup, runtime) {
+			if runtime == "kubepods" {
+				return "kubernetes"
+			}
+			return runtime
+		}
+	}
+	return ""
+}
+
+// diffInventory lists what changed between two inventories. Readings such
+// as temperatures, frequencies and uptime are not compared, and sections
+// that failed in either inventory are skipped.
+func diffInventory(old, cur *Inventory, now string) []InventoryChange {
+	var changes []InventoryChange
+	field := func(section, item, o, n string) {
+		if o != n {
+			changes = append(changes, InventoryChange{Time: now, Section: section, Item: item, Change: InventoryChanged, Old: o, New: n})
+		}
+	}
+	set := func(section string, o, n []string) {
+		was := make(map[string]bool, len(o))
+		for _, item := range o {
+			was[item] = true
+		}
+		is := make(map[string]bool, len(n))
+		for _, item := range n {
+			is[item] = true
+			if !was[item] {
+				changes = append(changes, InventoryChange{Time: now, Section: section, Item: item, Change: InventoryAdded})
+			}
+		}
+		for _, item := range o {
+			if !is[item] {
+				changes = append(changes, InventoryChange{Time: now, Section: section, Item: item, Change: InventoryRemoved})
+			}
+		}
+	}
+	failed := func(section string) bool {
+		return old.Errors[section] != "" || cur.Errors[section] != ""
+	}
+	itoa := func(n uint64) string { return strconv.FormatUint(n, 10) }
+
+	field("host", "hostname", old.Host.Hostname, cur.Host.Hostname)
+	field("host", "platform_version", old.Host.PlatformVersion, cur.Host.PlatformVersion)
+	field("host", "kernel_version", old.Host.KernelVersion, cur.Host.KernelVersion)
+	field("host", "boot_time", old.Host.BootTime, cur.Host.BootTime)
+	field("host", "bios_version", old.Host.BIOSVersion, cur.Host.BIOSVersion)
+
+	field("virtualization", "system", old.Virtualization.System, cur.Virtualization.System)
+	field("virtualization", "role", old.Virtualization.Role, cur.Virtualization.Role)
+
+	if !failed("cpu") {
+		field("cpu", "model_name", old.CPU.ModelName, cur.CPU.ModelName)
+		field("cpu", "microcode", old.CPU.Microcode, cur.CPU.Microcode)
+		field("cpu", "logical_cores", strconv.Itoa(old.CPU.LogicalCores), strconv.Itoa(cur.CPU.LogicalCores))
+		set("cpu_flags", old.CPU.Flags, cur.CPU.Flags)
+	}
+	if !failed("memory") {
+		field("memory", "total", itoa(old.Memory.Total), itoa(cur.Memory.Total))
+		field("memory", "swap_total", itoa(old.Memory.SwapTotal), itoa(cur.Memory.SwapTotal))
+		field("memory", "huge_pages_total", itoa(old.Memory.HugePagesTotal), itoa(cur.Memory.HugePagesTotal))
+		describe := func(modules []MemoryModule) []string {
+			var items []string
+			for _, m := range modules {
+				items = append(items, fmt.Sprintf("%s: %s %s %s", m.Locator, formatBytes(m.Size), m.Type, m.PartNumber))
+			}
+			return items
+		}
+		set("memory_modules", describe(old.Memory.Modules), describe(cur.Memory.Modules))
+	}
+	if !failed("sensors") {
+		keys := func(sensors []Sensor) []string {
+			var items []string
+			for _, s := range sensors {
+				items = append(items, s.Key)
+			}
+			return items
+		}
+		set("sensors", keys(old.Sensors), keys(cur.Sensors))
+	}
+	if !failed("users") {
+		sessions := func(users []LoggedInUser) []string {
+			var items []string
+			for _, u := range users {
+				item := u.User + " on " + u.Terminal
+				if u.Host != "" {
+					item += " from " + u.Host
+				}
+				items = append(items, item)
+			}
+			return items
+		}
+		set("users", sessions(old.Users), sessions(cur.Users))
+	}
+	if !failed("kernel_modules") {
+		names := func(modules []KernelModule) []string {
+			var items []string
+			for _, m := range modules {
+				items = append(items, m.Name)
+			}
+			return items
+		}
+		set("kernel_modules", names(old.KernelModules), names(cur.KernelModules))
+	}
+	return changes
+}
+
+func handleInventory(w http.ResponseWriter, r *http.Request) {
+	inv, err := inventory.Collect(r.Context())
+	if err != nil {
+		log.Printf("Error getting inventory: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(inv); err != nil {
+		log.Printf("Error encoding response: %v", err)
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadKernelModules(t *testing.T) {
+	modules, err := readKernelModules("testdata/proc/modules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []KernelModule{
+		{Name: "kvm_intel", Size: 380928, State: "Loading"},
+		{Name: "nf_nat", Size: 49152, Instances: 2, UsedBy: []string{"nft_chain_nat", "xt_MASQUERADE"}, State: "Live"},
+		{Name: "overlay", Size: 151552, State: "Live"},
+		{Name: "xt_MASQUERADE", Size: 16384, Instances: 1, State: "Live"},
+	}
+	if !reflect.DeepEqual(modules, want) {
+		t.Errorf("got %+v\nwant %+v", modules, want)
+	}
+}
+
+func TestReadNUMANodes(t *testing.T) {
+	nodes := readNUMANodes("testdata/sys/node")
+	want := []NUMANode{
+		{Node: 0, Total: 16318480 * 1024, CPUs: "0-3"},
+		{Node: 1, Total: 8159240 * 1024, CPUs: "4-7"},
+	}
+	if !reflect.DeepEqual(nodes, want) {
+		t.Errorf("got %+v\nwant %+v", nodes, want)
+	}
+}
+
+// memoryDevice builds an SMBIOS type 17 structure with the given size word
+// and the strings locator, bank, manufacturer and part number.
+func memoryDevice(size uint16, extended uint32) []byte {
+	raw := make([]byte, 0x28)
+	raw[0], raw[1] = 17, byte(len(raw))
+	binary.LittleEndian.PutUint16(raw[0x0C:], size)
+	raw[0x10], raw[0x11], raw[0x12] = 1, 2, 0x1A
+	binary.LittleEndian.PutUint16(raw[0x15:], 3200)
+	raw[0x17], raw[0x1A] = 3, 4
+	binary.LittleEndian.PutUint32(raw[0x1C:], extended)
+	return append(raw, "DIMM A1\x00BANK 0\x00Samsung  \x00M393A2K43DB3-CWE \x00\x00"...)
+}
+
+func TestParseMemoryDevice(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  []byte
+		size uint64
+	}{
+		{"megabytes", memoryDevice(16384, 0), 16 << 30},
+		{"kilobytes", memoryDevice(0x8000|512, 0), 512 << 10},
+		{"extended", memoryDevice(0x7FFF, 65536), 64 << 30},
+		{"empty slot", memoryDevice(0, 0), 0},
+	}
+	for _, tt := range tests {
+		m, ok := parseMemoryDevice(tt.raw)
+		if !ok {
+			t.Fatalf("%s: not parsed", tt.name)
+		}
+		if m.Size != tt.size {
+			t.Errorf("%s: size %d, want %d", tt.name, m.Size, tt.size)
+		}
+	}
+
+	m, _ := parseMemoryDevice(memoryDevice(16384, 0))
+	want := MemoryModule{Locator: "DIMM A1", Bank: "BANK 0", Type: "DDR4", Size: 16 << 30, SpeedMTs: 3200,
+		Manufacturer: "Samsung", PartNumber: "M393A2K43DB3-CWE"}
+	if m != want {
+		t.Errorf("got %+v\nwant %+v", m, want)
+	}
+
+	if _, ok := parseMemoryDevice([]byte{16, 4, 0, 0}); ok {
+		t.Error("parsed a structure of the wrong type")
+	}
+}
+
+func TestReadUtmp(t *testing.T) {
+	record := func(kind uint16, line, user, host string, started int32) []byte {
+		rec := make([]byte, utmpRecordSize)
+		binary.LittleEndian.PutUint16(rec[0:], kind)
+		copy(rec[8:40], line)
+		copy(rec[44:76], user)
+		copy(rec[76:332], host)
+		binary.LittleEndian.PutUint32(rec[340:], uint32(started))
+		return rec
+	}
+	var data []byte
+	data = append(data, record(2, "~", "reboot", "", 1700000000)...)
+	data = append(data, record(utmpUserProcess, "pts/0", "alice", "10.0.0.5", 1700000100)...)
+	data = append(data, record(8, "pts/1", "", "", 1700000200)...)
+	data = append(data, record(utmpUserProcess, "tty1", "root", "", 1700000300)...)
+	path := filepath.Join(t.TempDir(), "utmp")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := readUtmp(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []LoggedInUser{
+		{User: "alice", Terminal: "pts/0", Host: "10.0.0.5", Started: time.Unix(1700000100, 0).UTC().Format(time.RFC3339)},
+		{User: "root", Terminal: "tty1", Started: time.Unix(1700000300, 0).UTC().Format(time.RFC3339)},
+	}
+	if !reflect.DeepEqual(users, want) {
+		t.Errorf("got %+v\nwant %+v", users, want)
+	}
+}
+
+func TestDiffInventory(t *testing.T) {
+	old := &Inventory{
+		Host:          HostInventory{KernelVersion: "6.1.0-17", BootTime: "2024-01-01T00:00:00Z"},
+		CPU:           CPUInventory{LogicalCores: 4, Flags: []string{"avx", "sse"}},
+		Sensors:       []Sensor{{Key: "coretemp_core0", Temperature: 40}},
+		Users:         []LoggedInUser{{User: "alice", Terminal: "pts/0"}},
+		KernelModules: []KernelModule{{Name: "overlay"}, {Name: "nf_nat"}},
+	}
+	cur := &Inventory{
+		Host:          HostInventory{KernelVersion: "6.1.0-18", BootTime: "2024-01-02T00:00:00Z"},
+		CPU:           CPUInventory{LogicalCores: 4, Flags: []string{"avx", "sse"}},
+		Sensors:       []Sensor{{Key: "coretemp_core0", Temperature: 55}},
+		Users:         []LoggedInUser{{User: "bob", Terminal: "pts/1", Host: "10.0.0.7"}},
+		KernelModules: []KernelModule{{Name: "overlay"}, {Name: "wireguard"}},
+		// A failing section must not report everything as removed.
+		Errors: map[string]string{"cpu": "unavailable"},
+	}
+	cur.CPU = CPUInventory{}
+
+	now := "2024-01-02T00:01:00Z"
+	want := []InventoryChange{
+		{Time: now, Section: "host", Item: "kernel_version", Change: InventoryChanged, Old: "6.1.0-17", New: "6.1.0-18"},
+		{Time: now, Section: "host", Item: "boot_time", Change: InventoryChanged, Old: "2024-01-01T00:00:00Z", New: "2024-01-02T00:00:00Z"},
+		{Time: now, Section: "users", Item: "bob on pts/1 from 10.0.0.7", Change: InventoryAdded},
+		{Time: now, Section: "users", Item: "alice on pts/0", Change: InventoryRemoved},
+		{Time: now, Section: "kernel_modules", Item: "wireguard", Change: InventoryAdded},
+		{Time: now, Section: "kernel_modules", Item: "nf_nat", Change: InventoryRemoved},
+	}
+	if got := diffInventory(old, cur, now); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+	if got := diffInventory(old, old, now); len(got) != 0 {
+		t.Errorf("identical inventories reported changes: %+v", got)
+	}
+}
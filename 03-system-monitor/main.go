@@ -7,25 +7,28 @@ import (
 	"net/http"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/disk"
-	"github.com/shirou/gopsutil/v3/host"
-	"github.com/shirou/gopsutil/v3/load"
-	"github.com/shirou/gopsutil/v3/mem"
 	"github.com/shirou/gopsutil/v3/process"
 )
 
 type SystemStats struct {
-	Timestamp     string         `json:"timestamp"`
-	HostInfo      HostInfo       `json:"host_info"`
-	CPU           CPUStats       `json:"cpu"`
-	Memory        MemoryStats    `json:"memory"`
-	Disk          []DiskStats    `json:"disk"`
-	ProcessCount  int            `json:"process_count"`
-	TopProcesses  []ProcessStats `json:"top_processes"`
+	Timestamp    string                     `json:"timestamp"`
+	HostInfo     HostInfo                   `json:"host_info"`
+	CPU          CPUStats                   `json:"cpu"`
+	Memory       MemoryStats                `json:"memory"`
+	Disk         []DiskStats                `json:"disk"`
+	DiskIO       []DiskIOStats              `json:"disk_io,omitempty"`
+	Network      *NetworkStats              `json:"network,omitempty"`
+	Containers   []ContainerStats           `json:"containers,omitempty"`
+	Pressure     *PressureStats             `json:"pressure,omitempty"`
+	OOM          *OOMStats                  `json:"oom,omitempty"`
+	Collectors   map[string]CollectorStatus `json:"collectors"`
+	ProcessCount int                        `json:"process_count"`
+	TopProcesses []ProcessStats             `json:"top_processes"`
 }
 
 type HostInfo struct {
@@ -34,7 +37,12 @@ type HostInfo struct {
 	Platform        string `json:"platform"`
 	PlatformVersion string `json:"platform_version"`
 	KernelVersion   string `json:"kernel_version"`
+	KernelArch      string `json:"kernel_arch,omitempty"`
 	Uptime         uint64 `json:"uptime"`
+	BootTime        string `json:"boot_time,omitempty"`
+	// Virtualization as detected by gopsutil, e.g. "kvm" and "guest".
+	VirtualizationSystem string `json:"virtualization_system,omitempty"`
+	VirtualizationRole   string `json:"virtualization_role,omitempty"`
 }
 
 type CPUStats struct {
@@ -66,6 +74,10 @@ type DiskStats struct {
 	InodesTotal uint64  `json:"inodes_total"`
 	InodesUsed  uint64  `json:"inodes_used"`
 	InodesFree  uint64  `json:"inodes_free"`
+
+	GrowthRate       float64  `json:"growth_bytes_per_sec"`
+	SecondsUntilFull *float64 `json:"seconds_until_full,omitempty"`
+	FullAt           string   `json:"full_at,omitempty"`
 }
 
 type ProcessStats struct {
@@ -78,23 +90,32 @@ type ProcessStats struct {
 	MemoryUsage uint64  `json:"memory_usage"`
 	Status      string  `json:"status"`
 	CreateTime  int64   `json:"create_time"`
+	ContainerID string  `json:"container_id,omitempty"`
 }
 
+var (
+	statsSampler *sampler
+	alertEngine  *alertManager
+)
+
 func init() {
-	// Set host paths for containerized environment
-	if hostProc := os.Getenv("HOST_PROC"); hostProc != "" {
-		if err := os.Setenv("HOST_PROC", hostProc); err != nil {
-			log.Printf("Warning: Failed to set HOST_PROC: %v", err)
-		}
+	// Resolve host paths for containerized environments
+	config, err := loadHostConfig()
+	if err != nil {
+		log.Fatalf("Invalid host configuration: %v", err)
 	}
-	if hostSys := os.Getenv("HOST_SYS"); hostSys != "" {
-		if err := os.Setenv("HOST_SYS", hostSys); err != nil {
-			log.Printf("Warning: Failed to set HOST_SYS: %v", err)
-		}
+	if err := config.apply(); err != nil {
+		log.Fatalf("Failed to apply host configuration: %v", err)
 	}
+	hostConfig = config
 }
 
 func main() {
+	// Any arguments select a CLI command instead of the server
+	if len(os.Args) > 1 {
+		os.Exit(runCLI(os.Args[1:]))
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
@@ -103,10 +124,121 @@ func main() {
 	log.Printf("Starting System Monitor on port %s", port)
 	log.Printf("Running with CPU cores: %d", runtime.NumCPU())
 
+	// Configure the collectors and what they report
+	if err := loadCollectionConfig(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	logHostReport()
+	log.Printf("Enabled collectors: %s", strings.Join(collectors.Names(), ", "))
+
+	// Start the background sampler feeding the history buffer
+	interval, err := envDuration("SAMPLE_INTERVAL", time.Second)
+	if err != nil {
+		log.Fatalf("Invalid SAMPLE_INTERVAL: %v", err)
+	}
+	tierSpec := os.Getenv("HISTORY_TIERS")
+	if tierSpec == "" {
+		tierSpec = "1s:10m,1m:24h"
+	}
+	tiers, err := parseHistoryTiers(tierSpec)
+	if err != nil {
+		log.Fatalf("Invalid HISTORY_TIERS: %v", err)
+	}
+	statsSampler = newSampler(interval, newHistory(tiers))
+
+	// Load alert rules, if any, and evaluate them on every sample
+	ruleFile := &AlertRuleFile{}
+	if path := os.Getenv("ALERT_RULES_FILE"); path != "" {
+		if ruleFile, err = loadAlertRules(path); err != nil {
+			log.Fatalf("Invalid ALERT_RULES_FILE: %v", err)
+		}
+		log.Printf("Loaded %d alert rules from %s", len(ruleFile.Rules), path)
+	}
+	for _, hook := range strings.Split(os.Getenv("ALERT_WEBHOOKS"), ",") {
+		if hook = strings.TrimSpace(hook); hook == "" {
+			continue
+		}
+		if err := validateWebhook(hook); err != nil {
+			log.Fatalf("Invalid ALERT_WEBHOOKS: %v", err)
+		}
+		ruleFile.Webhooks = append(ruleFile.Webhooks, hook)
+	}
+	alertEngine = newAlertManager(ruleFile.Rules, ruleFile.Webhooks)
+	statsSampler.OnSample(alertEngine.Evaluate)
+
+	// Watch named processes, if configured
+	if watches, err = loadWatcher(); err != nil {
+		log.Fatalf("Invalid WATCH_RULES_FILE: %v", err)
+	}
+	if watches != nil {
+		http.HandleFunc("/watches", handleWatches)
+		go watches.run()
+		log.Printf("Watching %d process rules every %s", len(watches.rules), watches.interval)
+	}
+
+	// Follow the kernel log for OOM kills
+	kmsgPath := os.Getenv("KMSG_PATH")
+	if kmsgPath == "" {
+		kmsgPath = "/dev/kmsg"
+	}
+	go oomEvents.watchKernelLog(kmsgPath)
+
+	// Push samples to a remote endpoint, if configured
+	pushConfig, err := loadPushConfig()
+	if err != nil {
+		log.Fatalf("Invalid push configuration: %v", err)
+	}
+	if pushConfig != nil {
+		p, err := newPusher(pushConfig)
+		if err != nil {
+			log.Fatalf("Could not start push mode: %v", err)
+		}
+		statsSampler.OnSample(p.Add)
+		go p.run()
+		log.Printf("Pushing %s batches to %s every %s (buffer: %s)", pushConfig.Format.name, pushConfig.URL, pushConfig.Interval, pushConfig.BufferDir)
+	}
+
+	// Enable the process control API, if configured
+	if control, err = loadControlConfig(); err != nil {
+		log.Fatalf("Invalid control API configuration: %v", err)
+	}
+	if control != nil {
+		log.Printf("Process control API enabled (audit log: %s)", control.AuditLog)
+	}
+
+	// Aggregate peer monitors into a fleet view, if configured
+	if fleet, err = loadAggregator(); err != nil {
+		log.Fatalf("Invalid aggregator configuration: %v", err)
+	}
+	if fleet != nil {
+		http.HandleFunc("/fleet", handleFleet)
+		http.HandleFunc("/fleet/hosts/", handleFleetHost)
+		if fleet.acceptPush {
+			http.HandleFunc("/fleet/push", handleFleetPush)
+		}
+		go fleet.run()
+		log.Printf("Aggregating %d peers every %s", len(fleet.peers), fleet.interval)
+	}
+
+	// Collect the inventory in the background to detect changes
+	if inventory, err = loadInventoryTracker(); err != nil {
+		log.Fatalf("Invalid INVENTORY_INTERVAL: %v", err)
+	}
+	go inventory.run()
+
+	go statsSampler.run()
+	log.Printf("Sampling every %s with history tiers %s", interval, tierSpec)
+
 	// Create routes
 	http.HandleFunc("/", handleHome)
 	http.HandleFunc("/metrics", handleMetrics)
+	http.HandleFunc("/metrics/history", handleMetricsHistory)
+	http.HandleFunc("/network", handleNetwork)
+	http.HandleFunc("/containers", handleContainers)
 	http.HandleFunc("/processes", handleProcesses)
+	http.HandleFunc("/processes/", handleProcess)
+	http.HandleFunc("/alerts", handleAlerts)
+	http.HandleFunc("/inventory", handleInventory)
 	http.HandleFunc("/health", handleHealth)
 
 	log.Printf("Server is ready to handle requests at :%s", port)
@@ -115,15 +247,60 @@ func main() {
 	}
 }
 
+// loadCollectionConfig configures the collectors and what they report: the
+// disk filter and timeouts, disk forecasts, socket owner scans and the
+// enabled collectors. The server and the CLI share it.
+func loadCollectionConfig() error {
+	var err error
+	if diskFilter, err = loadDiskFilter(); err != nil {
+		return fmt.Errorf("invalid disk filter: %v", err)
+	}
+	if diskUsageTimeout, err = envDuration("DISK_USAGE_TIMEOUT", diskUsageTimeout); err != nil {
+		return fmt.Errorf("invalid DISK_USAGE_TIMEOUT: %v", err)
+	}
+	forecastWindow, err := envDuration("DISK_FORECAST_WINDOW", diskForecasts.window)
+	if err != nil {
+		return fmt.Errorf("invalid DISK_FORECAST_WINDOW: %v", err)
+	}
+	diskForecasts = newDiskForecaster(forecastWindow)
+	if socketOwnerInterval, err = envDuration("NETWORK_OWNER_SCAN_INTERVAL", socketOwnerInterval); err != nil {
+		return fmt.Errorf("invalid NETWORK_OWNER_SCAN_INTERVAL: %v", err)
+	}
+	if collectors, err = loadCollectors(); err != nil {
+		return fmt.Errorf("invalid collector configuration: %v", err)
+	}
+	return nil
+}
+
 func handleHome(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
 		http.NotFound(w, r)
 		return
 	}
-	fmt.Fprintf(w, "System Monitor is running. Available endpoints:\n"+
+	if wantsHTML(r) {
+		serveDashboard(w, r)
+		return
+	}
+	endpoints := "System Monitor is running. Available endpoints:\n" +
 		"- /metrics - System metrics\n"+
+		"- /metrics/history - Metric history (metric, from, to, step)\n"+
+		"- /network - Network interfaces and connections\n"+
+		"- /containers - Container cgroup usage and limits (all=true for every cgroup)\n"+
 		"- /processes - Process information\n"+
-		"- /health - Health check")
+		"- /processes/{pid} - Process details\n"+
+		"- /processes/tree - Process hierarchy (pid, depth, format=text)\n"+
+		"- /alerts - Active alerts\n"+
+		"- /inventory - Hardware and software inventory with recent changes\n"
+	if control != nil {
+		endpoints += "- POST /processes/{pid}/signal, /renice, /kill-tree - Process control\n"
+	}
+	if watches != nil {
+		endpoints += "- /watches - Watched processes and their events\n"
+	}
+	if fleet != nil {
+		endpoints += "- /fleet - Fleet view of peer monitors\n"
+	}
+	fmt.Fprint(w, endpoints+"- /health - Health check")
 }
 
 func handleMetrics(w http.ResponseWriter, r *http.Request) {
@@ -142,149 +319,282 @@ func handleMetrics(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func handleProcesses(w http.ResponseWriter, r *http.Request) {
-	limit := 10 // Default limit
-	processes, err := getTopProcesses(limit)
+func handleMetricsHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	metrics := query["metric"]
+	w.Header().Set("Content-Type", "application/json")
+	if len(metrics) == 0 {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"metrics": statsSampler.history.Metrics(),
+		})
+		return
+	}
+
+	now := time.Now()
+	from, to := now.Add(-10*time.Minute), now
+	var err error
+	if v := query.Get("from"); v != "" {
+		if from, err = parseHistoryTime(v, now); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if to, err = parseHistoryTime(v, now); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	var step time.Duration
+	if v := query.Get("step"); v != "" {
+		if step, err = time.ParseDuration(v); err != nil {
+			http.Error(w, fmt.Sprintf("invalid step %q: %v", v, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var series []*HistorySeries
+	for _, metric := range metrics {
+		s, err := statsSampler.history.Query(metric, from, to, step)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		series = append(series, s)
+	}
+
+	if err := json.NewEncoder(w).Encode(series); err != nil {
+		log.Printf("Error encoding response: %v", err)
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+}
+
+func handleNetwork(w http.ResponseWriter, r *http.Request) {
+	network, err := getNetworkStats()
 	if err != nil {
-		log.Printf("Error getting processes: %v", err)
+		log.Printf("Error getting network stats: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(processes); err != nil {
+	if err := json.NewEncoder(w).Encode(network); err != nil {
 		log.Printf("Error encoding response: %v", err)
 		http.Error(w, "Error encoding response", http.StatusInternalServerError)
 		return
 	}
 }
 
-func handleHealth(w http.ResponseWriter, r *http.Request) {
-	health := map[string]interface{}{
-		"status":    "UP",
-		"timestamp": time.Now().UTC().Format(time.RFC3339),
-		"version":   "1.0.0",
+func handleContainers(w http.ResponseWriter, r *http.Request) {
+	containers, err := getContainerStats(r.URL.Query().Get("all") == "true")
+	if err != nil {
+		log.Printf("Error getting container stats: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if containers == nil {
+		containers = []ContainerStats{}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(health)
+	if err := json.NewEncoder(w).Encode(containers); err != nil {
+		log.Printf("Error encoding response: %v", err)
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
 }
 
-func getSystemStats() (*SystemStats, error) {
-	stats := &SystemStats{
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
+func handleProcesses(w http.ResponseWriter, r *http.Request) {
+	q, err := parseProcessQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	// Host Info
-	hostInfo, err := host.Info()
+	processes, total, err := queryProcesses(q)
 	if err != nil {
-		log.Printf("Warning: Could not get host info: %v", err)
-	} else {
-		stats.HostInfo = HostInfo{
-			Hostname:        hostInfo.Hostname,
-			OS:             hostInfo.OS,
-			Platform:       hostInfo.Platform,
-			PlatformVersion: hostInfo.PlatformVersion,
-			KernelVersion:  hostInfo.KernelVersion,
-			Uptime:        hostInfo.Uptime,
-		}
+		log.Printf("Error getting processes: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	// CPU Stats
-	cpuPercent, err := cpu.Percent(0, false)
-	if err != nil {
-		return nil, fmt.Errorf("error getting CPU stats: %v", err)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if err := json.NewEncoder(w).Encode(processes); err != nil {
+		log.Printf("Error encoding response: %v", err)
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
 	}
+}
 
-	perCPU, err := cpu.Percent(0, true)
-	if err != nil {
-		log.Printf("Warning: Could not get per-CPU stats: %v", err)
+// handleProcess serves the endpoints below /processes/.
+func handleProcess(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/processes/")
+	if pid, action, ok := strings.Cut(rest, "/"); ok {
+		handleProcessControl(w, r, pid, action)
+		return
+	}
+	switch rest {
+	case "tree":
+		handleProcessTree(w, r)
+	default:
+		handleProcessDetail(w, r)
+	}
+}
+
+func handleProcessDetail(w http.ResponseWriter, r *http.Request) {
+	pid, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/processes/"), 10, 32)
+	if err != nil || pid <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	envMode := r.URL.Query().Get("env")
+	switch envMode {
+	case "":
+		envMode = EnvNone
+	case EnvNone:
+	case EnvRedacted:
+		if os.Getenv("ALLOW_ENV") != "true" && os.Getenv("ALLOW_FULL_ENV") != "true" {
+			http.Error(w, "process environments are disabled, set ALLOW_ENV=true to enable", http.StatusForbidden)
+			return
+		}
+	case EnvFull:
+		if os.Getenv("ALLOW_FULL_ENV") != "true" {
+			http.Error(w, "unredacted environment is disabled, set ALLOW_FULL_ENV=true to enable", http.StatusForbidden)
+			return
+		}
+	default:
+		http.Error(w, "env must be none, redacted or full", http.StatusBadRequest)
+		return
 	}
 
-	loadAvg, err := load.Avg()
+	detail, err := getProcessDetail(int32(pid), envMode)
+	if err == errProcessNotFound {
+		http.Error(w, fmt.Sprintf("process %d not found", pid), http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		log.Printf("Warning: Could not get load average: %v", err)
+		log.Printf("Error getting process %d: %v", pid, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	stats.CPU = CPUStats{
-		Usage:     cpuPercent[0],
-		CoreCount: runtime.NumCPU(),
-		PerCPU:    perCPU,
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(detail); err != nil {
+		log.Printf("Error encoding response: %v", err)
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
 	}
+}
 
-	if loadAvg != nil {
-		stats.CPU.LoadAverage = []float64{loadAvg.Load1, loadAvg.Load5, loadAvg.Load15}
+func handleProcessTree(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var rootPID int32
+	if v := query.Get("pid"); v != "" {
+		pid, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || pid <= 0 {
+			http.Error(w, fmt.Sprintf("invalid pid %q", v), http.StatusBadRequest)
+			return
+		}
+		rootPID = int32(pid)
+	}
+	depth := 0
+	if v := query.Get("depth"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("invalid depth %q", v), http.StatusBadRequest)
+			return
+		}
+		depth = n
 	}
 
-	// Memory Stats
-	virtualMem, err := mem.VirtualMemory()
+	processes, err := listProcesses()
 	if err != nil {
-		return nil, fmt.Errorf("error getting memory stats: %v", err)
+		log.Printf("Error getting processes: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-
-	swapMem, err := mem.SwapMemory()
+	roots, err := buildProcessTree(processes, rootPID, depth)
 	if err != nil {
-		log.Printf("Warning: Could not get swap memory stats: %v", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
 	}
 
-	stats.Memory = MemoryStats{
-		Total:     virtualMem.Total,
-		Used:      virtualMem.Used,
-		Free:      virtualMem.Free,
-		UsagePerc: virtualMem.UsedPercent,
+	switch query.Get("format") {
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writeProcessTree(w, roots)
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(roots); err != nil {
+			log.Printf("Error encoding response: %v", err)
+			http.Error(w, "Error encoding response", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "format must be json or text", http.StatusBadRequest)
 	}
+}
 
-	if swapMem != nil {
-		stats.Memory.SwapTotal = swapMem.Total
-		stats.Memory.SwapUsed = swapMem.Used
-		stats.Memory.SwapFree = swapMem.Free
-		stats.Memory.SwapUsagePerc = swapMem.UsedPercent
+func handleAlerts(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "", AlertPending, AlertFiring, AlertResolved:
+	default:
+		http.Error(w, fmt.Sprintf("invalid state %q", state), http.StatusBadRequest)
+		return
 	}
 
-	// Disk Stats
-	partitions, err := disk.Partitions(false)
-	if err != nil {
-		return nil, fmt.Errorf("error getting disk partitions: %v", err)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(alertEngine.Alerts(state)); err != nil {
+		log.Printf("Error encoding response: %v", err)
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
 	}
+}
 
-	for _, partition := range partitions {
-		usage, err := disk.Usage(partition.Mountpoint)
-		if err != nil {
-			log.Printf("Warning: Could not get disk usage for %s: %v", partition.Mountpoint, err)
-			continue
-		}
-		stats.Disk = append(stats.Disk, DiskStats{
-			Device:      partition.Device,
-			MountPoint:  partition.Mountpoint,
-			FSType:      partition.Fstype,
-			Total:       usage.Total,
-			Used:        usage.Used,
-			Free:        usage.Free,
-			UsagePerc:   usage.UsedPercent,
-			InodesTotal: usage.InodesTotal,
-			InodesUsed:  usage.InodesUsed,
-			InodesFree:  usage.InodesFree,
-		})
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	health := map[string]interface{}{
+		"status":    "UP",
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"version":   "1.0.0",
 	}
 
-	// Process Count and Top Processes
-	processes, err := process.Processes()
-	if err != nil {
-		return nil, fmt.Errorf("error getting processes: %v", err)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(health)
+}
+
+func getSystemStats() (*SystemStats, error) {
+	stats := &SystemStats{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
 	}
-	stats.ProcessCount = len(processes)
 
-	topProcesses, err := getTopProcesses(5)
-	if err == nil {
-		stats.TopProcesses = topProcesses
-	} else {
-		log.Printf("Warning: Could not get top processes: %v", err)
+	if err := collectors.Collect(stats); err != nil {
+		return nil, err
 	}
 
 	return stats, nil
 }
 
 func getTopProcesses(limit int) ([]ProcessStats, error) {
+	q := defaultProcessQuery(limit)
+	processes, _, err := queryProcesses(q)
+	return processes, err
+}
+
+// queryProcesses lists running processes and applies q to them.
+func queryProcesses(q ProcessQuery) ([]ProcessStats, int, error) {
+	processes, err := listProcesses()
+	if err != nil {
+		return nil, 0, err
+	}
+	selected, total := q.Apply(processes)
+	return selected, total, nil
+}
+
+func listProcesses() ([]ProcessStats, error) {
 	processes, err := process.Processes()
 	if err != nil {
 		return nil, err
@@ -292,71 +602,105 @@ func getTopProcesses(limit int) ([]ProcessStats, error) {
 
 	var processStats []ProcessStats
 	for _, p := range processes {
-		name, err := p.Name()
+		stats, err := getProcessStats(p)
 		if err != nil {
 			continue
 		}
+		processStats = append(processStats, stats)
+	}
 
-		cpu, err := p.CPUPercent()
-		if err != nil {
-			continue
-		}
+	return processStats, nil
+}
 
-		mem, err := p.MemoryInfo()
-		if err != nil {
-			continue
-		}
+// processFieldWarnings records which optional process fields have already
+// failed to read. getProcessStats runs for every process on every sample, so
+// only the first failure of each field is logged.
+var processFieldWarnings sync.Map
 
-		memPercent, err := p.MemoryPercent()
-		if err != nil {
-			log.Printf("Warning: Could not get memory percent for process %s: %v", name, err)
-		}
+func warnProcessField(field, name string, err error) {
+	if _, logged := processFieldWarnings.LoadOrStore(field, true); !logged {
+		log.Printf("Warning: Could not get %s for process %s: %v (further failures are not logged)", field, name, err)
+	}
+}
 
-		username, err := p.Username()
-		if err != nil {
-			log.Printf("Warning: Could not get username for process %s: %v", name, err)
-		}
+// getProcessStats reads the summary fields for one process. It fails only if
+// the name, CPU or memory usage cannot be read.
+func getProcessStats(p *process.Process) (ProcessStats, error) {
+	name, err := p.Name()
+	if err != nil {
+		return ProcessStats{}, err
+	}
 
-		status, err := p.Status()
-		if err != nil {
-			log.Printf("Warning: Could not get status for process %s: %v", name, err)
-		}
+	cpu, err := p.CPUPercent()
+	if err != nil {
+		return ProcessStats{}, err
+	}
 
-		createTime, err := p.CreateTime()
-		if err != nil {
-			log.Printf("Warning: Could not get create time for process %s: %v", name, err)
-		}
+	mem, err := p.MemoryInfo()
+	if err != nil {
+		return ProcessStats{}, err
+	}
 
-		ppid, err := p.Ppid()
-		if err != nil {
-			log.Printf("Warning: Could not get parent PID for process %s: %v", name, err)
-		}
+	memPercent, err := p.MemoryPercent()
+	if err != nil {
+		warnProcessField("memory percent", name, err)
+	}
 
-		processStats = append(processStats, ProcessStats{
-			PID:         p.Pid,
-			PPID:        ppid,
-			Name:        name,
-			Username:    username,
-			CPUPercent:  cpu,
-			MemoryPerc:  memPercent,
-			MemoryUsage: mem.RSS,
-			Status:      strings.Join(status, ", "),
-			CreateTime:  createTime,
-		})
+	username, err := p.Username()
+	if err != nil {
+		warnProcessField("username", name, err)
+	}
+
+	status, err := p.Status()
+	if err != nil {
+		warnProcessField("status", name, err)
+	}
+
+	createTime, err := p.CreateTime()
+	if err != nil {
+		warnProcessField("create time", name, err)
+	}
+
+	ppid, err := p.Ppid()
+	if err != nil {
+		warnProcessField("parent PID", name, err)
 	}
 
-	// Sort by CPU usage (simple bubble sort for demonstration)
-	for i := 0; i < len(processStats)-1; i++ {
-		for j := 0; j < len(processStats)-i-1; j++ {
-			if processStats[j].CPUPercent < processStats[j+1].CPUPercent {
-				processStats[j], processStats[j+1] = processStats[j+1], processStats[j]
+	var containerID string
+	if cgroups, err := readProcessCgroups(p.Pid); err == nil {
+		for _, cg := range cgroups {
+			if containerID = containerIDFromCgroup(cg.Path); containerID != "" {
+				break
 			}
 		}
 	}
 
-	if len(processStats) > limit {
-		processStats = processStats[:limit]
-	}
+	return ProcessStats{
+		PID:         p.Pid,
+		PPID:        ppid,
+		Name:        name,
+		Username:    username,
+		CPUPercent:  cpu,
+		MemoryPerc:  memPercent,
+		MemoryUsage: mem.RSS,
+		Status:      strings.Join(status, ", "),
+		CreateTime:  createTime,
+		ContainerID: containerID,
+	}, nil
+}
 
-	return processStats, nil
+// envDuration reads a duration such as "5s" from the environment.
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s must be positive", key)
+	}
+	return d, nil
 }
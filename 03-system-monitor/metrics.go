@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// flattenStats turns a SystemStats snapshot into a flat map of numeric
+// metrics keyed by their dotted JSON path, e.g. "cpu.usage" or
+// "disk[/].usage_percentage". These names are what the history API accepts.
+func flattenStats(stats *SystemStats) map[string]float64 {
+	values := map[string]float64{
+		"cpu.usage":                    stats.CPU.Usage,
+		"cpu.core_count":               float64(stats.CPU.CoreCount),
+		"memory.total":                 float64(stats.Memory.Total),
+		"memory.used":                  float64(stats.Memory.Used),
+		"memory.free":                  float64(stats.Memory.Free),
+		"memory.usage_percentage":      stats.Memory.UsagePerc,
+		"memory.swap_total":            float64(stats.Memory.SwapTotal),
+		"memory.swap_used":             float64(stats.Memory.SwapUsed),
+		"memory.swap_free":             float64(stats.Memory.SwapFree),
+		"memory.swap_usage_percentage": stats.Memory.SwapUsagePerc,
+		"process_count":                float64(stats.ProcessCount),
+		"host_info.uptime":             float64(stats.HostInfo.Uptime),
+	}
+
+	for i, load := range stats.CPU.LoadAverage {
+		values[fmt.Sprintf("cpu.load_average[%d]", i)] = load
+	}
+	for i, usage := range stats.CPU.PerCPU {
+		values[fmt.Sprintf("cpu.per_cpu[%d]", i)] = usage
+	}
+
+	for _, d := range stats.Disk {
+		prefix := "disk[" + d.MountPoint + "]."
+		values[prefix+"total"] = float64(d.Total)
+		values[prefix+"used"] = float64(d.Used)
+		values[prefix+"free"] = float64(d.Free)
+		values[prefix+"usage_percentage"] = d.UsagePerc
+		values[prefix+"inodes_total"] = float64(d.InodesTotal)
+		values[prefix+"inodes_used"] = float64(d.InodesUsed)
+		values[prefix+"inodes_free"] = float64(d.InodesFree)
+		values[prefix+"growth_bytes_per_sec"] = d.GrowthRate
+		if d.SecondsUntilFull != nil {
+			values[prefix+"seconds_until_full"] = *d.SecondsUntilFull
+		}
+	}
+
+	for _, d := range stats.DiskIO {
+		prefix := "disk_io[" + d.Device + "]."
+		values[prefix+"read_iops"] = d.ReadIOPS
+		values[prefix+"write_iops"] = d.WriteIOPS
+		values[prefix+"read_bytes_per_sec"] = d.ReadBytesRate
+		values[prefix+"write_bytes_per_sec"] = d.WriteBytesRate
+		values[prefix+"utilization_percentage"] = d.Utilization
+		values[prefix+"avg_latency_ms"] = d.AvgLatency
+		values[prefix+"avg_queue_size"] = d.AvgQueueSize
+		values[prefix+"queue_depth"] = float64(d.QueueDepth)
+	}
+
+	for _, c := range stats.Containers {
+		name := c.ID
+		if len(name) > 12 {
+			name = name[:12]
+		}
+		prefix := "container[" + name + "]."
+		values[prefix+"cpu.usage_percent"] = c.CPU.UsagePercent
+		values[prefix+"cpu.limit_percentage"] = c.CPU.LimitPercentage
+		values[prefix+"cpu.throttled_seconds"] = c.CPU.ThrottledSeconds
+		values[prefix+"memory.usage"] = float64(c.Memory.Usage)
+		values[prefix+"memory.limit_percentage"] = c.Memory.LimitPercentage
+		values[prefix+"io.read_bytes_per_sec"] = c.IO.ReadBytesRate
+		values[prefix+"io.write_bytes_per_sec"] = c.IO.WriteBytesRate
+		values[prefix+"pids.current"] = float64(c.PIDs.Current)
+		values[prefix+"pids.limit_percentage"] = c.PIDs.LimitPercentage
+	}
+
+	if stats.Pressure != nil {
+		for resource, p := range map[string]*Pressure{"cpu": stats.Pressure.CPU, "memory": stats.Pressure.Memory, "io": stats.Pressure.IO} {
+			if p == nil {
+				continue
+			}
+			prefix := "pressure." + resource + "."
+			values[prefix+"some.avg10"] = p.Some.Avg10
+			values[prefix+"some.avg60"] = p.Some.Avg60
+			values[prefix+"some.avg300"] = p.Some.Avg300
+			if p.Full != nil {
+				values[prefix+"full.avg10"] = p.Full.Avg10
+				values[prefix+"full.avg60"] = p.Full.Avg60
+				values[prefix+"full.avg300"] = p.Full.Avg300
+			}
+		}
+	}
+	if stats.OOM != nil {
+		values["oom.kills"] = float64(stats.OOM.Kills)
+	}
+
+	if stats.Network != nil {
+		for _, iface := range stats.Network.Interfaces {
+			prefix := "network[" + iface.Name + "]."
+			values[prefix+"bytes_sent"] = float64(iface.BytesSent)
+			values[prefix+"bytes_recv"] = float64(iface.BytesRecv)
+			values[prefix+"bytes_sent_per_sec"] = iface.BytesSentRate
+			values[prefix+"bytes_recv_per_sec"] = iface.BytesRecvRate
+			values[prefix+"packets_sent_per_sec"] = iface.PacketsSentRate
+			values[prefix+"packets_recv_per_sec"] = iface.PacketsRecvRate
+			values[prefix+"errors_per_sec"] = iface.ErrRate
+			values[prefix+"drops_per_sec"] = iface.DropRate
+		}
+		for state, count := range stats.Network.TCPStates {
+			values["network.tcp_states["+state+"]"] = float64(count)
+		}
+		values["network.listening"] = float64(len(stats.Network.Listening))
+	}
+
+	for name, status := range stats.Collectors {
+		prefix := "collector[" + name + "]."
+		values[prefix+"duration_seconds"] = status.Duration
+		if status.Error == "" {
+			values[prefix+"success"] = 1
+		} else {
+			values[prefix+"success"] = 0
+		}
+	}
+
+	return values
+}
+
+// sortedKeys returns the keys of a metric map in lexical order.
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
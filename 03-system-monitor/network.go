@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/net"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+type NetworkStats struct {
+	Interfaces []InterfaceStats  `json:"interfaces"`
+	TCPStates  map[string]int    `json:"tcp_states"`
+	Listening  []ListeningSocket `json:"listening"`
+}
+
+type InterfaceStats struct {
+	Name            string  `json:"name"`
+	BytesSent       uint64  `json:"bytes_sent"`
+	BytesRecv       uint64  `json:"bytes_recv"`
+	PacketsSent     uint64  `json:"packets_sent"`
+	PacketsRecv     uint64  `json:"packets_recv"`
+	ErrIn           uint64  `json:"err_in"`
+	ErrOut          uint64  `json:"err_out"`
+	DropIn          uint64  `json:"drop_in"`
+	DropOut         uint64  `json:"drop_out"`
+	BytesSentRate   float64 `json:"bytes_sent_per_sec"`
+	BytesRecvRate   float64 `json:"bytes_recv_per_sec"`
+	PacketsSentRate float64 `json:"packets_sent_per_sec"`
+	PacketsRecvRate float64 `json:"packets_recv_per_sec"`
+	ErrRate         float64 `json:"errors_per_sec"`
+	DropRate        float64 `json:"drops_per_sec"`
+}
+
+type ListeningSocket struct {
+	Protocol string `json:"protocol"`
+	Address  string `json:"address"`
+	Port     uint32 `json:"port"`
+	PID      int32  `json:"pid,omitempty"`
+	Process  string `json:"process,omitempty"`
+}
+
+// netRates tracks interface counters between collections.
+var netRates = newCounterRates()
+
+func getNetworkStats() (*NetworkStats, error) {
+	// /proc/net follows the reader's network namespace; when watching a host
+	// read the counters of the host's init process instead.
+	var counters []net.IOCountersStat
+	var err error
+	if hostConfig.Containerized() {
+		counters, err = net.IOCountersByFile(true, procPath("1", "net", "dev"))
+	} else {
+		counters, err = net.IOCounters(true)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting network counters: %v", err)
+	}
+
+	stats := &NetworkStats{TCPStates: make(map[string]int)}
+	now := time.Now()
+	for _, c := range counters {
+		rates := netRates.Rates("net/"+c.Name, now,
+			c.BytesSent, c.BytesRecv, c.PacketsSent, c.PacketsRecv, c.Errin+c.Errout, c.Dropin+c.Dropout)
+		stats.Interfaces = append(stats.Interfaces, InterfaceStats{
+			Name:            c.Name,
+			BytesSent:       c.BytesSent,
+			BytesRecv:       c.BytesRecv,
+			PacketsSent:     c.PacketsSent,
+			PacketsRecv:     c.PacketsRecv,
+			ErrIn:           c.Errin,
+			ErrOut:          c.Errout,
+			DropIn:          c.Dropin,
+			DropOut:         c.Dropout,
+			BytesSentRate:   rates[0],
+			BytesRecvRate:   rates[1],
+			PacketsSentRate: rates[2],
+			PacketsRecvRate: rates[3],
+			ErrRate:         rates[4],
+			DropRate:        rates[5],
+		})
+	}
+	netRates.Prune(now, nil)
+
+	sockets, err := readSocketTables()
+	if err != nil {
+		log.Printf("Warning: Could not get network connections: %v", err)
+		return stats, nil
+	}
+
+	var owners map[uint64]int32
+	names := make(map[int32]string)
+	for _, sock := range sockets {
+		if sock.tcp {
+			stats.TCPStates[sock.state]++
+		}
+		// TCP sockets in LISTEN, and UDP sockets without a peer, accept traffic.
+		if sock.state != "LISTEN" && !(!sock.tcp && sock.remotePort == 0) {
+			continue
+		}
+		if owners == nil {
+			owners = socketOwnerCache.get()
+		}
+		socket := ListeningSocket{Protocol: sock.protocol, Address: sock.localAddr, Port: sock.localPort, PID: owners[sock.inode]}
+		if socket.PID > 0 {
+			name, ok := names[socket.PID]
+			if !ok {
+				if p, err := process.NewProcess(socket.PID); err == nil {
+					name, _ = p.Name()
+				}
+				names[socket.PID] = name
+			}
+			socket.Process = name
+		}
+		stats.Listening = append(stats.Listening, socket)
+	}
+	sort.Slice(stats.Listening, func(i, j int) bool {
+		a, b := stats.Listening[i], stats.Listening[j]
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		return a.Address < b.Address
+	})
+
+	return stats, nil
+}
+
+// tcpStates names the connection states used in /proc/net/tcp.
+var tcpStates = map[string]string{
+	"01": "ESTABLISHED", "02": "SYN_SENT", "03": "SYN_RECV", "04": "FIN_WAIT1",
+	"05": "FIN_WAIT2", "06": "TIME_WAIT", "07": "CLOSE", "08": "CLOSE_WAIT",
+	"09": "LAST_ACK", "0A": "LISTEN", "0B": "CLOSING",
+}
+
+type socketEntry struct {
+	protocol   string
+	tcp        bool
+	localAddr  string
+	localPort  uint32
+	remotePort uint32
+	state      string
+	inode      uint64
+}
+
+// readSocketTables reads the TCP and UDP socket tables. Like interface
+// counters, they follow the reader's network namespace, so when watching a
+// host they are read through the host's init process.
+func readSocketTables() ([]socketEntry, error) {
+	var sockets []socketEntry
+	for _, proto := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		path := procPath("net", proto)
+		if hostConfig.Containerized() {
+			path = procPath("1", "net", proto)
+		}
+		entries, err := readSocketTable(path, proto)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// No IPv6 support.
+				continue
+			}
+			return nil, err
+		}
+		sockets = append(sockets, entries...)
+	}
+	return sockets, nil
+}
+
+// readSocketTable parses one /proc/net/{tcp,tcp6,udp,udp6} file, whose
+// lines look like
+// "0: 0100007F:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000 1000 0 12345 ...".
+func readSocketTable(path, proto string) ([]socketEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var entries []socketEntry
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 10 {
+			continue
+		}
+		localAddr, localPort, err1 := parseSocketAddr(fields[1])
+		_, remotePort, err2 := parseSocketAddr(fields[2])
+		inode, err3 := strconv.ParseUint(fields[9], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		entry := socketEntry{
+			protocol:   proto,
+			tcp:        strings.HasPrefix(proto, "tcp"),
+			localAddr:  localAddr,
+			localPort:  localPort,
+			remotePort: remotePort,
+			inode:      inode,
+		}
+		if entry.tcp {
+			entry.state = tcpStates[fields[3]]
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// parseSocketAddr decodes "0100007F:1F90". Addresses are stored as 32-bit
+// words in host (little-endian) byte order, one word for IPv4 and four for
+// IPv6.
+func parseSocketAddr(s string) (string, uint32, error) {
+	host, port, ok := strings.Cut(s, ":")
+	if !ok {
+		return "", 0, fmt.Errorf("invalid socket address %q", s)
+	}
+	p, err := strconv.ParseUint(port, 16, 16)
+	if err != nil {
+		return "", 0, err
+	}
+	raw, err := hex.DecodeString(host)
+	if err != nil || (len(raw) != 4 && len(raw) != 16) {
+		return "", 0, fmt.Errorf("invalid socket address %q", s)
+	}
+	for i := 0; i < len(raw); i += 4 {
+		raw[i], raw[i+1], raw[i+2], raw[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+	}
+	addr, _ := netip.AddrFromSlice(raw)
+	return addr.Unmap().String(), uint32(p), nil
+}
+
+// socketOwnerInterval is how often the socket owner scan runs. Walking
+// every process's file descriptors is far more expensive than the rest of
+// the network collection, so it is not repeated on every sample.
+var socketOwnerInterval = time.Minute
+
+var socketOwnerCache ownerCache
+
+// ownerCache holds the result of the last socket owner scan.
+type ownerCache struct {
+	mu      sync.Mutex
+	owners  map[uint64]int32
+	scanned time.Time
+}
+
+// get returns the cached owners, rescanning once they are older than
+// socketOwnerInterval. Sockets opened since the last scan have no owner
+// until the next one.
+func (c *ownerCache) get() map[uint64]int32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.owners == nil || time.Since(c.scanned) >= socketOwnerInterval {
+		c.owners = socketOwners()
+		c.scanned = time.Now()
+	}
+	return c.owners
+}
+
+// socketOwners maps socket inodes to the PID holding them by walking every
+// process's open file descriptors.
+func socketOwners() map[uint64]int32 {
+	owners := make(map[uint64]int32)
+	dirs, err := filepath.Glob(procPath("[0-9]*", "fd"))
+	if err != nil {
+		return owners
+	}
+	for _, dir := range dirs {
+		pid, err := strconv.ParseInt(filepath.Base(filepath.Dir(dir)), 10, 32)
+		if err != nil {
+			continue
+		}
+		fds, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(dir, fd.Name()))
+			if err != nil || !strings.HasPrefix(target, "socket:[") {
+				continue
+			}
+			inode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(target, "socket:["), "]"), 10, 64)
+			if err == nil {
+				owners[inode] = int32(pid)
+			}
+		}
+	}
+	return owners
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// HostConfig describes where the host's filesystems are visible to the
+// monitor. Outside a container every path is the usual one; inside a
+// container HOST_ROOT is typically the host's / mounted read-only and
+// HOST_PROC/HOST_SYS/HOST_ETC/HOST_RUN default to the matching directories
+// below it.
+type HostConfig struct {
+	Root string `json:"root"`
+	Proc string `json:"proc"`
+	Sys  string `json:"sys"`
+	Etc  string `json:"etc"`
+	Run  string `json:"run"`
+}
+
+// hostConfig is applied once at startup and used by every collector.
+var hostConfig = HostConfig{Root: "/", Proc: "/proc", Sys: "/sys", Etc: "/etc", Run: "/run"}
+
+// pseudoFSTypes are never reported as disks.
+var pseudoFSTypes = map[string]bool{
+	"overlay": true, "aufs": true, "squashfs": true, "nsfs": true,
+	"proc": true, "sysfs": true, "cgroup": true, "cgroup2": true,
+	"devtmpfs": true, "devpts": true, "mqueue": true, "tracefs": true,
+	"debugfs": true, "securityfs": true, "fusectl": true, "bpf": true,
+}
+
+// containerMountPrefixes hold container runtime layers and volumes rather
+// than host filesystems.
+var containerMountPrefixes = []string{
+	"/var/lib/docker/", "/var/lib/containerd/", "/run/containerd/",
+	"/run/docker/", "/var/lib/kubelet/pods/", "/var/lib/containers/",
+	"/snap/",
+}
+
+// loadHostConfig reads HOST_ROOT, HOST_PROC, HOST_SYS, HOST_ETC and
+// HOST_RUN. Unset paths are derived from HOST_ROOT when the directory exists
+// there.
+func loadHostConfig() (HostConfig, error) {
+	c := HostConfig{Root: os.Getenv("HOST_ROOT")}
+	if c.Root == "" {
+		c.Root = "/"
+	}
+	if info, err := os.Stat(c.Root); err != nil || !info.IsDir() {
+		return c, fmt.Errorf("HOST_ROOT %s is not a directory", c.Root)
+	}
+
+	derive := func(key, dir string) string {
+		if v := os.Getenv(key); v != "" {
+			return v
+		}
+		if candidate := filepath.Join(c.Root, dir); c.Root != "/" && fileExists(candidate) {
+			return candidate
+		}
+		return "/" + dir
+	}
+	c.Proc = derive("HOST_PROC", "proc")
+	c.Sys = derive("HOST_SYS", "sys")
+	c.Etc = derive("HOST_ETC", "etc")
+	c.Run = derive("HOST_RUN", "run")
+	return c, nil
+}
+
+// apply exports the configuration for gopsutil, which reads the same
+// environment variables.
+func (c HostConfig) apply() error {
+	for key, value := range map[string]string{
+		"HOST_ROOT": c.Root,
+		"HOST_PROC": c.Proc,
+		"HOST_SYS":  c.Sys,
+		"HOST_ETC":  c.Etc,
+		"HOST_RUN":  c.Run,
+	} {
+		if err := os.Setenv(key, value); err != nil {
+			return fmt.Errorf("error setting %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// Containerized reports whether a host root other than / is configured.
+func (c HostConfig) Containerized() bool {
+	return c.Root != "/"
+}
+
+// procPath joins elem onto the host's proc filesystem.
+func procPath(elem ...string) string {
+	return filepath.Join(append([]string{hostConfig.Proc}, elem...)...)
+}
+
+// sysPath joins elem onto the host's sysfs.
+func sysPath(elem ...string) string {
+	return filepath.Join(append([]string{hostConfig.Sys}, elem...)...)
+}
+
+// etcPath joins elem onto the host's /etc.
+func etcPath(elem ...string) string {
+	return filepath.Join(append([]string{hostConfig.Etc}, elem...)...)
+}
+
+// runPath joins elem onto the host's /run.
+func runPath(elem ...string) string {
+	return filepath.Join(append([]string{hostConfig.Run}, elem...)...)
+}
+
+// hostMountPoint translates a mount point as seen by the monitor into the
+// host's path. Mounts below HOST_ROOT lose the prefix; mounts read from the
+// host's mount table are already host paths.
+func hostMountPoint(mountpoint string) string {
+	if !hostConfig.Containerized() {
+		return mountpoint
+	}
+	if mountpoint == hostConfig.Root {
+		return "/"
+	}
+	if strings.HasPrefix(mountpoint, hostConfig.Root+"/") {
+		return strings.TrimPrefix(mountpoint, hostConfig.Root)
+	}
+	return mountpoint
+}
+
+// localMountPoint is the path the monitor has to stat to reach a host
+// mount point.
+func localMountPoint(hostPath string) string {
+	if !hostConfig.Containerized() {
+		return hostPath
+	}
+	return filepath.Join(hostConfig.Root, hostPath)
+}
+
+// hostPartitions lists the host's real filesystems with host mount points,
+// skipping pseudo filesystems, container layers, duplicate mounts and mounts
+// rejected by diskFilter.
+func hostPartitions() ([]disk.PartitionStat, error) {
+	partitions, err := disk.Partitions(false)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var result []disk.PartitionStat
+	for _, p := range partitions {
+		p.Mountpoint = hostMountPoint(p.Mountpoint)
+		if pseudoFSTypes[p.Fstype] || seen[p.Mountpoint] || !diskFilter.Match(p) {
+			continue
+		}
+		container := false
+		for _, prefix := range containerMountPrefixes {
+			if strings.HasPrefix(p.Mountpoint+"/", prefix) {
+				container = true
+				break
+			}
+		}
+		if container {
+			continue
+		}
+		seen[p.Mountpoint] = true
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+// hostHostname returns the host's name. Inside a container os.Hostname
+// reports the container's name, so the host's /etc/hostname is preferred.
+func hostHostname(fallback string) string {
+	if !hostConfig.Containerized() {
+		return fallback
+	}
+	if name := readString(etcPath("hostname")); name != "" {
+		return name
+	}
+	return fallback
+}
+
+// logHostReport logs what the monitor is observing so misconfigured mounts
+// are obvious at startup.
+func logHostReport() {
+	c := hostConfig
+	log.Printf("Host root: %s (proc=%s sys=%s etc=%s run=%s)", c.Root, c.Proc, c.Sys, c.Etc, c.Run)
+
+	for _, ns := range []string{"pid", "net", "mnt"} {
+		observed, err1 := os.Readlink(procPath("1", "ns", ns))
+		own, err2 := os.Readlink(filepath.Join("/proc", "self", "ns", ns))
+		if err1 != nil || err2 != nil {
+			log.Printf("Namespace %s: unknown (insufficient permissions)", ns)
+			continue
+		}
+		shared := "separate from the monitor"
+		if observed == own {
+			shared = "shared with the monitor"
+		}
+		log.Printf("Namespace %s: observing %s, %s", ns, observed, shared)
+	}
+
+	if h := detectCgroupHierarchy(); h != nil {
+		log.Printf("Cgroups: v%d at %s", h.version, h.root)
+	} else {
+		log.Printf("Cgroups: not found at %s", cgroupRoot())
+	}
+
+	partitions, err := hostPartitions()
+	if err != nil {
+		log.Printf("Warning: Could not list partitions: %v", err)
+		return
+	}
+	var mounts []string
+	for _, p := range partitions {
+		mounts = append(mounts, fmt.Sprintf("%s (%s)", p.Mountpoint, p.Fstype))
+	}
+	log.Printf("Filesystems: %s", strings.Join(mounts, ", "))
+}
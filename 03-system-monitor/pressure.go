@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/host"
+)
+
+// maxOOMEvents is how many recent OOM kills are kept in memory.
+const maxOOMEvents = 100
+
+// PressureStats is the Pressure Stall Information from /proc/pressure.
+type PressureStats struct {
+	CPU    *Pressure `json:"cpu,omitempty"`
+	Memory *Pressure `json:"memory,omitempty"`
+	IO     *Pressure `json:"io,omitempty"`
+}
+
+// Pressure holds the "some" and "full" lines of one PSI file. "some" is the
+// share of time at least one task was stalled, "full" the share of time all
+// non-idle tasks were stalled at once.
+type Pressure struct {
+	Some PressureLine  `json:"some"`
+	Full *PressureLine `json:"full,omitempty"`
+}
+
+type PressureLine struct {
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+	Total  uint64  `json:"total_usec"`
+}
+
+type OOMStats struct {
+	Kills        uint64     `json:"kills"`
+	RecentEvents []OOMEvent `json:"recent_events,omitempty"`
+}
+
+type OOMEvent struct {
+	Time        string `json:"time"`
+	PID         int32  `json:"pid"`
+	Process     string `json:"process"`
+	Cgroup      string `json:"cgroup,omitempty"`
+	ContainerID string `json:"container_id,omitempty"`
+	Message     string `json:"message"`
+}
+
+// getPressureStats reads /proc/pressure/{cpu,memory,io}. It returns nil if
+// the kernel does not expose PSI.
+func getPressureStats() (*PressureStats, error) {
+	stats := &PressureStats{}
+	var err error
+	if stats.CPU, err = readPressure(procPath("pressure", "cpu")); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if stats.Memory, err = readPressure(procPath("pressure", "memory")); err != nil {
+		return nil, err
+	}
+	if stats.IO, err = readPressure(procPath("pressure", "io")); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// readPressure parses lines such as
+// "some avg10=0.12 avg60=0.05 avg300=0.01 total=123456".
+func readPressure(path string) (*Pressure, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Pressure{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		var pl PressureLine
+		for _, field := range fields[1:] {
+			key, value, _ := strings.Cut(field, "=")
+			switch key {
+			case "avg10":
+				pl.Avg10, _ = strconv.ParseFloat(value, 64)
+			case "avg60":
+				pl.Avg60, _ = strconv.ParseFloat(value, 64)
+			case "avg300":
+				pl.Avg300, _ = strconv.ParseFloat(value, 64)
+			case "total":
+				pl.Total, _ = strconv.ParseUint(value, 10, 64)
+			}
+		}
+		switch fields[0] {
+		case "some":
+			p.Some = pl
+		case "full":
+			p.Full = &pl
+		}
+	}
+	return p, nil
+}
+
+// readOOMKillCount returns the oom_kill counter from /proc/vmstat, which
+// counts OOM kills since boot (Linux 4.13+).
+func readOOMKillCount() (uint64, error) {
+	kills, ok := readKeyValues(procPath("vmstat"))["oom_kill"]
+	if !ok {
+		return 0, fmt.Errorf("oom_kill not found in vmstat")
+	}
+	return kills, nil
+}
+
+var (
+	killedProcessPattern = regexp.MustCompile(`Kill(?:ed)? process (\d+) \(([^)]*)\)`)
+	oomKillPattern       = regexp.MustCompile(`oom-kill:.*task_memcg=([^,]*),.*pid=(\d+)`)
+)
+
+// oomTracker keeps recent OOM kill events parsed from kernel messages.
+type oomTracker struct {
+	mu     sync.RWMutex
+	events []OOMEvent
+}
+
+var oomEvents = &oomTracker{}
+
+// Events returns the recent OOM kills, newest last.
+func (t *oomTracker) Events() []OOMEvent {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return append([]OOMEvent(nil), t.events...)
+}
+
+func (t *oomTracker) add(event OOMEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, event)
+	if len(t.events) > maxOOMEvents {
+		t.events = t.events[len(t.events)-maxOOMEvents:]
+	}
+}
+
+// watchKernelLog follows the kernel log at path (normally /dev/kmsg) and
+// records OOM kills. Reading /dev/kmsg from the start replays the kernel
+// ring buffer, so kills from before the monitor started are picked up too.
+// A regular file is read to the end, which is how fixtures are replayed.
+func (t *oomTracker) watchKernelLog(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("Warning: OOM event tracking disabled, cannot open %s: %v", path, err)
+		return
+	}
+	defer f.Close()
+
+	bootTime, err := host.BootTime()
+	if err != nil {
+		log.Printf("Warning: Could not get boot time, OOM event times will be approximate: %v", err)
+	}
+
+	memcgs := make(map[string]string)
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadString('\n')
+		if errors.Is(err, syscall.EPIPE) {
+			// Messages were overwritten before we read them; keep going.
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		// Records look like "6,1234,5678901,-;message" where the third field
+		// is microseconds since boot.
+		prefix, message, ok := strings.Cut(strings.TrimSpace(line), ";")
+		if !ok {
+			continue
+		}
+		eventTime := time.Now()
+		if fields := strings.Split(prefix, ","); len(fields) >= 3 && bootTime > 0 {
+			if usec, err := strconv.ParseInt(fields[2], 10, 64); err == nil {
+				eventTime = time.Unix(int64(bootTime), usec*1000)
+			}
+		}
+
+		if m := oomKillPattern.FindStringSubmatch(message); m != nil {
+			memcgs[m[2]] = m[1]
+			continue
+		}
+		m := killedProcessPattern.FindStringSubmatch(message)
+		if m == nil {
+			continue
+		}
+		pid, _ := strconv.ParseInt(m[1], 10, 32)
+		event := OOMEvent{
+			Time:    eventTime.UTC().Format(time.RFC3339),
+			PID:     int32(pid),
+			Process: m[2],
+			Cgroup:  memcgs[m[1]],
+			Message: message,
+		}
+		event.ContainerID = containerIDFromCgroup(event.Cgroup)
+		delete(memcgs, m[1])
+		t.add(event)
+	}
+}
+
+// getOOMStats combines the vmstat kill counter with recent kernel events.
+func getOOMStats() (*OOMStats, error) {
+	kills, err := readOOMKillCount()
+	if err != nil {
+		return nil, err
+	}
+	return &OOMStats{Kills: kills, RecentEvents: oomEvents.Events()}, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// useFixtureProc points procPath at dir for the duration of the test.
+func useFixtureProc(t *testing.T, dir string) {
+	t.Helper()
+	saved := hostConfig
+	hostConfig.Proc = dir
+	t.Cleanup(func() { hostConfig = saved })
+}
+
+func TestPressureFixture(t *testing.T) {
+	useFixtureProc(t, "testdata/proc")
+	stats, err := getPressureStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &PressureStats{
+		CPU: &Pressure{Some: PressureLine{Avg10: 1.5, Avg60: 0.75, Avg300: 0.2, Total: 123456789}},
+		Memory: &Pressure{
+			Some: PressureLine{Avg10: 12.34, Avg60: 5, Avg300: 1.25, Total: 987654},
+			Full: &PressureLine{Avg10: 8, Avg60: 2.5, Avg300: 0.5, Total: 456789},
+		},
+		IO: &Pressure{
+			Some: PressureLine{Total: 1000},
+			Full: &PressureLine{Total: 500},
+		},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("got  %+v\nwant %+v", stats, want)
+	}
+}
+
+func TestPressureUnsupported(t *testing.T) {
+	useFixtureProc(t, t.TempDir())
+	stats, err := getPressureStats()
+	if stats != nil || err != nil {
+		t.Errorf("got %+v, %v; want nil, nil without /proc/pressure", stats, err)
+	}
+}
+
+func TestOOMKillCountFixture(t *testing.T) {
+	useFixtureProc(t, "testdata/proc")
+	kills, err := readOOMKillCount()
+	if err != nil || kills != 3 {
+		t.Errorf("got %d, %v; want 3", kills, err)
+	}
+
+	useFixtureProc(t, t.TempDir())
+	if _, err := readOOMKillCount(); err == nil {
+		t.Error("expected an error without oom_kill in vmstat")
+	}
+}
+
+func TestWatchKernelLogReplay(t *testing.T) {
+	tracker := &oomTracker{}
+	tracker.watchKernelLog("testdata/kmsg")
+
+	events := tracker.Events()
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2: %+v", len(events), events)
+	}
+
+	id := strings.Repeat("a", 64)
+	container := events[0]
+	if container.PID != 4321 || container.Process != "stress" || container.ContainerID != id ||
+		container.Cgroup != "/system.slice/docker-"+id+".scope" {
+		t.Errorf("container kill = %+v", container)
+	}
+	if !strings.HasPrefix(container.Message, "Memory cgroup out of memory: Killed process 4321") {
+		t.Errorf("message = %q", container.Message)
+	}
+
+	host := events[1]
+	if host.PID != 555 || host.Process != "java" || host.Cgroup != "" || host.ContainerID != "" {
+		t.Errorf("host kill = %+v", host)
+	}
+	if host.Time == "" || host.Time == container.Time {
+		t.Errorf("event times %q and %q should come from the record timestamps", container.Time, host.Time)
+	}
+}
+
+func TestOOMTrackerKeepsRecentEvents(t *testing.T) {
+	tracker := &oomTracker{}
+	for i := 0; i < maxOOMEvents+5; i++ {
+		tracker.add(OOMEvent{PID: int32(i)})
+	}
+	events := tracker.Events()
+	if len(events) != maxOOMEvents || events[0].PID != 5 || events[len(events)-1].PID != maxOOMEvents+4 {
+		t.Errorf("kept %d events from PID %d to %d", len(events), events[0].PID, events[len(events)-1].PID)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// Values of the env query parameter on /processes/{pid}.
+const (
+	EnvRedacted = "redacted"
+	EnvFull     = "full"
+	EnvNone     = "none"
+)
+
+// defaultRedactPatterns are matched case-insensitively against environment
+// variable names whose values should not be exposed.
+var defaultRedactPatterns = []string{"PASS", "SECRET", "TOKEN", "KEY", "CREDENTIAL", "AUTH", "PRIVATE", "DSN", "COOKIE", "SESSION"}
+
+// urlUserinfo matches the user:password@ part of a URL anywhere in a value,
+// e.g. DATABASE_URL=postgres://app:hunter2@db/app.
+var urlUserinfo = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)[^/@\s]+@`)
+
+type ProcessDetail struct {
+	ProcessStats
+	Cmdline     []string            `json:"cmdline"`
+	Exe         string              `json:"exe,omitempty"`
+	Cwd         string              `json:"cwd,omitempty"`
+	Environ     map[string]string   `json:"environ,omitempty"`
+	Nice        int32               `json:"nice"`
+	IONice      int32               `json:"ionice"`
+	NumThreads  int32               `json:"num_threads"`
+	Threads     []ThreadStats       `json:"threads,omitempty"`
+	NumFDs      int32               `json:"num_fds"`
+	OpenFiles   []OpenFile          `json:"open_files,omitempty"`
+	Connections []ProcessConnection `json:"connections,omitempty"`
+	IO          *ProcessIO          `json:"io,omitempty"`
+	CtxSwitches *CtxSwitches        `json:"ctx_switches,omitempty"`
+	Memory      *ProcessMemory      `json:"memory,omitempty"`
+	MemoryMaps  *MemoryMapsSummary  `json:"memory_maps,omitempty"`
+	Cgroups     []CgroupMembership  `json:"cgroups,omitempty"`
+	Errors      map[string]string   `json:"errors,omitempty"`
+	EnvMode     string              `json:"env_mode"`
+}
+
+type ThreadStats struct {
+	TID    int32   `json:"tid"`
+	User   float64 `json:"user"`
+	System float64 `json:"system"`
+}
+
+type OpenFile struct {
+	FD   uint64 `json:"fd"`
+	Path string `json:"path"`
+}
+
+type ProcessConnection struct {
+	FD         uint32 `json:"fd"`
+	Family     string `json:"family"`
+	Type       string `json:"type"`
+	LocalAddr  string `json:"local_addr"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	Status     string `json:"status,omitempty"`
+}
+
+type ProcessIO struct {
+	ReadCount  uint64 `json:"read_count"`
+	WriteCount uint64 `json:"write_count"`
+	ReadBytes  uint64 `json:"read_bytes"`
+	WriteBytes uint64 `json:"write_bytes"`
+}
+
+type CtxSwitches struct {
+	Voluntary   int64 `json:"voluntary"`
+	Involuntary int64 `json:"involuntary"`
+}
+
+type ProcessMemory struct {
+	RSS  uint64 `json:"rss"`
+	VMS  uint64 `json:"vms"`
+	HWM  uint64 `json:"hwm"`
+	Data uint64 `json:"data"`
+	Swap uint64 `json:"swap"`
+}
+
+// MemoryMapsSummary totals /proc/<pid>/smaps across all mappings.
+type MemoryMapsSummary struct {
+	Size         uint64 `json:"size"`
+	RSS          uint64 `json:"rss"`
+	PSS          uint64 `json:"pss"`
+	SharedClean  uint64 `json:"shared_clean"`
+	SharedDirty  uint64 `json:"shared_dirty"`
+	PrivateClean uint64 `json:"private_clean"`
+	PrivateDirty uint64 `json:"private_dirty"`
+	Anonymous    uint64 `json:"anonymous"`
+	Swap         uint64 `json:"swap"`
+}
+
+// CgroupMembership is one line of /proc/<pid>/cgroup.
+type CgroupMembership struct {
+	HierarchyID int      `json:"hierarchy_id"`
+	Controllers []string `json:"controllers,omitempty"`
+	Path        string   `json:"path"`
+}
+
+var errProcessNotFound = fmt.Errorf("process not found")
+
+var connFamilies = map[uint32]string{1: "unix", 2: "inet", 10: "inet6"}
+var connTypes = map[uint32]string{1: "stream", 2: "dgram", 5: "seqpacket"}
+
+// connectionType names a socket type the way ss does: by protocol for
+// internet sockets and by socket type for everything else, e.g. unix.
+func connectionType(family, sockType uint32) string {
+	if family == 2 || family == 10 {
+		switch sockType {
+		case 1:
+			return "tcp"
+		case 2:
+			return "udp"
+		}
+	}
+	return connTypes[sockType]
+}
+
+// getProcessDetail collects everything gopsutil exposes about one process.
+// Fields that cannot be read (usually for permission reasons) are reported in
+// Errors rather than failing the whole request.
+func getProcessDetail(pid int32, envMode string) (*ProcessDetail, error) {
+	exists, err := process.PidExists(pid)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errProcessNotFound
+	}
+	p, err := process.NewProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := getProcessStats(p)
+	if err != nil {
+		return nil, err
+	}
+
+	detail := &ProcessDetail{
+		ProcessStats: stats,
+		EnvMode:      envMode,
+		Errors:       make(map[string]string),
+	}
+	record := func(field string, err error) bool {
+		if err != nil {
+			detail.Errors[field] = err.Error()
+			return false
+		}
+		return true
+	}
+
+	if v, err := p.CmdlineSlice(); record("cmdline", err) {
+		detail.Cmdline = v
+	}
+	if v, err := p.Exe(); record("exe", err) {
+		detail.Exe = v
+	}
+	if v, err := p.Cwd(); record("cwd", err) {
+		detail.Cwd = v
+	}
+	if envMode != EnvNone {
+		if v, err := p.Environ(); record("environ", err) {
+			detail.Environ = parseEnviron(v, envMode == EnvRedacted)
+		}
+	}
+	if v, err := p.Nice(); record("nice", err) {
+		detail.Nice = v
+	}
+	if v, err := p.IOnice(); record("ionice", err) {
+		detail.IONice = v
+	}
+	if v, err := p.NumThreads(); record("num_threads", err) {
+		detail.NumThreads = v
+	}
+	if v, err := p.Threads(); record("threads", err) {
+		for tid, times := range v {
+			detail.Threads = append(detail.Threads, ThreadStats{TID: tid, User: times.User, System: times.System})
+		}
+		sort.Slice(detail.Threads, func(i, j int) bool { return detail.Threads[i].TID < detail.Threads[j].TID })
+	}
+	if v, err := p.NumFDs(); record("num_fds", err) {
+		detail.NumFDs = v
+	}
+	if v, err := p.OpenFiles(); record("open_files", err) {
+		for _, f := range v {
+			detail.OpenFiles = append(detail.OpenFiles, OpenFile{FD: f.Fd, Path: f.Path})
+		}
+	}
+	if v, err := p.Connections(); record("connections", err) {
+		for _, c := range v {
+			conn := ProcessConnection{
+				FD:        c.Fd,
+				Family:    connFamilies[c.Family],
+				Type:      connectionType(c.Family, c.Type),
+				LocalAddr: fmt.Sprintf("%s:%d", c.Laddr.IP, c.Laddr.Port),
+				Status:    c.Status,
+			}
+			if c.Raddr.IP != "" {
+				conn.RemoteAddr = fmt.Sprintf("%s:%d", c.Raddr.IP, c.Raddr.Port)
+			}
+			detail.Connections = append(detail.Connections, conn)
+		}
+	}
+	if v, err := p.IOCounters(); record("io", err) {
+		detail.IO = &ProcessIO{
+			ReadCount:  v.ReadCount,
+			WriteCount: v.WriteCount,
+			ReadBytes:  v.ReadBytes,
+			WriteBytes: v.WriteBytes,
+		}
+	}
+	if v, err := p.NumCtxSwitches(); record("ctx_switches", err) {
+		detail.CtxSwitches = &CtxSwitches{Voluntary: v.Voluntary, Involuntary: v.Involuntary}
+	}
+	if v, err := p.MemoryInfo(); record("memory", err) {
+		detail.Memory = &ProcessMemory{RSS: v.RSS, VMS: v.VMS, HWM: v.HWM, Data: v.Data, Swap: v.Swap}
+	}
+	if v, err := p.MemoryMaps(true); record("memory_maps", err) && v != nil && len(*v) > 0 {
+		m := (*v)[0]
+		detail.MemoryMaps = &MemoryMapsSummary{
+			Size:         m.Size,
+			RSS:          m.Rss,
+			PSS:          m.Pss,
+			SharedClean:  m.SharedClean,
+			SharedDirty:  m.SharedDirty,
+			PrivateClean: m.PrivateClean,
+			PrivateDirty: m.PrivateDirty,
+			Anonymous:    m.Anonymous,
+			Swap:         m.Swap,
+		}
+	}
+	if v, err := readProcessCgroups(pid); record("cgroups", err) {
+		detail.Cgroups = v
+	}
+
+	if len(detail.Errors) == 0 {
+		detail.Errors = nil
+	}
+	return detail, nil
+}
+
+// parseEnviron converts KEY=value pairs into a map. When redact is set, the
+// values of sensitive-looking variables are replaced and credentials embedded
+// in URLs are removed from all others.
+func parseEnviron(environ []string, redact bool) map[string]string {
+	env := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		key, value, _ := strings.Cut(kv, "=")
+		if key == "" {
+			continue
+		}
+		if redact {
+			if isSensitiveEnv(key) {
+				value = "[REDACTED]"
+			} else {
+				value = urlUserinfo.ReplaceAllString(value, "${1}[REDACTED]@")
+			}
+		}
+		env[key] = value
+	}
+	return env
+}
+
+func isSensitiveEnv(key string) bool {
+	upper := strings.ToUpper(key)
+	for _, pattern := range defaultRedactPatterns {
+		if strings.Contains(upper, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// readProcessCgroups parses /proc/<pid>/cgroup. On cgroup v2 hosts there is a
+// single "0::/path" entry.
+func readProcessCgroups(pid int32) ([]CgroupMembership, error) {
+	f, err := os.Open(procPath(strconv.Itoa(int(pid)), "cgroup"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cgroups []CgroupMembership
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		id, _ := strconv.Atoi(fields[0])
+		cg := CgroupMembership{HierarchyID: id, Path: fields[2]}
+		if fields[1] != "" {
+			cg.Controllers = strings.Split(fields[1], ",")
+		}
+		cgroups = append(cgroups, cg)
+	}
+	return cgroups, scanner.Err()
+}
@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestParseEnvironRedacts(t *testing.T) {
+	env := parseEnviron([]string{
+		"HOME=/root",
+		"API_TOKEN=abc123",
+		"SENTRY_DSN=https://key@sentry.example.com/1",
+		"DATABASE_URL=postgres://app:hunter2@db:5432/app",
+		"UPSTREAMS=http://a:b@one,http://two",
+		"DOCS=https://example.com/a@b",
+	}, true)
+
+	want := map[string]string{
+		"HOME":         "/root",
+		"API_TOKEN":    "[REDACTED]",
+		"SENTRY_DSN":   "[REDACTED]",
+		"DATABASE_URL": "postgres://[REDACTED]@db:5432/app",
+		"UPSTREAMS":    "http://[REDACTED]@one,http://two",
+		"DOCS":         "https://example.com/a@b",
+	}
+	for key, value := range want {
+		if env[key] != value {
+			t.Errorf("%s = %q, want %q", key, env[key], value)
+		}
+	}
+}
+
+func TestParseEnvironFull(t *testing.T) {
+	env := parseEnviron([]string{"DATABASE_URL=postgres://app:hunter2@db/app"}, false)
+	if got := env["DATABASE_URL"]; got != "postgres://app:hunter2@db/app" {
+		t.Errorf("DATABASE_URL = %q, want it unmodified", got)
+	}
+}
+
+func TestConnectionType(t *testing.T) {
+	tests := []struct {
+		family, sockType uint32
+		want             string
+	}{
+		{2, 1, "tcp"},
+		{10, 2, "udp"},
+		{1, 1, "stream"},
+		{1, 2, "dgram"},
+		{1, 5, "seqpacket"},
+		{10, 5, "seqpacket"},
+	}
+	for _, tt := range tests {
+		if got := connectionType(tt.family, tt.sockType); got != tt.want {
+			t.Errorf("connectionType(%d, %d) = %q, want %q", tt.family, tt.sockType, got, tt.want)
+		}
+	}
+}
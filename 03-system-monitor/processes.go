@@ -0,0 +1,183 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const maxProcessLimit = 1000
+
+// maxProcessOffset is the kernel's upper bound on pid_max; no process list
+// can be longer.
+const maxProcessOffset = 1 << 22
+
+// processLess orders processes for each supported sort key, ascending.
+var processLess = map[string]func(a, b *ProcessStats) bool{
+	"cpu":         func(a, b *ProcessStats) bool { return a.CPUPercent < b.CPUPercent },
+	"memory":      func(a, b *ProcessStats) bool { return a.MemoryPerc < b.MemoryPerc },
+	"rss":         func(a, b *ProcessStats) bool { return a.MemoryUsage < b.MemoryUsage },
+	"pid":         func(a, b *ProcessStats) bool { return a.PID < b.PID },
+	"create_time": func(a, b *ProcessStats) bool { return a.CreateTime < b.CreateTime },
+	"name":        func(a, b *ProcessStats) bool { return a.Name < b.Name },
+}
+
+// ProcessQuery selects, orders and pages the process list.
+type ProcessQuery struct {
+	Limit  int
+	Offset int
+	SortBy string
+	Asc    bool
+
+	Name      *regexp.Regexp
+	User      string
+	Status    string
+	PPID      *int32
+	Container string
+}
+
+func defaultProcessQuery(limit int) ProcessQuery {
+	return ProcessQuery{Limit: limit, SortBy: "cpu"}
+}
+
+// parseProcessQuery builds a ProcessQuery from /processes query parameters.
+func parseProcessQuery(values url.Values) (ProcessQuery, error) {
+	q := defaultProcessQuery(10)
+
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxProcessLimit {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxProcessLimit)
+		}
+		q.Limit = n
+	}
+	if v := values.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxProcessOffset {
+			return q, fmt.Errorf("offset must be between 0 and %d", maxProcessOffset)
+		}
+		q.Offset = n
+	}
+	if v := values.Get("sort"); v != "" {
+		if _, ok := processLess[v]; !ok {
+			return q, fmt.Errorf("invalid sort key %q", v)
+		}
+		q.SortBy = v
+	}
+	switch values.Get("order") {
+	case "", "desc":
+	case "asc":
+		q.Asc = true
+	default:
+		return q, fmt.Errorf("order must be asc or desc")
+	}
+	if v := values.Get("name"); v != "" {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return q, fmt.Errorf("invalid name pattern: %v", err)
+		}
+		q.Name = re
+	}
+	q.User = values.Get("user")
+	q.Status = values.Get("status")
+	q.Container = values.Get("container")
+	if v := values.Get("ppid"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return q, fmt.Errorf("invalid ppid %q", v)
+		}
+		ppid := int32(n)
+		q.PPID = &ppid
+	}
+	return q, nil
+}
+
+func (q *ProcessQuery) matches(p *ProcessStats) bool {
+	if q.Name != nil && !q.Name.MatchString(p.Name) {
+		return false
+	}
+	if q.User != "" && p.Username != q.User {
+		return false
+	}
+	if q.PPID != nil && p.PPID != *q.PPID {
+		return false
+	}
+	if q.Container != "" && (p.ContainerID == "" || !strings.HasPrefix(p.ContainerID, q.Container)) {
+		return false
+	}
+	if q.Status != "" {
+		found := false
+		for _, s := range strings.Split(p.Status, ", ") {
+			if strings.EqualFold(s, q.Status) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// processHeap keeps the k best processes seen so far with the worst one on
+// top, so it can be evicted in O(log k).
+type processHeap struct {
+	items  []ProcessStats
+	better func(a, b *ProcessStats) bool
+}
+
+func (h *processHeap) Len() int           { return len(h.items) }
+func (h *processHeap) Less(i, j int) bool { return h.better(&h.items[j], &h.items[i]) }
+func (h *processHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *processHeap) Push(x interface{}) { h.items = append(h.items, x.(ProcessStats)) }
+func (h *processHeap) Pop() interface{} {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+// Apply filters processes and returns the requested page along with the
+// number of processes that matched the filters.
+func (q *ProcessQuery) Apply(processes []ProcessStats) ([]ProcessStats, int) {
+	less := processLess[q.SortBy]
+	better := func(a, b *ProcessStats) bool {
+		if q.Asc {
+			return less(a, b)
+		}
+		return less(b, a)
+	}
+
+	// Clamp k so large offsets can neither overflow nor make the heap
+	// bigger than the input.
+	k := len(processes)
+	if q.Offset < k && q.Limit < k-q.Offset {
+		k = q.Offset + q.Limit
+	}
+	h := &processHeap{better: better}
+	total := 0
+	for i := range processes {
+		p := &processes[i]
+		if !q.matches(p) {
+			continue
+		}
+		total++
+		if h.Len() < k {
+			heap.Push(h, *p)
+		} else if h.Len() > 0 && better(p, &h.items[0]) {
+			h.items[0] = *p
+			heap.Fix(h, 0)
+		}
+	}
+
+	selected := h.items
+	sort.SliceStable(selected, func(i, j int) bool { return better(&selected[i], &selected[j]) })
+	if q.Offset >= len(selected) {
+		return []ProcessStats{}, total
+	}
+	return selected[q.Offset:], total
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseProcessQueryRejectsHugeOffset(t *testing.T) {
+	if _, err := parseProcessQuery(url.Values{"offset": {"9223372036854775807"}}); err == nil {
+		t.Fatal("expected an error for an offset beyond pid_max")
+	}
+}
+
+func TestProcessQueryApplyPaging(t *testing.T) {
+	processes := []ProcessStats{
+		{PID: 1, CPUPercent: 5},
+		{PID: 2, CPUPercent: 50},
+		{PID: 3, CPUPercent: 20},
+	}
+
+	tests := []struct {
+		name   string
+		offset int
+		limit  int
+		want   []int32
+	}{
+		{"first page", 0, 2, []int32{2, 3}},
+		{"second page", 2, 2, []int32{1}},
+		{"offset past end", 5, 2, nil},
+		{"largest offset", maxProcessOffset, maxProcessLimit, nil},
+		{"overflowing offset", int(^uint(0) >> 1), 10, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := defaultProcessQuery(tt.limit)
+			q.Offset = tt.offset
+			page, total := q.Apply(processes)
+			if total != len(processes) {
+				t.Errorf("total = %d, want %d", total, len(processes))
+			}
+			if len(page) != len(tt.want) {
+				t.Fatalf("got %d processes, want %d", len(page), len(tt.want))
+			}
+			for i, p := range page {
+				if p.PID != tt.want[i] {
+					t.Errorf("page[%d].PID = %d, want %d", i, p.PID, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestProcessQueryApplyEmpty(t *testing.T) {
+	q := defaultProcessQuery(10)
+	if page, total := q.Apply(nil); len(page) != 0 || total != 0 {
+		t.Errorf("got %d processes (total %d) from an empty list", len(page), total)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPushInterval   = 15 * time.Second
+	defaultPushBufferSize = 100 << 20
+	maxPushBackoff        = 5 * time.Minute
+)
+
+// PushConfig configures push mode, where samples are sent to an endpoint
+// instead of (or as well as) being scraped.
+type PushConfig struct {
+	URL         string
+	Format      pushFormat
+	Interval    time.Duration
+	BufferDir   string
+	BufferBytes int64
+	BearerToken string
+}
+
+// loadPushConfig reads the PUSH_* variables. It returns nil if PUSH_URL is
+// not set.
+func loadPushConfig() (*PushConfig, error) {
+	c := &PushConfig{URL: os.Getenv("PUSH_URL")}
+	if c.URL == "" {
+		return nil, nil
+	}
+	if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("PUSH_URL: invalid URL %q", c.URL)
+	}
+
+	name := os.Getenv("PUSH_FORMAT")
+	if name == "" {
+		name = "remote_write"
+	}
+	format, ok := pushFormats[name]
+	if !ok {
+		return nil, fmt.Errorf("PUSH_FORMAT: unknown format %q (want remote_write, influx or json)", name)
+	}
+	c.Format = format
+
+	var err error
+	if c.Interval, err = envDuration("PUSH_INTERVAL", defaultPushInterval); err != nil {
+		return nil, err
+	}
+	c.BufferDir = os.Getenv("PUSH_BUFFER_DIR")
+	if c.BufferDir == "" {
+		c.BufferDir = filepath.Join(os.TempDir(), "system-monitor-push")
+	}
+	c.BufferBytes = defaultPushBufferSize
+	if v := os.Getenv("PUSH_BUFFER_BYTES"); v != "" {
+		if c.BufferBytes, err = strconv.ParseInt(v, 10, 64); err != nil || c.BufferBytes <= 0 {
+			return nil, fmt.Errorf("PUSH_BUFFER_BYTES: invalid size %q", v)
+		}
+	}
+	c.BearerToken = os.Getenv("PUSH_BEARER_TOKEN")
+	return c, nil
+}
+
+// pusher batches samples and sends them on an interval. Every batch is
+// written to the buffer directory before it is sent and removed once the
+// endpoint accepts it, so batches survive both outages and restarts.
+type pusher struct {
+	config *PushConfig
+	client *http.Client
+
+	mu      sync.Mutex
+	pending []*Sample
+
+	failures  int
+	nextRetry time.Time
+}
+
+func newPusher(config *PushConfig) (*pusher, error) {
+	if err := os.MkdirAll(config.BufferDir, 0o700); err != nil {
+		return nil, fmt.Errorf("error creating push buffer: %v", err)
+	}
+	return &pusher{config: config, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// Add is registered as a sampler handler.
+func (p *pusher) Add(sample *Sample) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending = append(p.pending, sample)
+}
+
+func (p *pusher) run() {
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if err := p.buffer(); err != nil {
+			log.Printf("Warning: Could not buffer push batch: %v", err)
+		}
+		if now.Before(p.nextRetry) {
+			continue
+		}
+		if err := p.flush(); err != nil {
+			p.failures++
+			backoff := p.backoff()
+			p.nextRetry = now.Add(backoff)
+			log.Printf("Warning: Push to %s failed, retrying in %s: %v", p.config.URL, backoff.Round(time.Second), err)
+			continue
+		}
+		if p.failures > 0 {
+			log.Printf("Push to %s recovered after %d failed attempts", p.config.URL, p.failures)
+		}
+		p.failures = 0
+	}
+}
+
+// backoff doubles the push interval for every consecutive failure, up to
+// maxPushBackoff, with up to 20% jitter so a fleet doesn't retry in step.
+func (p *pusher) backoff() time.Duration {
+	backoff := p.config.Interval
+	for i := 1; i < p.failures && backoff < maxPushBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxPushBackoff {
+		backoff = maxPushBackoff
+	}
+	return backoff + time.Duration(rand.Int63n(int64(backoff)/5+1))
+}
+
+// buffer encodes the pending samples and writes them to a new batch file.
+func (p *pusher) buffer() error {
+	p.mu.Lock()
+	samples := p.pending
+	p.pending = nil
+	p.mu.Unlock()
+	if len(samples) == 0 {
+		return nil
+	}
+
+	host := samples[len(samples)-1].Stats.HostInfo.Hostname
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	body, err := p.config.Format.encode(host, samples)
+	if err != nil {
+		return err
+	}
+
+	// Zero-padded nanoseconds keep the files in send order when listed.
+	name := fmt.Sprintf("%020d%s", samples[0].Time.UnixNano(), p.config.Format.extension)
+	tmp := filepath.Join(p.config.BufferDir, "."+name)
+	if err := os.WriteFile(tmp, body, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(p.config.BufferDir, name)); err != nil {
+		return err
+	}
+	return p.trim()
+}
+
+// batches lists buffered batch files in the configured format, oldest
+// first.
+func (p *pusher) batches() ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(p.config.BufferDir)
+	if err != nil {
+		return nil, err
+	}
+	var files []os.FileInfo
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || filepath.Ext(entry.Name()) != p.config.Format.extension {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			files = append(files, info)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	return files, nil
+}
+
+// trim drops the oldest batches once the buffer exceeds its size limit.
+func (p *pusher) trim() error {
+	files, err := p.batches()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, f := range files {
+		total += f.Size()
+	}
+	dropped := 0
+	for _, f := range files {
+		if total <= p.config.BufferBytes {
+			break
+		}
+		if err := os.Remove(filepath.Join(p.config.BufferDir, f.Name())); err != nil {
+			return err
+		}
+		total -= f.Size()
+		dropped++
+	}
+	if dropped > 0 {
+		log.Printf("Warning: Push buffer full, dropped %d oldest batches", dropped)
+	}
+	return nil
+}
+
+// flush sends buffered batches in order, stopping at the first failure.
+func (p *pusher) flush() error {
+	files, err := p.batches()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		path := filepath.Join(p.config.BufferDir, f.Name())
+		body, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := p.send(body); err != nil {
+			if _, ok := err.(rejectedError); !ok {
+				return err
+			}
+			// Retrying a batch the endpoint rejects would block the
+			// buffer forever.
+			log.Printf("Warning: Dropping push batch %s: %v", f.Name(), err)
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rejectedError is a response saying the batch itself is invalid, which
+// will not succeed on retry. Other 4xx responses, such as an expired token
+// or a misconfigured URL, are retried until the endpoint is fixed.
+type rejectedError struct{ status string }
+
+func (e rejectedError) Error() string { return "endpoint rejected batch: " + e.status }
+
+func (p *pusher) send(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, p.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, value := range p.config.Format.headers {
+		req.Header.Set(key, value)
+	}
+	if p.config.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.config.BearerToken)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusRequestEntityTooLarge || resp.StatusCode == http.StatusUnprocessableEntity:
+		return rejectedError{resp.Status}
+	default:
+		return fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+}
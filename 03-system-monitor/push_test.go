@@ -0,0 +1,222 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testReceiver answers pushes with the queued status codes, then 204, and
+// records every body it accepts.
+type testReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	accepted [][]byte
+	requests int
+	auth     string
+}
+
+func (r *testReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests++
+	r.auth = req.Header.Get("Authorization")
+	status := http.StatusNoContent
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	if status < 300 {
+		r.accepted = append(r.accepted, body)
+	}
+	w.WriteHeader(status)
+}
+
+func newTestPusher(t *testing.T, format string, receiver *testReceiver) *pusher {
+	t.Helper()
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+	p, err := newPusher(&PushConfig{
+		URL:         server.URL,
+		Format:      pushFormats[format],
+		Interval:    time.Second,
+		BufferDir:   t.TempDir(),
+		BufferBytes: defaultPushBufferSize,
+		BearerToken: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func bufferedBatches(t *testing.T, p *pusher) int {
+	t.Helper()
+	files, err := p.batches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func TestPusherSendsEachFormat(t *testing.T) {
+	for name := range pushFormats {
+		t.Run(name, func(t *testing.T) {
+			receiver := &testReceiver{}
+			p := newTestPusher(t, name, receiver)
+			for _, s := range testSamples() {
+				p.Add(s)
+			}
+			if err := p.buffer(); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.flush(); err != nil {
+				t.Fatal(err)
+			}
+			if len(receiver.accepted) != 1 || bufferedBatches(t, p) != 0 {
+				t.Fatalf("accepted %d batches, %d left in the buffer", len(receiver.accepted), bufferedBatches(t, p))
+			}
+			if receiver.auth != "Bearer secret" {
+				t.Errorf("Authorization = %q", receiver.auth)
+			}
+			want, _ := pushFormats[name].encode("web1", testSamples())
+			if name != "remote_write" && string(receiver.accepted[0]) != string(want) {
+				t.Errorf("body = %s, want %s", receiver.accepted[0], want)
+			}
+			if name == "remote_write" && len(decodeRemoteWrite(t, receiver.accepted[0])) != 3 {
+				t.Error("remote_write body did not decode to 3 series")
+			}
+		})
+	}
+}
+
+func TestPusherKeepsBatchesUntilAccepted(t *testing.T) {
+	receiver := &testReceiver{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	p := newTestPusher(t, "json", receiver)
+
+	samples := testSamples()
+	p.Add(samples[0])
+	if err := p.buffer(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.flush(); err == nil {
+		t.Fatal("flush succeeded on 503")
+	}
+	p.Add(samples[1])
+	if err := p.buffer(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.flush(); err == nil {
+		t.Fatal("flush succeeded on 429")
+	}
+	if n := bufferedBatches(t, p); n != 2 {
+		t.Fatalf("%d batches buffered, want 2", n)
+	}
+
+	if err := p.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := bufferedBatches(t, p); n != 0 {
+		t.Fatalf("%d batches buffered after recovery, want 0", n)
+	}
+	// Batches are replayed oldest first.
+	first, _ := encodeJSONBatch("web1", samples[:1])
+	if len(receiver.accepted) != 2 || string(receiver.accepted[0]) != string(first) {
+		t.Errorf("accepted = %q", receiver.accepted)
+	}
+}
+
+func TestPusherDropsRejectedBatches(t *testing.T) {
+	tests := []struct {
+		status   int
+		buffered int
+	}{
+		{http.StatusBadRequest, 0},
+		{http.StatusRequestEntityTooLarge, 0},
+		{http.StatusUnprocessableEntity, 0},
+		// Errors in the endpoint's configuration must not lose batches.
+		{http.StatusUnauthorized, 2},
+		{http.StatusForbidden, 2},
+		{http.StatusNotFound, 2},
+		{http.StatusTooManyRequests, 2},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			receiver := &testReceiver{statuses: []int{tt.status}}
+			p := newTestPusher(t, "influx", receiver)
+			for _, s := range testSamples() {
+				p.Add(s)
+				if err := p.buffer(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := p.flush()
+			if tt.buffered == 0 {
+				if err != nil {
+					t.Fatalf("flush = %v, want the rejected batch dropped", err)
+				}
+				if receiver.requests != 2 || len(receiver.accepted) != 1 || bufferedBatches(t, p) != 0 {
+					t.Errorf("requests = %d, accepted = %d, buffered = %d", receiver.requests, len(receiver.accepted), bufferedBatches(t, p))
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("flush succeeded, want an error to back off")
+			}
+			if receiver.requests != 1 || bufferedBatches(t, p) != tt.buffered {
+				t.Errorf("requests = %d, buffered = %d", receiver.requests, bufferedBatches(t, p))
+			}
+		})
+	}
+}
+
+func TestPusherTrimsOldestBatches(t *testing.T) {
+	p := newTestPusher(t, "json", &testReceiver{})
+	samples := testSamples()
+	p.Add(samples[0])
+	if err := p.buffer(); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := p.batches()
+	p.config.BufferBytes = files[0].Size()
+
+	p.Add(samples[1])
+	if err := p.buffer(); err != nil {
+		t.Fatal(err)
+	}
+	files, _ = p.batches()
+	if len(files) != 1 {
+		t.Fatalf("%d batches buffered, want 1", len(files))
+	}
+	body, _ := os.ReadFile(filepath.Join(p.config.BufferDir, files[0].Name()))
+	newest, _ := encodeJSONBatch("web1", samples[1:])
+	if string(body) != string(newest) {
+		t.Errorf("kept %s, want the newest batch", body)
+	}
+}
+
+func TestPusherBackoff(t *testing.T) {
+	p := &pusher{config: &PushConfig{Interval: 15 * time.Second}}
+	tests := []struct {
+		failures int
+		min      time.Duration
+	}{
+		{1, 15 * time.Second},
+		{2, 30 * time.Second},
+		{3, time.Minute},
+		{20, maxPushBackoff},
+	}
+	for _, tt := range tests {
+		p.failures = tt.failures
+		for i := 0; i < 10; i++ {
+			if got := p.backoff(); got < tt.min || got > tt.min+tt.min/5 {
+				t.Errorf("backoff after %d failures = %s, want %s plus up to 20%%", tt.failures, got, tt.min)
+			}
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pushFormat encodes a batch of samples for one kind of push endpoint.
+type pushFormat struct {
+	name      string
+	extension string
+	headers   map[string]string
+	encode    func(host string, samples []*Sample) ([]byte, error)
+}
+
+var pushFormats = map[string]pushFormat{
+	"remote_write": {
+		name:      "remote_write",
+		extension: ".prw",
+		headers: map[string]string{
+			"Content-Type":                      "application/x-protobuf",
+			"Content-Encoding":                  "snappy",
+			"X-Prometheus-Remote-Write-Version": "0.1.0",
+		},
+		encode: encodeRemoteWrite,
+	},
+	"influx": {
+		name:      "influx",
+		extension: ".lp",
+		headers:   map[string]string{"Content-Type": "text/plain; charset=utf-8"},
+		encode:    encodeInfluxLines,
+	},
+	"json": {
+		name:      "json",
+		extension: ".json",
+		headers:   map[string]string{"Content-Type": "application/json"},
+		encode:    encodeJSONBatch,
+	},
+}
+
+// bracketLabels names the label carried in the [...] part of a flattened
+// metric, keyed by the path segment in front of it.
+var bracketLabels = map[string]string{
+	"disk":         "mountpoint",
+	"disk_io":      "device",
+	"network":      "interface",
+	"container":    "container_id",
+	"collector":    "collector",
+	"load_average": "index",
+	"per_cpu":      "cpu",
+	"tcp_states":   "state",
+}
+
+// metricPoint is a flattened metric name split into its parts, e.g.
+// "disk[/].usage_percentage" is measurement "disk", field
+// "usage_percentage" and label mountpoint="/".
+type metricPoint struct {
+	measurement string
+	field       string
+	labelKey    string
+	labelValue  string
+}
+
+func splitMetric(name string) metricPoint {
+	var p metricPoint
+	if i := strings.Index(name, "["); i >= 0 {
+		if j := strings.Index(name[i:], "]"); j >= 0 {
+			before := name[:i]
+			p.labelKey = bracketLabels[before[strings.LastIndex(before, ".")+1:]]
+			if p.labelKey == "" {
+				p.labelKey = "key"
+			}
+			p.labelValue = name[i+1 : i+j]
+			name = before + name[i+j+1:]
+		}
+	}
+	if measurement, field, ok := strings.Cut(name, "."); ok {
+		p.measurement, p.field = measurement, strings.ReplaceAll(field, ".", "_")
+	} else {
+		p.measurement, p.field = "system", name
+	}
+	return p
+}
+
+var invalidPromChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+// promName is the Prometheus metric name, e.g. system_disk_usage_percentage.
+func (p metricPoint) promName() string {
+	name := "system_" + p.field
+	if p.measurement != "system" {
+		name = "system_" + p.measurement + "_" + p.field
+	}
+	return invalidPromChars.ReplaceAllString(name, "_")
+}
+
+// encodeRemoteWrite builds a snappy-compressed Prometheus remote-write
+// WriteRequest. The protobuf is encoded by hand; the messages used are
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+func encodeRemoteWrite(host string, samples []*Sample) ([]byte, error) {
+	type series struct {
+		labels [][2]string
+		points []*Sample
+	}
+	byName := make(map[string]*series)
+	var names []string
+	for _, sample := range samples {
+		for name := range sample.Values {
+			if _, ok := byName[name]; ok {
+				continue
+			}
+			p := splitMetric(name)
+			labels := [][2]string{{"__name__", p.promName()}, {"host", host}}
+			if p.labelKey != "" {
+				labels = append(labels, [2]string{p.labelKey, p.labelValue})
+			}
+			sort.Slice(labels, func(i, j int) bool { return labels[i][0] < labels[j][0] })
+			byName[name] = &series{labels: labels}
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var request []byte
+	for _, name := range names {
+		s := byName[name]
+		var ts []byte
+		for _, label := range s.labels {
+			var l []byte
+			l = appendProtoString(l, 1, label[0])
+			l = appendProtoString(l, 2, label[1])
+			ts = appendProtoBytes(ts, 1, l)
+		}
+		for _, sample := range samples {
+			v, ok := sample.Values[name]
+			if !ok {
+				continue
+			}
+			var sm []byte
+			sm = append(sm, 1<<3|1) // field 1, 64-bit
+			sm = binary.LittleEndian.AppendUint64(sm, math.Float64bits(v))
+			sm = append(sm, 2<<3|0) // field 2, varint
+			sm = binary.AppendUvarint(sm, uint64(sample.Time.UnixMilli()))
+			ts = appendProtoBytes(ts, 2, sm)
+		}
+		request = appendProtoBytes(request, 1, ts)
+	}
+	return snappyEncode(request), nil
+}
+
+func appendProtoBytes(b []byte, field int, value []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+func appendProtoString(b []byte, field int, value string) []byte {
+	return appendProtoBytes(b, field, []byte(value))
+}
+
+// snappyEncode produces a snappy block made only of literals. That is valid
+// snappy that any decoder accepts; it just isn't compressed, which keeps the
+// monitor free of a compression dependency.
+func snappyEncode(src []byte) []byte {
+	dst := binary.AppendUvarint(nil, uint64(len(src)))
+	const maxLiteral = 1 << 16
+	for len(src) > 0 {
+		n := len(src)
+		if n > maxLiteral {
+			n = maxLiteral
+		}
+		// Literal tag: lengths up to 60 fit in the tag byte, longer ones
+		// follow it as a 1 or 2 byte little-endian length.
+		switch l := n - 1; {
+		case l < 60:
+			dst = append(dst, byte(l)<<2)
+		case l < 1<<8:
+			dst = append(dst, 60<<2, byte(l))
+		default:
+			dst = append(dst, 61<<2, byte(l), byte(l>>8))
+		}
+		dst = append(dst, src[:n]...)
+		src = src[n:]
+	}
+	return dst
+}
+
+var influxEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+// encodeInfluxLines writes InfluxDB line protocol with one line per
+// measurement, tag set and sample, e.g.
+// "disk,host=web1,mountpoint=/ free=1024,used=2048 1700000000000000000".
+func encodeInfluxLines(host string, samples []*Sample) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, sample := range samples {
+		lines := make(map[string][]string)
+		for name, v := range sample.Values {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+			p := splitMetric(name)
+			key := influxEscaper.Replace(p.measurement) + ",host=" + influxEscaper.Replace(host)
+			if p.labelKey != "" && p.labelValue != "" {
+				key += "," + p.labelKey + "=" + influxEscaper.Replace(p.labelValue)
+			}
+			lines[key] = append(lines[key], influxEscaper.Replace(p.field)+"="+strconv.FormatFloat(v, 'g', -1, 64))
+		}
+		keys := make([]string, 0, len(lines))
+		for key := range lines {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fields := lines[key]
+			sort.Strings(fields)
+			fmt.Fprintf(&buf, "%s %s %d\n", key, strings.Join(fields, ","), sample.Time.UnixNano())
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeJSONBatch writes {"host": ..., "samples": [{"timestamp", "values"}]}
+// using the same metric names as the history API.
+func encodeJSONBatch(host string, samples []*Sample) ([]byte, error) {
+	type jsonSample struct {
+		Timestamp string             `json:"timestamp"`
+		Values    map[string]float64 `json:"values"`
+	}
+	batch := struct {
+		Host    string       `json:"host"`
+		Samples []jsonSample `json:"samples"`
+	}{Host: host}
+	for _, sample := range samples {
+		values := make(map[string]float64, len(sample.Values))
+		for name, v := range sample.Values {
+			if !math.IsNaN(v) && !math.IsInf(v, 0) {
+				values[name] = v
+			}
+		}
+		batch.Samples = append(batch.Samples, jsonSample{
+			Timestamp: sample.Time.UTC().Format(time.RFC3339Nano),
+			Values:    values,
+		})
+	}
+	return json.Marshal(batch)
+}
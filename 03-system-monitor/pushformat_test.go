@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSamples() []*Sample {
+	start := time.Unix(1700000000, 0)
+	return []*Sample{
+		{Time: start, Stats: &SystemStats{HostInfo: HostInfo{Hostname: "web1"}}, Values: map[string]float64{
+			"cpu.usage":                25.5,
+			"disk[/].usage_percentage": 40,
+		}},
+		{Time: start.Add(time.Second), Stats: &SystemStats{HostInfo: HostInfo{Hostname: "web1"}}, Values: map[string]float64{
+			"cpu.usage":                30,
+			"disk[/].usage_percentage": 41,
+			"memory.usage_percentage":  math.NaN(),
+		}},
+	}
+}
+
+func TestSplitMetric(t *testing.T) {
+	tests := []struct {
+		name string
+		want metricPoint
+		prom string
+	}{
+		{"cpu.usage", metricPoint{measurement: "cpu", field: "usage"}, "system_cpu_usage"},
+		{"disk[/].usage_percentage", metricPoint{"disk", "usage_percentage", "mountpoint", "/"}, "system_disk_usage_percentage"},
+		{"pressure.memory.full.avg10", metricPoint{measurement: "pressure", field: "memory_full_avg10"}, "system_pressure_memory_full_avg10"},
+		{"network.tcp_states[LISTEN]", metricPoint{"network", "tcp_states", "state", "LISTEN"}, "system_network_tcp_states"},
+		{"uptime", metricPoint{measurement: "system", field: "uptime"}, "system_uptime"},
+	}
+	for _, tt := range tests {
+		got := splitMetric(tt.name)
+		if got != tt.want {
+			t.Errorf("splitMetric(%q) = %+v, want %+v", tt.name, got, tt.want)
+		}
+		if prom := got.promName(); prom != tt.prom {
+			t.Errorf("promName(%q) = %q, want %q", tt.name, prom, tt.prom)
+		}
+	}
+}
+
+// snappyDecode decodes the literal-only blocks written by snappyEncode.
+func snappyDecode(src []byte) ([]byte, error) {
+	n, k := binary.Uvarint(src)
+	if k <= 0 {
+		return nil, fmt.Errorf("invalid length")
+	}
+	src = src[k:]
+	var dst []byte
+	for len(src) > 0 {
+		tag := src[0]
+		if tag&3 != 0 {
+			return nil, fmt.Errorf("unexpected copy element %#x", tag)
+		}
+		l := int(tag >> 2)
+		src = src[1:]
+		switch l {
+		case 60:
+			l, src = int(src[0]), src[1:]
+		case 61:
+			l, src = int(src[0])|int(src[1])<<8, src[2:]
+		}
+		l++
+		if l > len(src) {
+			return nil, fmt.Errorf("literal overruns input")
+		}
+		dst, src = append(dst, src[:l]...), src[l:]
+	}
+	if uint64(len(dst)) != n {
+		return nil, fmt.Errorf("decoded %d bytes, header says %d", len(dst), n)
+	}
+	return dst, nil
+}
+
+type protoField struct {
+	num   int
+	wire  int
+	value uint64
+	bytes []byte
+}
+
+func readProto(b []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid key")
+		}
+		b = b[n:]
+		f := protoField{num: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case 0:
+			f.value, n = binary.Uvarint(b)
+			b = b[n:]
+		case 1:
+			f.value, b = binary.LittleEndian.Uint64(b), b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			b = b[n:]
+			f.bytes, b = b[:l], b[l:]
+		default:
+			return nil, fmt.Errorf("unexpected wire type %d", f.wire)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// decodeRemoteWrite returns "name{labels}" -> timestamp -> value.
+func decodeRemoteWrite(t *testing.T, body []byte) map[string]map[int64]float64 {
+	t.Helper()
+	raw, err := snappyDecode(body)
+	if err != nil {
+		t.Fatalf("snappy: %v", err)
+	}
+	request, err := readProto(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	series := make(map[string]map[int64]float64)
+	for _, ts := range request {
+		fields, err := readProto(ts.bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var labels []string
+		points := make(map[int64]float64)
+		for _, f := range fields {
+			parts, err := readProto(f.bytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch f.num {
+			case 1:
+				labels = append(labels, string(parts[0].bytes)+"="+string(parts[1].bytes))
+			case 2:
+				points[int64(parts[1].value)] = math.Float64frombits(parts[0].value)
+			}
+		}
+		if !sort.StringsAreSorted(labels) {
+			t.Errorf("labels not sorted: %v", labels)
+		}
+		series[strings.Join(labels, ",")] = points
+	}
+	return series
+}
+
+func TestEncodeRemoteWrite(t *testing.T) {
+	body, err := encodeRemoteWrite("web1", testSamples())
+	if err != nil {
+		t.Fatal(err)
+	}
+	series := decodeRemoteWrite(t, body)
+
+	cpu := series["__name__=system_cpu_usage,host=web1"]
+	if cpu[1700000000000] != 25.5 || cpu[1700000001000] != 30 {
+		t.Errorf("cpu series = %v", cpu)
+	}
+	disk := series["__name__=system_disk_usage_percentage,host=web1,mountpoint=/"]
+	if disk[1700000000000] != 40 || disk[1700000001000] != 41 {
+		t.Errorf("disk series = %v", disk)
+	}
+	if len(series) != 3 {
+		t.Errorf("got %d series, want 3: %v", len(series), series)
+	}
+}
+
+func TestSnappyEncodeLongLiterals(t *testing.T) {
+	for _, n := range []int{0, 1, 60, 61, 256, 257, 70000, 200000} {
+		src := make([]byte, n)
+		for i := range src {
+			src[i] = byte(i)
+		}
+		got, err := snappyDecode(snappyEncode(src))
+		if err != nil || string(got) != string(src) {
+			t.Errorf("round trip of %d bytes failed: %v", n, err)
+		}
+	}
+}
+
+func TestEncodeInfluxLines(t *testing.T) {
+	body, err := encodeInfluxLines("web 1", testSamples())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		`cpu,host=web\ 1 usage=25.5 1700000000000000000`,
+		`disk,host=web\ 1,mountpoint=/ usage_percentage=40 1700000000000000000`,
+		`cpu,host=web\ 1 usage=30 1700000001000000000`,
+		`disk,host=web\ 1,mountpoint=/ usage_percentage=41 1700000001000000000`,
+	}, "\n") + "\n"
+	if string(body) != want {
+		t.Errorf("got\n%s\nwant\n%s", body, want)
+	}
+}
+
+func TestEncodeJSONBatch(t *testing.T) {
+	body, err := encodeJSONBatch("web1", testSamples())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var batch struct {
+		Host    string `json:"host"`
+		Samples []struct {
+			Timestamp string             `json:"timestamp"`
+			Values    map[string]float64 `json:"values"`
+		} `json:"samples"`
+	}
+	if err := json.Unmarshal(body, &batch); err != nil {
+		t.Fatal(err)
+	}
+	if batch.Host != "web1" || len(batch.Samples) != 2 {
+		t.Fatalf("batch = %+v", batch)
+	}
+	if batch.Samples[0].Timestamp != "2023-11-14T22:13:20Z" || batch.Samples[0].Values["cpu.usage"] != 25.5 {
+		t.Errorf("first sample = %+v", batch.Samples[0])
+	}
+	if _, ok := batch.Samples[1].Values["memory.usage_percentage"]; ok {
+		t.Error("NaN values must be dropped")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// counterRates turns monotonically increasing counters into per-second rates
+// by remembering the previous reading for each key.
+type counterRates struct {
+	mu   sync.Mutex
+	prev map[string]counterReading
+}
+
+type counterReading struct {
+	time   time.Time
+	values []uint64
+}
+
+func newCounterRates() *counterRates {
+	return &counterRates{prev: make(map[string]counterReading)}
+}
+
+// Rates records values for key and returns the per-second rate of each value
+// since the previous call. The first reading, and any counter that went
+// backwards (e.g. after an interface reset), yields a rate of zero.
+func (c *counterRates) Rates(key string, now time.Time, values ...uint64) []float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rates := make([]float64, len(values))
+	prev, ok := c.prev[key]
+	c.prev[key] = counterReading{time: now, values: append([]uint64(nil), values...)}
+
+	elapsed := now.Sub(prev.time).Seconds()
+	if !ok || elapsed <= 0 || len(prev.values) != len(values) {
+		return rates
+	}
+	for i, v := range values {
+		if v >= prev.values[i] {
+			rates[i] = float64(v-prev.values[i]) / elapsed
+		}
+	}
+	return rates
+}
+
+// Prune forgets the keys that were not recorded by the collection made at
+// now, e.g. removed interfaces or containers, unless keep returns true for
+// them. keep may be nil.
+func (c *counterRates) Prune(now time.Time, keep func(key string) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, prev := range c.prev {
+		if prev.time.Before(now) && (keep == nil || !keep(key)) {
+			delete(c.prev, key)
+		}
+	}
+}
+
+// cpuPercent returns the CPU usage of a process since the previous call for
+// it, from its total CPU time. Readings are keyed by PID and create time so
+// a reused PID starts from scratch.
+func (c *counterRates) cpuPercent(pid int32, createTime int64, now time.Time, cpuSeconds float64) float64 {
+	key := fmt.Sprintf("%d/%d", pid, createTime)
+	return c.Rates(key, now, uint64(cpuSeconds*1e6))[0] / 1e6 * 100
+}
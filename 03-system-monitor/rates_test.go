@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCounterRates(t *testing.T) {
+	c := newCounterRates()
+	start := time.Now()
+
+	if got := c.Rates("eth0", start, 100); got[0] != 0 {
+		t.Errorf("first rate = %v, want 0", got[0])
+	}
+	if got := c.Rates("eth0", start.Add(2*time.Second), 300); got[0] != 100 {
+		t.Errorf("rate = %v, want 100", got[0])
+	}
+	if got := c.Rates("eth0", start.Add(3*time.Second), 50); got[0] != 0 {
+		t.Errorf("rate after a counter reset = %v, want 0", got[0])
+	}
+}
+
+func TestCounterRatesPrune(t *testing.T) {
+	c := newCounterRates()
+	start := time.Now()
+	c.Rates("net/eth0", start, 1)
+	c.Rates("net/veth1", start, 1)
+	c.Rates("net/lo", start, 1)
+
+	next := start.Add(time.Second)
+	c.Rates("net/eth0", next, 2)
+	c.Prune(next, func(key string) bool { return strings.HasSuffix(key, "/lo") })
+
+	for key, want := range map[string]bool{"net/eth0": true, "net/veth1": false, "net/lo": true} {
+		if _, ok := c.prev[key]; ok != want {
+			t.Errorf("%s kept = %v, want %v", key, ok, want)
+		}
+	}
+}
+
+func TestCounterRatesCPUPercent(t *testing.T) {
+	c := newCounterRates()
+	start := time.Now()
+
+	if got := c.cpuPercent(42, 1000, start, 10); got != 0 {
+		t.Errorf("first reading = %v, want 0", got)
+	}
+	// 1.5s of CPU time over 2s is 75%, regardless of the 10s used before.
+	if got := c.cpuPercent(42, 1000, start.Add(2*time.Second), 11.5); got != 75 {
+		t.Errorf("usage = %v, want 75", got)
+	}
+	// A new process with a reused PID starts from scratch.
+	if got := c.cpuPercent(42, 2000, start.Add(4*time.Second), 0.5); got != 0 {
+		t.Errorf("usage of a reused PID = %v, want 0", got)
+	}
+}
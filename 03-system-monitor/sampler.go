@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Sample is one collection of SystemStats along with its flattened metrics.
+type Sample struct {
+	Time   time.Time
+	Stats  *SystemStats
+	Values map[string]float64
+}
+
+// sampler collects SystemStats on a fixed interval, records them in the
+// history buffer and hands every sample to the registered handlers.
+type sampler struct {
+	interval time.Duration
+	history  *history
+
+	mu       sync.RWMutex
+	latest   *Sample
+	handlers []func(*Sample)
+}
+
+func newSampler(interval time.Duration, h *history) *sampler {
+	return &sampler{interval: interval, history: h}
+}
+
+// OnSample registers fn to be called after every successful sample.
+func (s *sampler) OnSample(fn func(*Sample)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers = append(s.handlers, fn)
+}
+
+// Latest returns the most recent sample, or nil before the first one.
+func (s *sampler) Latest() *Sample {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.latest
+}
+
+func (s *sampler) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	s.sample()
+	for range ticker.C {
+		s.sample()
+	}
+}
+
+func (s *sampler) sample() {
+	stats, err := getSystemStats()
+	if err != nil {
+		log.Printf("Warning: Sampler could not get system stats: %v", err)
+		return
+	}
+
+	sample := &Sample{Time: time.Now(), Stats: stats, Values: flattenStats(stats)}
+	s.history.Add(sample.Time, sample.Values)
+
+	s.mu.Lock()
+	s.latest = sample
+	handlers := append([]func(*Sample){}, s.handlers...)
+	s.mu.Unlock()
+
+	for _, fn := range handlers {
+		fn(sample)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ProcessNode is a process together with its descendants. The Tree* fields
+// aggregate the process and everything below it.
+type ProcessNode struct {
+	ProcessStats
+	TreeCPUPercent  float64        `json:"tree_cpu_percent"`
+	TreeMemoryUsage uint64         `json:"tree_memory_usage"`
+	TreeProcesses   int            `json:"tree_processes"`
+	Children        []*ProcessNode `json:"children,omitempty"`
+}
+
+// buildProcessTree links processes to their parents and returns the roots,
+// i.e. processes whose parent is not in the list. If rootPID is non-zero only
+// the subtree rooted at that PID is returned. maxDepth limits how many levels
+// below each root are kept; zero means unlimited.
+func buildProcessTree(processes []ProcessStats, rootPID int32, maxDepth int) ([]*ProcessNode, error) {
+	nodes := make(map[int32]*ProcessNode, len(processes))
+	for _, p := range processes {
+		nodes[p.PID] = &ProcessNode{ProcessStats: p}
+	}
+
+	var roots []*ProcessNode
+	for _, node := range nodes {
+		parent, ok := nodes[node.PPID]
+		if !ok || node.PPID == node.PID {
+			roots = append(roots, node)
+			continue
+		}
+		parent.Children = append(parent.Children, node)
+	}
+
+	if rootPID != 0 {
+		node, ok := nodes[rootPID]
+		if !ok {
+			return nil, fmt.Errorf("process %d not found", rootPID)
+		}
+		roots = []*ProcessNode{node}
+	}
+
+	sortProcessNodes(roots)
+	visited := make(map[int32]bool, len(nodes))
+	for _, root := range roots {
+		aggregateProcessNode(root, 0, maxDepth, visited)
+	}
+	return roots, nil
+}
+
+func sortProcessNodes(nodes []*ProcessNode) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].PID < nodes[j].PID })
+}
+
+// aggregateProcessNode fills in the Tree* totals bottom-up and trims children
+// deeper than maxDepth after they have been counted. PID reuse during the
+// scan can link processes into a PPID cycle, so a child that was already
+// visited is cut off instead of being descended into again.
+func aggregateProcessNode(node *ProcessNode, depth, maxDepth int, visited map[int32]bool) {
+	visited[node.PID] = true
+	node.TreeCPUPercent = node.CPUPercent
+	node.TreeMemoryUsage = node.MemoryUsage
+	node.TreeProcesses = 1
+
+	sortProcessNodes(node.Children)
+	children := node.Children[:0]
+	for _, child := range node.Children {
+		if visited[child.PID] {
+			continue
+		}
+		aggregateProcessNode(child, depth+1, maxDepth, visited)
+		node.TreeCPUPercent += child.TreeCPUPercent
+		node.TreeMemoryUsage += child.TreeMemoryUsage
+		node.TreeProcesses += child.TreeProcesses
+		children = append(children, child)
+	}
+	node.Children = children
+
+	if maxDepth > 0 && depth >= maxDepth {
+		node.Children = nil
+	}
+}
+
+// writeProcessTree renders the tree in a pstree-like plain-text format.
+func writeProcessTree(w io.Writer, roots []*ProcessNode) {
+	for _, root := range roots {
+		writeProcessNode(w, root, "", "")
+	}
+}
+
+func writeProcessNode(w io.Writer, node *ProcessNode, prefix, childPrefix string) {
+	fmt.Fprintf(w, "%s%s(%d) cpu=%.1f%% rss=%s", prefix, node.Name, node.PID,
+		node.TreeCPUPercent, formatBytes(node.TreeMemoryUsage))
+	if node.TreeProcesses > 1 {
+		fmt.Fprintf(w, " procs=%d", node.TreeProcesses)
+	}
+	fmt.Fprintln(w)
+
+	for i, child := range node.Children {
+		if i == len(node.Children)-1 {
+			writeProcessNode(w, child, childPrefix+"└─ ", childPrefix+"   ")
+		} else {
+			writeProcessNode(w, child, childPrefix+"├─ ", childPrefix+"│  ")
+		}
+	}
+}
+
+// formatBytes renders a byte count using binary units, e.g. "12.3MiB".
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func treeProcess(pid, ppid int32, name string, cpu float64, mem uint64) ProcessStats {
+	return ProcessStats{PID: pid, PPID: ppid, Name: name, CPUPercent: cpu, MemoryUsage: mem}
+}
+
+func TestBuildProcessTree(t *testing.T) {
+	processes := []ProcessStats{
+		treeProcess(1, 0, "init", 1, 1<<20),
+		treeProcess(4, 1, "sshd", 2, 2<<20),
+		treeProcess(2, 1, "bash", 3, 3<<20),
+		treeProcess(3, 2, "make", 4, 4<<20),
+		treeProcess(7, 99, "orphan", 5, 5<<20),
+	}
+
+	roots, err := buildProcessTree(processes, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 2 || roots[0].PID != 1 || roots[1].PID != 7 {
+		t.Fatalf("roots = %+v, want init and orphan", roots)
+	}
+	root := roots[0]
+	if root.TreeProcesses != 4 || root.TreeCPUPercent != 10 || root.TreeMemoryUsage != 10<<20 {
+		t.Errorf("init totals = %d procs, %.0f%%, %d bytes", root.TreeProcesses, root.TreeCPUPercent, root.TreeMemoryUsage)
+	}
+	if len(root.Children) != 2 || root.Children[0].PID != 2 || root.Children[1].PID != 4 {
+		t.Errorf("children of init are not sorted by PID: %+v", root.Children)
+	}
+
+	var out bytes.Buffer
+	writeProcessTree(&out, roots[:1])
+	want := "init(1) cpu=10.0% rss=10.0MiB procs=4\n" +
+		"├─ bash(2) cpu=7.0% rss=7.0MiB procs=2\n" +
+		"│  └─ make(3) cpu=4.0% rss=4.0MiB\n" +
+		"└─ sshd(4) cpu=2.0% rss=2.0MiB\n"
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestBuildProcessTreeSubtreeAndDepth(t *testing.T) {
+	processes := []ProcessStats{
+		treeProcess(1, 0, "init", 1, 0),
+		treeProcess(2, 1, "bash", 1, 0),
+		treeProcess(3, 2, "make", 1, 0),
+		treeProcess(5, 3, "cc", 1, 0),
+	}
+
+	roots, err := buildProcessTree(processes, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 1 || roots[0].PID != 2 {
+		t.Fatalf("roots = %+v, want bash", roots)
+	}
+	// Trimmed levels still count towards the totals.
+	if roots[0].TreeProcesses != 3 {
+		t.Errorf("TreeProcesses = %d, want 3", roots[0].TreeProcesses)
+	}
+	if child := roots[0].Children[0]; child.PID != 3 || child.Children != nil || child.TreeProcesses != 2 {
+		t.Errorf("depth 1 node = %+v, want make with its child trimmed", child)
+	}
+
+	if _, err := buildProcessTree(processes, 42, 0); err == nil {
+		t.Error("expected an error for a missing PID")
+	}
+}
+
+func TestBuildProcessTreeCycle(t *testing.T) {
+	// PID reuse during the scan can make two processes each other's parent.
+	processes := []ProcessStats{
+		treeProcess(10, 11, "a", 1, 0),
+		treeProcess(11, 10, "b", 1, 0),
+		treeProcess(12, 11, "c", 1, 0),
+		treeProcess(13, 13, "self", 1, 0),
+	}
+
+	roots, err := buildProcessTree(processes, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roots[0].TreeProcesses != 3 {
+		t.Errorf("TreeProcesses = %d, want 3", roots[0].TreeProcesses)
+	}
+	var out bytes.Buffer
+	writeProcessTree(&out, roots)
+	want := "a(10) cpu=3.0% rss=0B procs=3\n" +
+		"└─ b(11) cpu=2.0% rss=0B procs=2\n" +
+		"   └─ c(12) cpu=1.0% rss=0B\n"
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+
+	roots, err = buildProcessTree(processes, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 1 || roots[0].PID != 13 {
+		t.Errorf("roots = %+v, want only the self-parented process", roots)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := map[uint64]string{0: "0B", 1023: "1023B", 1024: "1.0KiB", 1536: "1.5KiB", 5 << 30: "5.0GiB"}
+	for b, want := range tests {
+		if got := formatBytes(b); got != want {
+			t.Errorf("formatBytes(%d) = %q, want %q", b, got, want)
+		}
+	}
+}
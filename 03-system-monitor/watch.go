@@ -0,0 +1,563 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// Watch event types reported by /watches and sent to watch webhooks.
+const (
+	WatchMissing        = "missing"
+	WatchStarted        = "started"
+	WatchRestarted      = "restarted"
+	WatchCPUHigh        = "cpu_high"
+	WatchCPUOK          = "cpu_ok"
+	WatchRSSHigh        = "rss_high"
+	WatchRSSOK          = "rss_ok"
+	WatchRestartAttempt = "restart_attempt"
+	WatchRestartFailed  = "restart_failed"
+)
+
+const (
+	defaultWatchInterval = 10 * time.Second
+	defaultMaxRestarts   = 3
+	restartTimeout       = time.Minute
+	minRestartInterval   = 30 * time.Second
+	maxWatchEvents       = 50
+)
+
+// WatchRule selects processes by name, command line or pidfile. Every
+// selector that is set must match.
+type WatchRule struct {
+	Name    string `json:"name"`
+	Process string `json:"process,omitempty"`
+	Cmdline string `json:"cmdline,omitempty"`
+	Pidfile string `json:"pidfile,omitempty"`
+
+	// Thresholds apply to the total of all matching processes and have to
+	// be exceeded for For before they are reported.
+	MaxCPUPercent float64 `json:"max_cpu_percent,omitempty"`
+	MaxRSSBytes   uint64  `json:"max_rss_bytes,omitempty"`
+	For           string  `json:"for,omitempty"`
+
+	// RestartCommand is run once the processes have been missing for
+	// RestartAfter, then retried at most MaxRestarts times in total until
+	// they come back.
+	RestartCommand []string `json:"restart_command,omitempty"`
+	RestartAfter   string   `json:"restart_after,omitempty"`
+	MaxRestarts    int      `json:"max_restarts,omitempty"`
+
+	cmdline      *regexp.Regexp
+	duration     time.Duration
+	restartAfter time.Duration
+}
+
+// WatchFile is the on-disk format loaded from WATCH_RULES_FILE.
+type WatchFile struct {
+	Webhooks []string    `json:"webhooks"`
+	Rules    []WatchRule `json:"rules"`
+}
+
+type WatchEvent struct {
+	Time    string `json:"time"`
+	Rule    string `json:"rule"`
+	Type    string `json:"type"`
+	PID     int32  `json:"pid,omitempty"`
+	Message string `json:"message"`
+}
+
+// WatchStatus is the current state of one rule.
+type WatchStatus struct {
+	Rule            string       `json:"rule"`
+	Running         bool         `json:"running"`
+	PIDs            []int32      `json:"pids,omitempty"`
+	CPUPercent      float64      `json:"cpu_percent"`
+	MemoryUsage     uint64       `json:"memory_usage"`
+	StartedAt       string       `json:"started_at,omitempty"`
+	MissingSince    string       `json:"missing_since,omitempty"`
+	Restarts        int          `json:"restarts"`
+	RestartAttempts int          `json:"restart_attempts"`
+	Violations      []string     `json:"violations,omitempty"`
+	Events          []WatchEvent `json:"events,omitempty"`
+
+	seen         bool
+	mainPID      int32
+	createTime   int64
+	missingSince time.Time
+	cpuSince     time.Time
+	rssSince     time.Time
+	cpuHigh      bool
+	rssHigh      bool
+	restarting   bool
+	lastRestart  time.Time
+}
+
+// parse validates the rule and fills in its parsed fields.
+func (r *WatchRule) parse() error {
+	if r.Name == "" {
+		return fmt.Errorf("watch rule name is required")
+	}
+	if r.Process == "" && r.Cmdline == "" && r.Pidfile == "" {
+		return fmt.Errorf("watch rule %s: one of process, cmdline or pidfile is required", r.Name)
+	}
+	var err error
+	if r.Cmdline != "" {
+		if r.cmdline, err = regexp.Compile(r.Cmdline); err != nil {
+			return fmt.Errorf("watch rule %s: invalid cmdline pattern: %v", r.Name, err)
+		}
+	}
+	if r.For != "" {
+		if r.duration, err = time.ParseDuration(r.For); err != nil || r.duration < 0 {
+			return fmt.Errorf("watch rule %s: invalid duration %q", r.Name, r.For)
+		}
+	}
+	if r.RestartAfter != "" {
+		if r.restartAfter, err = time.ParseDuration(r.RestartAfter); err != nil || r.restartAfter < 0 {
+			return fmt.Errorf("watch rule %s: invalid restart_after %q", r.Name, r.RestartAfter)
+		}
+	}
+	if r.MaxRestarts < 0 {
+		return fmt.Errorf("watch rule %s: max_restarts must not be negative", r.Name)
+	}
+	if r.MaxRestarts == 0 {
+		r.MaxRestarts = defaultMaxRestarts
+	}
+	return nil
+}
+
+// loadWatchRules reads and validates a watch rule file.
+func loadWatchRules(path string) (*WatchFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading watch rules: %v", err)
+	}
+	var file WatchFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing watch rules: %v", err)
+	}
+
+	names := make(map[string]bool)
+	for i := range file.Rules {
+		if err := file.Rules[i].parse(); err != nil {
+			return nil, err
+		}
+		if names[file.Rules[i].Name] {
+			return nil, fmt.Errorf("duplicate watch rule name %s", file.Rules[i].Name)
+		}
+		names[file.Rules[i].Name] = true
+	}
+	for _, hook := range file.Webhooks {
+		if err := validateWebhook(hook); err != nil {
+			return nil, err
+		}
+	}
+	return &file, nil
+}
+
+// watchedProcess is what the watcher reads about a candidate process.
+type watchedProcess struct {
+	PID        int32
+	Name       string
+	Cmdline    string
+	CreateTime int64
+	RSS        uint64
+	CPUSeconds float64
+}
+
+// watcher checks the watch rules on an interval.
+type watcher struct {
+	rules    []WatchRule
+	webhooks []string
+	interval time.Duration
+	client   *http.Client
+	rates    *counterRates
+
+	// runCommand starts a restart command; replaced in tests.
+	runCommand func(ctx context.Context, args []string) ([]byte, error)
+
+	mu       sync.RWMutex
+	statuses map[string]*WatchStatus
+}
+
+var watches *watcher
+
+// loadWatcher reads WATCH_RULES_FILE and WATCH_INTERVAL. It returns nil if
+// no rule file is configured.
+func loadWatcher() (*watcher, error) {
+	path := os.Getenv("WATCH_RULES_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	file, err := loadWatchRules(path)
+	if err != nil {
+		return nil, err
+	}
+	interval, err := envDuration("WATCH_INTERVAL", defaultWatchInterval)
+	if err != nil {
+		return nil, err
+	}
+	return newWatcher(file.Rules, file.Webhooks, interval), nil
+}
+
+func newWatcher(rules []WatchRule, webhooks []string, interval time.Duration) *watcher {
+	w := &watcher{
+		rules:    rules,
+		webhooks: webhooks,
+		interval: interval,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		rates:    newCounterRates(),
+		statuses: make(map[string]*WatchStatus),
+		runCommand: func(ctx context.Context, args []string) ([]byte, error) {
+			return exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+		},
+	}
+	for _, rule := range rules {
+		w.statuses[rule.Name] = &WatchStatus{Rule: rule.Name}
+	}
+	return w
+}
+
+func (w *watcher) run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for now := time.Now(); ; now = <-ticker.C {
+		procs, err := w.scan()
+		if err != nil {
+			// Without a process list every rule would look missing.
+			log.Printf("Warning: Could not list processes for watch rules: %v", err)
+			continue
+		}
+		w.check(now, procs)
+	}
+}
+
+// scan lists the processes matching at least one rule. Command lines are
+// only read when a rule needs them, and usage only for matching processes.
+func (w *watcher) scan() ([]watchedProcess, error) {
+	procs, err := process.Processes()
+	if err != nil {
+		return nil, err
+	}
+	needCmdline := false
+	pidfiles := make(map[int32]bool)
+	for i := range w.rules {
+		if w.rules[i].cmdline != nil {
+			needCmdline = true
+		}
+		if pid := readPidfile(w.rules[i].Pidfile); pid > 0 {
+			pidfiles[pid] = true
+		}
+	}
+
+	var matched []watchedProcess
+	for _, p := range procs {
+		wp := watchedProcess{PID: p.Pid}
+		if wp.Name, err = p.Name(); err != nil {
+			continue
+		}
+		if needCmdline {
+			wp.Cmdline, _ = p.Cmdline()
+		}
+		if !w.matchesAny(wp, pidfiles) {
+			continue
+		}
+		if wp.CreateTime, err = p.CreateTime(); err != nil {
+			continue
+		}
+		if mem, err := p.MemoryInfo(); err == nil {
+			wp.RSS = mem.RSS
+		}
+		if times, err := p.Times(); err == nil {
+			wp.CPUSeconds = times.User + times.System
+		}
+		matched = append(matched, wp)
+	}
+	return matched, nil
+}
+
+func (w *watcher) matchesAny(p watchedProcess, pidfiles map[int32]bool) bool {
+	for i := range w.rules {
+		rule := &w.rules[i]
+		if rule.Pidfile != "" && !pidfiles[p.PID] {
+			continue
+		}
+		if rule.matches(p, 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether p is selected by the rule. pidfilePID is the PID
+// read from the rule's pidfile, or 0 to skip that check.
+func (r *WatchRule) matches(p watchedProcess, pidfilePID int32) bool {
+	if r.Process != "" && p.Name != r.Process {
+		return false
+	}
+	if r.cmdline != nil && !r.cmdline.MatchString(p.Cmdline) {
+		return false
+	}
+	if pidfilePID != 0 && p.PID != pidfilePID {
+		return false
+	}
+	return true
+}
+
+// retryInterval is how long to wait after a restart attempt before the next
+// one, long enough for a started service to show up in the process list.
+func (r *WatchRule) retryInterval() time.Duration {
+	if r.restartAfter > minRestartInterval {
+		return r.restartAfter
+	}
+	return minRestartInterval
+}
+
+// readPidfile returns the PID in path, or 0 if it is unset, missing or
+// invalid.
+func readPidfile(path string) int32 {
+	if path == "" {
+		return 0
+	}
+	pid, err := strconv.ParseInt(readString(path), 10, 32)
+	if err != nil || pid <= 0 {
+		return 0
+	}
+	return int32(pid)
+}
+
+// check updates every rule from the processes found by scan and reports
+// what changed.
+func (w *watcher) check(now time.Time, procs []watchedProcess) {
+	var events []WatchEvent
+	var restarts []*WatchRule
+
+	// CPU usage is the rate of CPU time since the previous check.
+	cpu := make(map[int32]float64, len(procs))
+	for _, p := range procs {
+		cpu[p.PID] = w.rates.cpuPercent(p.PID, p.CreateTime, now, p.CPUSeconds)
+	}
+	w.rates.Prune(now, nil)
+
+	w.mu.Lock()
+	for i := range w.rules {
+		rule := &w.rules[i]
+		s := w.statuses[rule.Name]
+		event := func(kind string, pid int32, format string, args ...interface{}) {
+			e := WatchEvent{
+				Time:    now.UTC().Format(time.RFC3339),
+				Rule:    rule.Name,
+				Type:    kind,
+				PID:     pid,
+				Message: fmt.Sprintf(format, args...),
+			}
+			s.Events = append(s.Events, e)
+			if len(s.Events) > maxWatchEvents {
+				s.Events = s.Events[len(s.Events)-maxWatchEvents:]
+			}
+			events = append(events, e)
+		}
+
+		var matched []watchedProcess
+		if pidfilePID := readPidfile(rule.Pidfile); rule.Pidfile == "" || pidfilePID != 0 {
+			for _, p := range procs {
+				if rule.matches(p, pidfilePID) {
+					matched = append(matched, p)
+				}
+			}
+		}
+
+		if len(matched) == 0 {
+			if s.Running || !s.seen {
+				event(WatchMissing, s.mainPID, "no process matches %s", rule.Name)
+				s.missingSince = now
+				s.MissingSince = now.UTC().Format(time.RFC3339)
+			}
+			s.seen = true
+			s.Running, s.PIDs, s.CPUPercent, s.MemoryUsage, s.Violations = false, nil, 0, 0, nil
+			s.cpuHigh, s.rssHigh = false, false
+			s.cpuSince, s.rssSince = time.Time{}, time.Time{}
+
+			if len(rule.RestartCommand) > 0 && !s.restarting && s.RestartAttempts < rule.MaxRestarts &&
+				now.Sub(s.missingSince) >= rule.restartAfter && now.Sub(s.lastRestart) >= rule.retryInterval() {
+				s.RestartAttempts++
+				s.restarting = true
+				s.lastRestart = now
+				event(WatchRestartAttempt, 0, "running %s (attempt %d of %d)", strings.Join(rule.RestartCommand, " "), s.RestartAttempts, rule.MaxRestarts)
+				restarts = append(restarts, rule)
+				if s.RestartAttempts == rule.MaxRestarts {
+					log.Printf("Warning: Watch %s: giving up restarts until the process is back", rule.Name)
+				}
+			}
+			continue
+		}
+
+		// The oldest matching process identifies the service; a new one
+		// means it was restarted.
+		sort.Slice(matched, func(i, j int) bool {
+			if matched[i].CreateTime != matched[j].CreateTime {
+				return matched[i].CreateTime < matched[j].CreateTime
+			}
+			return matched[i].PID < matched[j].PID
+		})
+		leader := matched[0]
+		switch {
+		case !s.Running:
+			event(WatchStarted, leader.PID, "%s is running as PID %d", rule.Name, leader.PID)
+			s.RestartAttempts = 0
+		case leader.PID != s.mainPID || leader.CreateTime != s.createTime:
+			s.Restarts++
+			event(WatchRestarted, leader.PID, "%s restarted: PID %d is now %d", rule.Name, s.mainPID, leader.PID)
+		}
+		s.seen, s.Running = true, true
+		s.mainPID, s.createTime = leader.PID, leader.CreateTime
+		s.StartedAt = time.UnixMilli(leader.CreateTime).UTC().Format(time.RFC3339)
+		s.missingSince, s.MissingSince = time.Time{}, ""
+
+		s.PIDs, s.CPUPercent, s.MemoryUsage = nil, 0, 0
+		for _, p := range matched {
+			s.PIDs = append(s.PIDs, p.PID)
+			s.CPUPercent += cpu[p.PID]
+			s.MemoryUsage += p.RSS
+		}
+		sort.Slice(s.PIDs, func(i, j int) bool { return s.PIDs[i] < s.PIDs[j] })
+
+		threshold := func(exceeded bool, since *time.Time, high *bool, highKind, okKind, what string) {
+			switch {
+			case exceeded && since.IsZero():
+				*since = now
+			case !exceeded:
+				*since = time.Time{}
+			}
+			if exceeded && !*high && now.Sub(*since) >= rule.duration {
+				*high = true
+				event(highKind, leader.PID, "%s %s is above its limit", rule.Name, what)
+			} else if !exceeded && *high {
+				*high = false
+				event(okKind, leader.PID, "%s %s is back below its limit", rule.Name, what)
+			}
+		}
+		threshold(rule.MaxCPUPercent > 0 && s.CPUPercent > rule.MaxCPUPercent, &s.cpuSince, &s.cpuHigh, WatchCPUHigh, WatchCPUOK,
+			fmt.Sprintf("CPU usage (%.1f%%)", s.CPUPercent))
+		threshold(rule.MaxRSSBytes > 0 && s.MemoryUsage > rule.MaxRSSBytes, &s.rssSince, &s.rssHigh, WatchRSSHigh, WatchRSSOK,
+			fmt.Sprintf("RSS (%d bytes)", s.MemoryUsage))
+		s.Violations = nil
+		if s.cpuHigh {
+			s.Violations = append(s.Violations, "cpu")
+		}
+		if s.rssHigh {
+			s.Violations = append(s.Violations, "rss")
+		}
+	}
+	w.mu.Unlock()
+
+	for _, rule := range restarts {
+		go w.restart(rule)
+	}
+	for _, e := range events {
+		log.Printf("Watch %s: %s: %s", e.Rule, e.Type, e.Message)
+	}
+	if len(events) > 0 && len(w.webhooks) > 0 {
+		go w.notify(events)
+	}
+}
+
+// restart runs the rule's restart command. Whether it worked shows up as a
+// started event on a later check.
+func (w *watcher) restart(rule *WatchRule) {
+	ctx, cancel := context.WithTimeout(context.Background(), restartTimeout)
+	defer cancel()
+	output, err := w.runCommand(ctx, rule.RestartCommand)
+
+	w.mu.Lock()
+	s := w.statuses[rule.Name]
+	s.restarting = false
+	if err == nil {
+		w.mu.Unlock()
+		return
+	}
+	message := err.Error()
+	if out := strings.TrimSpace(string(output)); out != "" {
+		if len(out) > 512 {
+			out = out[len(out)-512:]
+		}
+		message += ": " + out
+	}
+	e := WatchEvent{
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		Rule:    rule.Name,
+		Type:    WatchRestartFailed,
+		Message: message,
+	}
+	s.Events = append(s.Events, e)
+	if len(s.Events) > maxWatchEvents {
+		s.Events = s.Events[len(s.Events)-maxWatchEvents:]
+	}
+	w.mu.Unlock()
+
+	log.Printf("Watch %s: %s: %s", e.Rule, e.Type, e.Message)
+	if len(w.webhooks) > 0 {
+		w.notify([]WatchEvent{e})
+	}
+}
+
+// Statuses returns the state of every rule, sorted by name.
+func (w *watcher) Statuses() []WatchStatus {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	statuses := make([]WatchStatus, 0, len(w.statuses))
+	for _, s := range w.statuses {
+		status := *s
+		status.Events = append([]WatchEvent(nil), s.Events...)
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Rule < statuses[j].Rule })
+	return statuses
+}
+
+// notify posts watch events to every configured webhook.
+func (w *watcher) notify(events []WatchEvent) {
+	body, err := json.Marshal(map[string]interface{}{
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"events":    events,
+	})
+	if err != nil {
+		log.Printf("Error encoding watch notification: %v", err)
+		return
+	}
+
+	for _, hook := range w.webhooks {
+		resp, err := w.client.Post(hook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Warning: Could not notify webhook %s: %v", hook, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("Warning: Webhook %s returned %s", hook, resp.Status)
+		}
+	}
+}
+
+func handleWatches(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(watches.Statuses()); err != nil {
+		log.Printf("Error encoding response: %v", err)
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+}
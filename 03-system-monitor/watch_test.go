@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestWatcher(t *testing.T, rules ...WatchRule) *watcher {
+	t.Helper()
+	for i := range rules {
+		if err := rules[i].parse(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return newWatcher(rules, nil, time.Second)
+}
+
+func watchEventTypes(s WatchStatus) []string {
+	var types []string
+	for _, e := range s.Events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestWatchRuleValidation(t *testing.T) {
+	tests := []WatchRule{
+		{},
+		{Name: "no-selector"},
+		{Name: "bad-regexp", Cmdline: "("},
+		{Name: "bad-for", Process: "nginx", For: "soon"},
+		{Name: "bad-restart", Process: "nginx", RestartAfter: "-1s"},
+	}
+	for _, rule := range tests {
+		if err := rule.parse(); err == nil {
+			t.Errorf("rule %+v: expected an error", rule)
+		}
+	}
+}
+
+func TestWatchMissingStartedRestarted(t *testing.T) {
+	w := newTestWatcher(t, WatchRule{Name: "web", Process: "nginx"})
+	start := time.Now()
+	nginx := watchedProcess{PID: 100, Name: "nginx", CreateTime: 1000}
+	worker := watchedProcess{PID: 101, Name: "nginx", CreateTime: 1001, RSS: 10}
+
+	w.check(start, nil)
+	w.check(start.Add(time.Second), []watchedProcess{nginx, worker})
+	w.check(start.Add(2*time.Second), []watchedProcess{worker, nginx})
+	restarted := watchedProcess{PID: 200, Name: "nginx", CreateTime: 5000}
+	w.check(start.Add(3*time.Second), []watchedProcess{restarted})
+	w.check(start.Add(4*time.Second), []watchedProcess{{PID: 300, Name: "bash"}})
+
+	s := w.Statuses()[0]
+	want := []string{WatchMissing, WatchStarted, WatchRestarted, WatchMissing}
+	if got := watchEventTypes(s); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+	if s.Running || s.Restarts != 1 || s.MissingSince == "" {
+		t.Errorf("status = %+v", s)
+	}
+}
+
+func TestWatchCmdlineAndPidfile(t *testing.T) {
+	pidfile := filepath.Join(t.TempDir(), "app.pid")
+	if err := os.WriteFile(pidfile, []byte("42\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w := newTestWatcher(t,
+		WatchRule{Name: "worker", Cmdline: `worker\.py --queue=high`},
+		WatchRule{Name: "app", Pidfile: pidfile},
+	)
+	procs := []watchedProcess{
+		{PID: 10, Name: "python3", Cmdline: "python3 worker.py --queue=low"},
+		{PID: 11, Name: "python3", Cmdline: "python3 worker.py --queue=high"},
+		{PID: 42, Name: "app"},
+		{PID: 43, Name: "app"},
+	}
+	w.check(time.Now(), procs)
+
+	for _, s := range w.Statuses() {
+		want := map[string]int32{"worker": 11, "app": 42}[s.Rule]
+		if !s.Running || len(s.PIDs) != 1 || s.PIDs[0] != want {
+			t.Errorf("%s: running = %v, pids = %v, want [%d]", s.Rule, s.Running, s.PIDs, want)
+		}
+	}
+}
+
+func TestWatchThresholds(t *testing.T) {
+	w := newTestWatcher(t, WatchRule{Name: "db", Process: "postgres", MaxCPUPercent: 50, MaxRSSBytes: 1000, For: "2s"})
+	start := time.Now()
+	proc := func(cpuSeconds float64, rss uint64) []watchedProcess {
+		return []watchedProcess{{PID: 5, Name: "postgres", CreateTime: 1, CPUSeconds: cpuSeconds, RSS: rss}}
+	}
+
+	w.check(start, proc(0, 2000))
+	w.check(start.Add(time.Second), proc(0.9, 2000))   // 90% CPU, RSS high for 1s
+	w.check(start.Add(2*time.Second), proc(1.8, 2000)) // RSS high for 2s
+	if s := w.Statuses()[0]; strings.Join(s.Violations, ",") != "rss" {
+		t.Fatalf("violations after 2s = %v, want [rss]", s.Violations)
+	}
+	w.check(start.Add(3*time.Second), proc(2.7, 500)) // CPU high for 2s, RSS ok
+	s := w.Statuses()[0]
+	if strings.Join(s.Violations, ",") != "cpu" {
+		t.Errorf("violations = %v, want [cpu]", s.Violations)
+	}
+	want := []string{WatchStarted, WatchRSSHigh, WatchCPUHigh, WatchRSSOK}
+	if got := watchEventTypes(s); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestWatchRestartCommand(t *testing.T) {
+	w := newTestWatcher(t, WatchRule{
+		Name:           "cron",
+		Process:        "crond",
+		RestartCommand: []string{"systemctl", "restart", "crond"},
+		RestartAfter:   "5s",
+		MaxRestarts:    2,
+	})
+	var mu sync.Mutex
+	var runs [][]string
+	w.runCommand = func(ctx context.Context, args []string) ([]byte, error) {
+		mu.Lock()
+		runs = append(runs, args)
+		mu.Unlock()
+		return []byte("unit not found"), errors.New("exit status 5")
+	}
+	countFailures := func() int {
+		n := 0
+		for _, e := range w.Statuses()[0].Events {
+			if e.Type == WatchRestartFailed {
+				n++
+			}
+		}
+		return n
+	}
+	waitForFailures := func(n int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for countFailures() < n && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	start := time.Now()
+	crond := []watchedProcess{{PID: 7, Name: "crond", CreateTime: 1}}
+	w.check(start, crond)
+	w.check(start.Add(time.Second), nil)
+	w.check(start.Add(3*time.Second), nil) // missing for 2s: too early
+	for i := 1; i <= 4; i++ {
+		w.check(start.Add(time.Duration(6+30*(i-1))*time.Second), nil)
+		w.check(start.Add(time.Duration(7+30*(i-1))*time.Second), nil) // too soon to retry
+		if i <= 2 {
+			waitForFailures(i)
+		}
+	}
+
+	mu.Lock()
+	if len(runs) != 2 || strings.Join(runs[0], " ") != "systemctl restart crond" {
+		t.Errorf("runs = %v, want two restarts", runs)
+	}
+	mu.Unlock()
+	s := w.Statuses()[0]
+	if failures := countFailures(); failures != 2 || s.RestartAttempts != 2 {
+		t.Errorf("failures = %d, attempts = %d", failures, s.RestartAttempts)
+	}
+	if last := s.Events[len(s.Events)-1]; !strings.Contains(last.Message, "unit not found") {
+		t.Errorf("message = %q, want the command output", last.Message)
+	}
+
+	// Coming back resets the attempts.
+	w.check(start.Add(200*time.Second), crond)
+	if s := w.Statuses()[0]; !s.Running || s.RestartAttempts != 0 {
+		t.Errorf("status after recovery = %+v", s)
+	}
+}
@@ -0,0 +1,551 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+	"github.com/docker/go-units"
+	"github.com/gin-gonic/gin"
+)
+
+// ContainerSpec is the request body of POST /api/v1/containers.
+type ContainerSpec struct {
+	Image       string            `json:"image"`
+	Name        string            `json:"name"`
+	Command     []string          `json:"command"`
+	Entrypoint  []string          `json:"entrypoint"`
+	Env         map[string]string `json:"env"`
+	Labels      map[string]string `json:"labels"`
+	WorkingDir  string            `json:"working_dir"`
+	User        string            `json:"user"`
+	Ports       []PortSpec        `json:"ports"`
+	Mounts      []MountSpec       `json:"mounts"`
+	Network     string            `json:"network"`
+	Aliases     []string          `json:"network_aliases"`
+	Restart     *RestartSpec      `json:"restart_policy"`
+	Resources   *ResourceSpec     `json:"resources"`
+	Healthcheck *HealthcheckSpec  `json:"healthcheck"`
+	// Pull pulls the image first if it is not present locally.
+	Pull bool `json:"pull"`
+}
+
+// PortSpec publishes a container port, e.g. {"container_port": 80, "host_port": 8080}.
+// A zero host port lets Docker pick a free one.
+type PortSpec struct {
+	ContainerPort int    `json:"container_port"`
+	Protocol      string `json:"protocol"`
+	HostIP        string `json:"host_ip"`
+	HostPort      int    `json:"host_port"`
+}
+
+// MountSpec is a bind mount, named volume or tmpfs.
+type MountSpec struct {
+	Type     string `json:"type"`
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"read_only"`
+}
+
+type RestartSpec struct {
+	Name       string `json:"name"`
+	MaxRetries int    `json:"max_retries"`
+}
+
+// ResourceSpec limits the container. Memory sizes accept units such as
+// "512m" or "2g".
+type ResourceSpec struct {
+	CPUs       float64 `json:"cpus"`
+	CPUShares  int64   `json:"cpu_shares"`
+	Memory     string  `json:"memory"`
+	MemorySwap string  `json:"memory_swap"`
+	PidsLimit  int64   `json:"pids_limit"`
+}
+
+// HealthcheckSpec mirrors the Dockerfile HEALTHCHECK instruction, e.g.
+// {"test": ["CMD-SHELL", "curl -f http://localhost/"], "interval": "30s"}.
+type HealthcheckSpec struct {
+	Test        []string `json:"test"`
+	Interval    string   `json:"interval"`
+	Timeout     string   `json:"timeout"`
+	StartPeriod string   `json:"start_period"`
+	Retries     int      `json:"retries"`
+}
+
+// containerNamePattern is the name format accepted by the Docker daemon.
+var containerNamePattern = regexp.MustCompile(`^/?[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+var restartPolicies = map[string]bool{"no": true, "always": true, "unless-stopped": true, "on-failure": true}
+
+// build validates the spec and converts it into the Docker API's
+// configuration structs.
+func (s *ContainerSpec) build() (*container.Config, *container.HostConfig, *network.NetworkingConfig, error) {
+	if strings.TrimSpace(s.Image) == "" || strings.ContainsAny(s.Image, " \t\n") {
+		return nil, nil, nil, fmt.Errorf("image is required and must not contain whitespace")
+	}
+	if s.Name != "" && !containerNamePattern.MatchString(s.Name) {
+		return nil, nil, nil, fmt.Errorf("invalid container name %q, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", s.Name)
+	}
+
+	config := &container.Config{
+		Image:      s.Image,
+		Cmd:        s.Command,
+		Entrypoint: s.Entrypoint,
+		Labels:     s.Labels,
+		WorkingDir: s.WorkingDir,
+		User:       s.User,
+	}
+	if s.WorkingDir != "" && !path.IsAbs(s.WorkingDir) {
+		return nil, nil, nil, fmt.Errorf("working_dir must be an absolute path")
+	}
+	env, err := envList(s.Env)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	config.Env = env
+
+	hostConfig := &container.HostConfig{}
+	if len(s.Ports) > 0 {
+		config.ExposedPorts = nat.PortSet{}
+		hostConfig.PortBindings = nat.PortMap{}
+	}
+	for _, p := range s.Ports {
+		protocol := p.Protocol
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		if protocol != "tcp" && protocol != "udp" && protocol != "sctp" {
+			return nil, nil, nil, fmt.Errorf("invalid protocol %q for port %d", p.Protocol, p.ContainerPort)
+		}
+		if p.ContainerPort < 1 || p.ContainerPort > 65535 || p.HostPort < 0 || p.HostPort > 65535 {
+			return nil, nil, nil, fmt.Errorf("invalid port mapping %d:%d", p.HostPort, p.ContainerPort)
+		}
+		port, err := nat.NewPort(protocol, fmt.Sprint(p.ContainerPort))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		binding := nat.PortBinding{HostIP: p.HostIP}
+		if p.HostPort > 0 {
+			binding.HostPort = fmt.Sprint(p.HostPort)
+		}
+		config.ExposedPorts[port] = struct{}{}
+		hostConfig.PortBindings[port] = append(hostConfig.PortBindings[port], binding)
+	}
+
+	for _, m := range s.Mounts {
+		if !path.IsAbs(m.Target) {
+			return nil, nil, nil, fmt.Errorf("mount target %q must be an absolute path", m.Target)
+		}
+		switch mount.Type(m.Type) {
+		case mount.TypeBind:
+			if !path.IsAbs(m.Source) {
+				return nil, nil, nil, fmt.Errorf("bind mount source %q must be an absolute path", m.Source)
+			}
+		case mount.TypeVolume:
+		case mount.TypeTmpfs:
+			if m.Source != "" {
+				return nil, nil, nil, fmt.Errorf("tmpfs mount %s must not have a source", m.Target)
+			}
+		default:
+			return nil, nil, nil, fmt.Errorf("invalid mount type %q, expected bind, volume or tmpfs", m.Type)
+		}
+		hostConfig.Mounts = append(hostConfig.Mounts, mount.Mount{
+			Type:     mount.Type(m.Type),
+			Source:   m.Source,
+			Target:   m.Target,
+			ReadOnly: m.ReadOnly,
+		})
+	}
+
+	if r := s.Restart; r != nil {
+		if !restartPolicies[r.Name] {
+			return nil, nil, nil, fmt.Errorf("invalid restart policy %q, expected no, always, unless-stopped or on-failure", r.Name)
+		}
+		if r.MaxRetries < 0 || (r.MaxRetries > 0 && r.Name != "on-failure") {
+			return nil, nil, nil, fmt.Errorf("max_retries is only valid with the on-failure restart policy")
+		}
+		hostConfig.RestartPolicy = container.RestartPolicy{Name: r.Name, MaximumRetryCount: r.MaxRetries}
+	}
+
+	if r := s.Resources; r != nil {
+		if r.CPUs < 0 || r.CPUShares < 0 || r.PidsLimit < 0 {
+			return nil, nil, nil, fmt.Errorf("resource limits must not be negative")
+		}
+		hostConfig.NanoCPUs = int64(r.CPUs * 1e9)
+		hostConfig.CPUShares = r.CPUShares
+		if r.Memory != "" {
+			memory, err := units.RAMInBytes(r.Memory)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("invalid memory limit: %v", err)
+			}
+			hostConfig.Memory = memory
+		}
+		if r.MemorySwap == "-1" {
+			hostConfig.MemorySwap = -1
+		} else if r.MemorySwap != "" {
+			swap, err := units.RAMInBytes(r.MemorySwap)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("invalid memory_swap limit: %v", err)
+			}
+			if hostConfig.Memory == 0 || swap < hostConfig.Memory {
+				return nil, nil, nil, fmt.Errorf("memory_swap requires memory and must be at least as large")
+			}
+			hostConfig.MemorySwap = swap
+		}
+		if r.PidsLimit > 0 {
+			hostConfig.PidsLimit = &r.PidsLimit
+		}
+	}
+
+	if h := s.Healthcheck; h != nil {
+		if len(h.Test) == 0 {
+			return nil, nil, nil, fmt.Errorf("healthcheck test is required")
+		}
+		switch h.Test[0] {
+		case "NONE":
+		case "CMD", "CMD-SHELL":
+			if len(h.Test) < 2 {
+				return nil, nil, nil, fmt.Errorf("healthcheck %s needs a command", h.Test[0])
+			}
+		default:
+			return nil, nil, nil, fmt.Errorf("healthcheck test must start with NONE, CMD or CMD-SHELL")
+		}
+		health := &container.HealthConfig{Test: h.Test, Retries: h.Retries}
+		for _, d := range []struct {
+			name  string
+			value string
+			dst   *time.Duration
+		}{
+			{"interval", h.Interval, &health.Interval},
+			{"timeout", h.Timeout, &health.Timeout},
+			{"start_period", h.StartPeriod, &health.StartPeriod},
+		} {
+			if d.value == "" {
+				continue
+			}
+			v, err := time.ParseDuration(d.value)
+			if err != nil || v < time.Millisecond {
+				return nil, nil, nil, fmt.Errorf("invalid healthcheck %s %q", d.name, d.value)
+			}
+			*d.dst = v
+		}
+		if h.Retries < 0 {
+			return nil, nil, nil, fmt.Errorf("healthcheck retries must not be negative")
+		}
+		config.Healthcheck = health
+	}
+
+	var networkingConfig *network.NetworkingConfig
+	if s.Network != "" {
+		hostConfig.NetworkMode = container.NetworkMode(s.Network)
+		networkingConfig = &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				s.Network: {Aliases: s.Aliases},
+			},
+		}
+	} else if len(s.Aliases) > 0 {
+		return nil, nil, nil, fmt.Errorf("network_aliases require a network")
+	}
+	return config, hostConfig, networkingConfig, nil
+}
+
+// envList converts environment variables to Docker's sorted KEY=value form.
+func envList(vars map[string]string) ([]string, error) {
+	var env []string
+	for key, value := range vars {
+		if key == "" || strings.Contains(key, "=") {
+			return nil, fmt.Errorf("invalid environment variable name %q", key)
+		}
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return env, nil
+}
+
+func createContainer(c *gin.Context) {
+	var spec ContainerSpec
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	config, hostConfig, networkingConfig, err := spec.build()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if spec.Pull {
+		if _, _, err := dockerClient.ImageInspectWithRaw(ctx, spec.Image); client.IsErrNotFound(err) {
+			log.Infof("Pulling missing image %s", spec.Image)
+			if err := pullImageAndWait(ctx, spec.Image); err != nil {
+				respondDockerError(c, "pull image "+spec.Image, err)
+				return
+			}
+		} else if err != nil {
+			respondDockerError(c, "inspect image "+spec.Image, err)
+			return
+		}
+	}
+
+	created, err := dockerClient.ContainerCreate(ctx, config, hostConfig, networkingConfig, nil, spec.Name)
+	if err != nil {
+		respondDockerError(c, "create container", err)
+		return
+	}
+	if created.Warnings == nil {
+		created.Warnings = []string{}
+	}
+
+	log.Infof("Created container %s from %s", created.ID, spec.Image)
+	c.JSON(http.StatusCreated, gin.H{"id": created.ID, "warnings": created.Warnings})
+}
+
+// dockerErrorStatus maps an error of the Docker client to the HTTP status
+// it stands for, e.g. a missing container to 404 and a name that is taken
+// to 409.
+func dockerErrorStatus(err error) int {
+	switch {
+	case errdefs.IsNotFound(err):
+		return http.StatusNotFound
+	case errdefs.IsConflict(err):
+		return http.StatusConflict
+	case errdefs.IsNotModified(err):
+		return http.StatusNotModified
+	case errdefs.IsInvalidParameter(err):
+		return http.StatusBadRequest
+	case errdefs.IsUnauthorized(err):
+		return http.StatusUnauthorized
+	case errdefs.IsForbidden(err):
+		return http.StatusForbidden
+	case errdefs.IsUnavailable(err):
+		return http.StatusServiceUnavailable
+	case errdefs.IsDeadline(err), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// respondDockerError logs a failed Docker call and answers with the
+// matching status. Client errors are only logged at warning level.
+func respondDockerError(c *gin.Context, action string, err error) {
+	status := dockerErrorStatus(err)
+	if status >= http.StatusInternalServerError {
+		log.Errorf("Failed to %s: %v", action, err)
+	} else {
+		log.Warnf("Failed to %s: %v", action, err)
+	}
+	if status == http.StatusNotModified {
+		// A 304 response must not have a body.
+		c.Status(status)
+		return
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+// queryBool parses an optional boolean query parameter.
+func queryBool(c *gin.Context, key string) (bool, error) {
+	value := c.Query(key)
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q, expected true or false", key, value)
+	}
+	return b, nil
+}
+
+// stopOptions reads the timeout query parameter: seconds to wait for the
+// container to exit before it is killed, -1 to wait forever, or Docker's
+// default (usually 10s) if absent.
+func stopOptions(c *gin.Context) (container.StopOptions, error) {
+	var options container.StopOptions
+	if value := c.Query("timeout"); value != "" {
+		timeout, err := strconv.Atoi(value)
+		if err != nil || timeout < -1 {
+			return options, fmt.Errorf("invalid timeout %q, expected seconds or -1", value)
+		}
+		options.Timeout = &timeout
+	}
+	return options, nil
+}
+
+func getContainer(c *gin.Context) {
+	size, err := queryBool(c, "size")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	info, _, err := dockerClient.ContainerInspectWithRaw(c.Request.Context(), c.Param("id"), size)
+	if err != nil {
+		respondDockerError(c, "inspect container "+c.Param("id"), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, info)
+}
+
+// startContainer answers 304 if the container is already running. The
+// Docker API does the same, but its client reports that as success. Paused
+// containers are left to Docker, which asks for an unpause.
+func startContainer(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	info, err := dockerClient.ContainerInspect(ctx, id)
+	if err != nil {
+		respondDockerError(c, "inspect container "+id, err)
+		return
+	}
+	if info.State.Running && !info.State.Paused {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	if err := dockerClient.ContainerStart(ctx, id, types.ContainerStartOptions{}); err != nil {
+		respondDockerError(c, "start container "+id, err)
+		return
+	}
+
+	log.Infof("Started container %s", id)
+	c.Status(http.StatusNoContent)
+}
+
+// stopContainer answers 304 if the container is not running.
+func stopContainer(c *gin.Context) {
+	options, err := stopOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	info, err := dockerClient.ContainerInspect(ctx, id)
+	if err != nil {
+		respondDockerError(c, "inspect container "+id, err)
+		return
+	}
+	if !info.State.Running {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	if err := dockerClient.ContainerStop(ctx, id, options); err != nil {
+		respondDockerError(c, "stop container "+id, err)
+		return
+	}
+
+	log.Infof("Stopped container %s", id)
+	c.Status(http.StatusNoContent)
+}
+
+func restartContainer(c *gin.Context) {
+	options, err := stopOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	id := c.Param("id")
+	if err := dockerClient.ContainerRestart(c.Request.Context(), id, options); err != nil {
+		respondDockerError(c, "restart container "+id, err)
+		return
+	}
+
+	log.Infof("Restarted container %s", id)
+	c.Status(http.StatusNoContent)
+}
+
+// killContainer sends the signal query parameter, e.g. SIGHUP, HUP or 1,
+// or SIGKILL if absent. Docker rejects unknown signals and containers that
+// are not running.
+func killContainer(c *gin.Context) {
+	signal := c.DefaultQuery("signal", "SIGKILL")
+	id := c.Param("id")
+	if err := dockerClient.ContainerKill(c.Request.Context(), id, signal); err != nil {
+		respondDockerError(c, "kill container "+id, err)
+		return
+	}
+
+	log.Infof("Sent %s to container %s", signal, id)
+	c.Status(http.StatusNoContent)
+}
+
+func pauseContainer(c *gin.Context) {
+	id := c.Param("id")
+	if err := dockerClient.ContainerPause(c.Request.Context(), id); err != nil {
+		respondDockerError(c, "pause container "+id, err)
+		return
+	}
+
+	log.Infof("Paused container %s", id)
+	c.Status(http.StatusNoContent)
+}
+
+func unpauseContainer(c *gin.Context) {
+	id := c.Param("id")
+	if err := dockerClient.ContainerUnpause(c.Request.Context(), id); err != nil {
+		respondDockerError(c, "unpause container "+id, err)
+		return
+	}
+
+	log.Infof("Unpaused container %s", id)
+	c.Status(http.StatusNoContent)
+}
+
+func renameContainer(c *gin.Context) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !containerNamePattern.MatchString(body.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid container name %q, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", body.Name)})
+		return
+	}
+	id := c.Param("id")
+	if err := dockerClient.ContainerRename(c.Request.Context(), id, body.Name); err != nil {
+		respondDockerError(c, "rename container "+id, err)
+		return
+	}
+
+	log.Infof("Renamed container %s to %s", id, body.Name)
+	c.Status(http.StatusNoContent)
+}
+
+// removeContainer removes a stopped container. force=true also removes a
+// running one, volumes=true its anonymous volumes.
+func removeContainer(c *gin.Context) {
+	force, err := queryBool(c, "force")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	volumes, err := queryBool(c, "volumes")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	id := c.Param("id")
+	options := types.ContainerRemoveOptions{Force: force, RemoveVolumes: volumes}
+	if err := dockerClient.ContainerRemove(c.Request.Context(), id, options); err != nil {
+		respondDockerError(c, "remove container "+id, err)
+		return
+	}
+
+	log.Infof("Removed container %s", id)
+	c.Status(http.StatusNoContent)
+}
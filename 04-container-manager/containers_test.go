@@ -0,0 +1,117 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/go-connections/nat"
+)
+
+func TestContainerSpecBuild(t *testing.T) {
+	spec := ContainerSpec{
+		Image:      "nginx:1.25",
+		Name:       "web",
+		Command:    []string{"nginx", "-g", "daemon off;"},
+		Env:        map[string]string{"PORT": "80", "MODE": "prod"},
+		WorkingDir: "/srv",
+		Ports: []PortSpec{
+			{ContainerPort: 80, HostPort: 8080},
+			{ContainerPort: 80, HostIP: "127.0.0.1"},
+			{ContainerPort: 53, Protocol: "udp", HostPort: 5353},
+		},
+		Mounts: []MountSpec{
+			{Type: "bind", Source: "/etc/nginx", Target: "/etc/nginx", ReadOnly: true},
+			{Type: "volume", Source: "data", Target: "/data"},
+			{Type: "tmpfs", Target: "/tmp"},
+		},
+		Network:     "backend",
+		Aliases:     []string{"www"},
+		Restart:     &RestartSpec{Name: "on-failure", MaxRetries: 3},
+		Resources:   &ResourceSpec{CPUs: 1.5, Memory: "512m", MemorySwap: "1g", PidsLimit: 100},
+		Healthcheck: &HealthcheckSpec{Test: []string{"CMD", "true"}, Interval: "30s", Retries: 2},
+	}
+	config, hostConfig, networkingConfig, err := spec.build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(config.Env, []string{"MODE=prod", "PORT=80"}) {
+		t.Errorf("env = %v", config.Env)
+	}
+	wantBindings := nat.PortMap{
+		"80/tcp": {{HostPort: "8080"}, {HostIP: "127.0.0.1"}},
+		"53/udp": {{HostPort: "5353"}},
+	}
+	if !reflect.DeepEqual(hostConfig.PortBindings, wantBindings) || len(config.ExposedPorts) != 2 {
+		t.Errorf("ports = %v, exposed %v", hostConfig.PortBindings, config.ExposedPorts)
+	}
+	if len(hostConfig.Mounts) != 3 || hostConfig.Mounts[0].Type != mount.TypeBind || !hostConfig.Mounts[0].ReadOnly {
+		t.Errorf("mounts = %+v", hostConfig.Mounts)
+	}
+	if hostConfig.RestartPolicy != (container.RestartPolicy{Name: "on-failure", MaximumRetryCount: 3}) {
+		t.Errorf("restart policy = %+v", hostConfig.RestartPolicy)
+	}
+	if hostConfig.NanoCPUs != 1.5e9 || hostConfig.Memory != 512<<20 || hostConfig.MemorySwap != 1<<30 || *hostConfig.PidsLimit != 100 {
+		t.Errorf("resources = %+v", hostConfig.Resources)
+	}
+	if h := config.Healthcheck; h == nil || h.Interval != 30*time.Second || h.Retries != 2 {
+		t.Errorf("healthcheck = %+v", config.Healthcheck)
+	}
+	if hostConfig.NetworkMode != "backend" || !reflect.DeepEqual(networkingConfig.EndpointsConfig["backend"].Aliases, []string{"www"}) {
+		t.Errorf("network = %s, %+v", hostConfig.NetworkMode, networkingConfig)
+	}
+
+	// A bare image needs no host or networking configuration.
+	config, hostConfig, networkingConfig, err = (&ContainerSpec{Image: "alpine"}).build()
+	if err != nil || config.Image != "alpine" || hostConfig.PortBindings != nil || networkingConfig != nil {
+		t.Errorf("minimal spec: %+v, %+v, %+v, %v", config, hostConfig, networkingConfig, err)
+	}
+}
+
+func TestContainerSpecBuildErrors(t *testing.T) {
+	tests := []struct {
+		spec ContainerSpec
+		err  string
+	}{
+		{ContainerSpec{}, "image is required"},
+		{ContainerSpec{Image: "nginx latest"}, "image is required"},
+		{ContainerSpec{Image: "nginx", Name: "-web"}, "invalid container name"},
+		{ContainerSpec{Image: "nginx", WorkingDir: "srv"}, "working_dir must be an absolute path"},
+		{ContainerSpec{Image: "nginx", Env: map[string]string{"": "x"}}, "invalid environment variable name"},
+		{ContainerSpec{Image: "nginx", Ports: []PortSpec{{ContainerPort: 80, Protocol: "http"}}}, "invalid protocol"},
+		{ContainerSpec{Image: "nginx", Ports: []PortSpec{{ContainerPort: 0}}}, "invalid port mapping"},
+		{ContainerSpec{Image: "nginx", Ports: []PortSpec{{ContainerPort: 80, HostPort: 70000}}}, "invalid port mapping"},
+		{ContainerSpec{Image: "nginx", Mounts: []MountSpec{{Type: "bind", Source: "/a", Target: "b"}}}, "must be an absolute path"},
+		{ContainerSpec{Image: "nginx", Mounts: []MountSpec{{Type: "bind", Source: "a", Target: "/b"}}}, "bind mount source"},
+		{ContainerSpec{Image: "nginx", Mounts: []MountSpec{{Type: "tmpfs", Source: "a", Target: "/b"}}}, "must not have a source"},
+		{ContainerSpec{Image: "nginx", Mounts: []MountSpec{{Type: "npipe", Target: "/b"}}}, "invalid mount type"},
+		{ContainerSpec{Image: "nginx", Restart: &RestartSpec{Name: "sometimes"}}, "invalid restart policy"},
+		{ContainerSpec{Image: "nginx", Restart: &RestartSpec{Name: "always", MaxRetries: 3}}, "max_retries is only valid"},
+		{ContainerSpec{Image: "nginx", Resources: &ResourceSpec{CPUs: -1}}, "must not be negative"},
+		{ContainerSpec{Image: "nginx", Resources: &ResourceSpec{Memory: "lots"}}, "invalid memory limit"},
+		{ContainerSpec{Image: "nginx", Resources: &ResourceSpec{MemorySwap: "1g"}}, "memory_swap requires memory"},
+		{ContainerSpec{Image: "nginx", Resources: &ResourceSpec{Memory: "1g", MemorySwap: "512m"}}, "memory_swap requires memory"},
+		{ContainerSpec{Image: "nginx", Healthcheck: &HealthcheckSpec{}}, "healthcheck test is required"},
+		{ContainerSpec{Image: "nginx", Healthcheck: &HealthcheckSpec{Test: []string{"CMD"}}}, "needs a command"},
+		{ContainerSpec{Image: "nginx", Healthcheck: &HealthcheckSpec{Test: []string{"true"}}}, "must start with NONE"},
+		{ContainerSpec{Image: "nginx", Healthcheck: &HealthcheckSpec{Test: []string{"NONE"}, Interval: "1us"}}, "invalid healthcheck interval"},
+		{ContainerSpec{Image: "nginx", Healthcheck: &HealthcheckSpec{Test: []string{"NONE"}, Retries: -1}}, "retries must not be negative"},
+		{ContainerSpec{Image: "nginx", Aliases: []string{"www"}}, "network_aliases require a network"},
+	}
+	for _, tt := range tests {
+		_, _, _, err := tt.spec.build()
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%+v: err = %v, want %q", tt.spec, err, tt.err)
+		}
+	}
+
+	// Unlimited swap is allowed without a memory limit.
+	_, hostConfig, _, err := (&ContainerSpec{Image: "nginx", Resources: &ResourceSpec{MemorySwap: "-1"}}).build()
+	if err != nil || hostConfig.MemorySwap != -1 {
+		t.Errorf("memory_swap -1: %v, %d", err, hostConfig.MemorySwap)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// ExecSpec is the request body of POST /containers/:id/exec.
+type ExecSpec struct {
+	Cmd        []string          `json:"cmd"`
+	Env        map[string]string `json:"env"`
+	User       string            `json:"user"`
+	WorkingDir string            `json:"workdir"`
+	Tty        bool              `json:"tty"`
+}
+
+// execMessage is a message on an exec WebSocket. Clients send stdin,
+// close_stdin and resize; the server sends stdout, stderr, exit and error.
+type execMessage struct {
+	Type     string `json:"type"`
+	Data     string `json:"data,omitempty"`
+	Rows     uint   `json:"rows,omitempty"`
+	Cols     uint   `json:"cols,omitempty"`
+	ExitCode *int   `json:"exit_code,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// build validates the spec and converts it into Docker's exec config.
+func (s *ExecSpec) build() (types.ExecConfig, error) {
+	config := types.ExecConfig{
+		Cmd:          s.Cmd,
+		User:         s.User,
+		WorkingDir:   s.WorkingDir,
+		Tty:          s.Tty,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+	}
+	if len(s.Cmd) == 0 || s.Cmd[0] == "" {
+		return config, fmt.Errorf("cmd is required")
+	}
+	if s.WorkingDir != "" && !path.IsAbs(s.WorkingDir) {
+		return config, fmt.Errorf("workdir must be an absolute path")
+	}
+	env, err := envList(s.Env)
+	if err != nil {
+		return config, err
+	}
+	config.Env = env
+	return config, nil
+}
+
+// createExec creates an exec instance that is started by attaching to it.
+func createExec(c *gin.Context) {
+	var spec ExecSpec
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	config, err := spec.build()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id := c.Param("id")
+	created, err := dockerClient.ContainerExecCreate(c.Request.Context(), id, config)
+	if err != nil {
+		respondDockerError(c, "create exec in container "+id, err)
+		return
+	}
+
+	log.Infof("Created exec %s in container %s: %q", created.ID, id, spec.Cmd)
+	c.JSON(http.StatusCreated, gin.H{"id": created.ID})
+}
+
+// outputMessage wraps output of the process in a stdout or stderr message.
+func outputMessage(stream stdcopy.StdType, data []byte) execMessage {
+	if stream == stdcopy.Stderr {
+		return execMessage{Type: "stderr", Data: string(data)}
+	}
+	return execMessage{Type: "stdout", Data: string(data)}
+}
+
+// utf8Complete returns how much of p ends on a rune boundary, so a rune
+// split across two reads is not sent as two invalid halves.
+func utf8Complete(p []byte) int {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(p[i]) {
+			continue
+		}
+		if utf8.FullRune(p[i:]) {
+			return len(p)
+		}
+		return i
+	}
+	return len(p)
+}
+
+// execExitPoll is how often execExitCode checks whether the process has
+// exited, for up to execExitTimeout after its output ended.
+var (
+	execExitPoll    = 100 * time.Millisecond
+	execExitTimeout = 5 * time.Second
+)
+
+// execExitCode waits briefly for the process to be reaped after its output
+// ended and returns its exit code, or nil if it is still running, e.g.
+// because it closed its output without exiting.
+func execExitCode(ctx context.Context, id string) (*int, error) {
+	ticker := time.NewTicker(execExitPoll)
+	defer ticker.Stop()
+	deadline := time.Now().Add(execExitTimeout)
+	for {
+		info, err := dockerClient.ContainerExecInspect(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if !info.Running {
+			return &info.ExitCode, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// attachExec starts an exec instance and connects it to a WebSocket:
+// binary messages and stdin messages are written to the process, resize
+// messages resize its terminal, and its output is sent as stdout and
+// stderr messages, followed by an exit message with the exit code if the
+// process has exited. Closing the WebSocket early detaches without stopping
+// the process.
+func attachExec(c *gin.Context) {
+	if !websocket.IsWebSocketUpgrade(c.Request) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exec must be attached over a WebSocket"})
+		return
+	}
+	id := c.Param("id")
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	info, err := dockerClient.ContainerExecInspect(ctx, id)
+	if err != nil {
+		respondDockerError(c, "inspect exec "+id, err)
+		return
+	}
+	if info.Running || info.Pid != 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "exec " + id + " has already been started"})
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already answered the request.
+		log.Warnf("Failed to upgrade exec %s: %v", id, err)
+		return
+	}
+	defer conn.Close()
+	// Output and replies to control messages come from different
+	// goroutines, but a WebSocket allows only one writer at a time.
+	var writeMu sync.Mutex
+	send := func(msg execMessage) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return writeWebSocketJSON(conn, msg)
+	}
+	fail := func(action string, err error) {
+		log.Warnf("Failed to %s exec %s: %v", action, id, err)
+		send(execMessage{Type: "error", Error: err.Error()})
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		closeWebSocket(conn, websocket.CloseInternalServerErr, action+" failed")
+	}
+
+	// Attaching without a TTY makes Docker multiplex the output even if the
+	// exec has a TTY, so one reader handles both cases.
+	hijacked, err := dockerClient.ContainerExecAttach(ctx, id, types.ExecStartCheck{})
+	if err != nil {
+		fail("start", err)
+		return
+	}
+	defer hijacked.Close()
+	// Closing the connection unblocks the output reader when the client
+	// goes away.
+	go func() {
+		<-ctx.Done()
+		hijacked.Close()
+	}()
+	log.Infof("Attached to exec %s in container %s", id, info.ContainerID)
+
+	go func() {
+		defer cancel()
+		for {
+			kind, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if kind == websocket.BinaryMessage {
+				if _, err := hijacked.Conn.Write(data); err != nil {
+					return
+				}
+				continue
+			}
+			var msg execMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				send(execMessage{Type: "error", Error: "invalid message: " + err.Error()})
+				continue
+			}
+			switch msg.Type {
+			case "stdin":
+				if _, err := hijacked.Conn.Write([]byte(msg.Data)); err != nil {
+					return
+				}
+			case "close_stdin":
+				hijacked.CloseWrite()
+			case "resize":
+				if msg.Rows == 0 || msg.Cols == 0 {
+					send(execMessage{Type: "error", Error: "resize needs rows and cols"})
+					continue
+				}
+				err := dockerClient.ContainerExecResize(ctx, id, types.ResizeOptions{Height: msg.Rows, Width: msg.Cols})
+				if err != nil && ctx.Err() == nil {
+					log.Warnf("Failed to resize exec %s: %v", id, err)
+					send(execMessage{Type: "error", Error: err.Error()})
+				}
+			default:
+				send(execMessage{Type: "error", Error: fmt.Sprintf("unknown message type %q", msg.Type)})
+			}
+		}
+	}()
+
+	// Bytes of a rune split across frames, per stream.
+	pending := map[stdcopy.StdType][]byte{}
+	err = readFrames(hijacked.Reader, func(stream stdcopy.StdType, payload []byte) error {
+		data := append(pending[stream], payload...)
+		n := utf8Complete(data)
+		pending[stream] = append([]byte(nil), data[n:]...)
+		if n == 0 {
+			return nil
+		}
+		return send(outputMessage(stream, data[:n]))
+	})
+	if ctx.Err() != nil {
+		log.Infof("Client detached from exec %s", id)
+		return
+	}
+	if err != nil {
+		fail("read output of", err)
+		return
+	}
+	for _, stream := range []stdcopy.StdType{stdcopy.Stdout, stdcopy.Stderr} {
+		if len(pending[stream]) > 0 {
+			send(outputMessage(stream, pending[stream]))
+		}
+	}
+
+	code, err := execExitCode(ctx, id)
+	if ctx.Err() != nil {
+		log.Infof("Client detached from exec %s", id)
+		return
+	}
+	if err != nil {
+		fail("inspect", err)
+		return
+	}
+	if code == nil {
+		log.Warnf("Exec %s closed its output but is still running", id)
+	} else {
+		log.Infof("Exec %s exited with code %d", id, *code)
+	}
+	send(execMessage{Type: "exit", ExitCode: code})
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	closeWebSocket(conn, websocket.CloseNormalClosure, "")
+}
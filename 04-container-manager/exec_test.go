@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+func TestUTF8Complete(t *testing.T) {
+	euro := "€"           // 3 bytes
+	emoji := "\U0001f600" // 4 bytes
+	tests := []struct {
+		input string
+		want  int
+	}{
+		{"", 0},
+		{"plain", 5},
+		{"price " + euro, 9},
+		{"price " + euro[:1], 6},
+		{"price " + euro[:2], 6},
+		{emoji[:3], 0},
+		{"a" + emoji, 5},
+		// Bytes that can never start a rune are passed on as they are.
+		{"a\x80\x80", 3},
+		{"\xff", 1},
+		{"\x80\x80\x80\x80\x80", 5},
+	}
+	for _, tt := range tests {
+		if got := utf8Complete([]byte(tt.input)); got != tt.want {
+			t.Errorf("utf8Complete(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestExecSpecBuild(t *testing.T) {
+	spec := ExecSpec{Cmd: []string{"sh", "-c", "env"}, Env: map[string]string{"B": "2", "A": "1"}, User: "nobody", WorkingDir: "/tmp", Tty: true}
+	config, err := spec.build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config.Env, []string{"A=1", "B=2"}) || !config.Tty || !config.AttachStdin || !config.AttachStdout || !config.AttachStderr {
+		t.Errorf("config = %+v", config)
+	}
+
+	for _, bad := range []ExecSpec{
+		{},
+		{Cmd: []string{""}},
+		{Cmd: []string{"ls"}, WorkingDir: "tmp"},
+		{Cmd: []string{"ls"}, Env: map[string]string{"A=B": "c"}},
+	} {
+		if _, err := bad.build(); err == nil {
+			t.Errorf("%+v: expected an error", bad)
+		}
+	}
+}
+
+// fakeDocker points dockerClient at handler for the duration of the test.
+func fakeDocker(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+strings.TrimPrefix(server.URL, "http://")), client.WithVersion("1.43"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := dockerClient
+	dockerClient = cli
+	t.Cleanup(func() { dockerClient = saved })
+}
+
+func TestExecExitCode(t *testing.T) {
+	savedPoll, savedTimeout := execExitPoll, execExitTimeout
+	t.Cleanup(func() { execExitPoll, execExitTimeout = savedPoll, savedTimeout })
+	execExitPoll, execExitTimeout = time.Millisecond, 50*time.Millisecond
+
+	// The process is reaped after runningPolls inspections.
+	var polls, runningPolls int32
+	fakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&polls, 1)
+		if n <= atomic.LoadInt32(&runningPolls) {
+			json.NewEncoder(w).Encode(map[string]interface{}{"ID": "e1", "Running": true, "ExitCode": 0})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"ID": "e1", "Running": false, "ExitCode": 3})
+	})
+
+	atomic.StoreInt32(&runningPolls, 3)
+	code, err := execExitCode(context.Background(), "e1")
+	if n := atomic.LoadInt32(&polls); err != nil || code == nil || *code != 3 || n != 4 {
+		t.Errorf("code = %v, err = %v after %d polls, want 3 after 4", code, err, n)
+	}
+
+	// A process that keeps running has no exit code, not exit code 0.
+	atomic.StoreInt32(&polls, 0)
+	atomic.StoreInt32(&runningPolls, 1<<30)
+	if code, err := execExitCode(context.Background(), "e1"); err != nil || code != nil {
+		t.Errorf("still running: code = %v, err = %v, want nil", code, err)
+	}
+
+	// Waiting stops as soon as the client goes away.
+	execExitTimeout = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := execExitCode(ctx, "e1"); !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+		t.Errorf("canceled: err = %v after %s", err, time.Since(start))
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/gin-gonic/gin"
+)
+
+// pullProgressInterval limits how often byte counts are reported; layer
+// status changes are always reported.
+const pullProgressInterval = 250 * time.Millisecond
+
+// platformPattern matches os[/arch[/variant]], e.g. linux/arm64/v8.
+var platformPattern = regexp.MustCompile(`^[a-z0-9_-]+(/[a-z0-9_-]+(/[a-z0-9_.-]+)?)?$`)
+
+// PullRequest is the request body of POST /images.
+type PullRequest struct {
+	Reference string `json:"reference"`
+	Platform  string `json:"platform"`
+	// Auth holds registry credentials, either a username and password or
+	// a token.
+	Auth *RegistryAuth `json:"auth"`
+}
+
+// RegistryAuth are the credentials for the registry of a pulled image.
+type RegistryAuth struct {
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	ServerAddress string `json:"server_address"`
+	IdentityToken string `json:"identity_token"`
+	RegistryToken string `json:"registry_token"`
+}
+
+// LayerProgress is the state of one layer of a pull.
+type LayerProgress struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// Size is the compressed size, known once the download started.
+	Size       int64 `json:"size,omitempty"`
+	Downloaded int64 `json:"downloaded,omitempty"`
+	Extracted  int64 `json:"extracted,omitempty"`
+	done       bool
+}
+
+// PullProgress is the overall progress of a pull, aggregated from
+// Docker's per-layer progress messages.
+type PullProgress struct {
+	Status     string           `json:"status,omitempty"`
+	Layers     []*LayerProgress `json:"layers"`
+	LayersDone int              `json:"layers_done"`
+	// DownloadedBytes and TotalBytes cover the layers whose size is known.
+	DownloadedBytes int64 `json:"downloaded_bytes"`
+	TotalBytes      int64 `json:"total_bytes"`
+	// Percent weighs downloading and extracting equally. It never goes
+	// down, even when the size of another layer becomes known.
+	Percent float64 `json:"percent"`
+}
+
+// PullResult describes the image once the pull finished.
+type PullResult struct {
+	Reference string `json:"reference"`
+	Digest    string `json:"digest,omitempty"`
+	ID        string `json:"id"`
+	Status    string `json:"status,omitempty"`
+}
+
+// pullTracker aggregates the JSON messages of a pull.
+type pullTracker struct {
+	progress PullProgress
+	layers   map[string]*LayerProgress
+	digest   string
+	result   string
+}
+
+func newPullTracker() *pullTracker {
+	return &pullTracker{progress: PullProgress{Layers: []*LayerProgress{}}, layers: map[string]*LayerProgress{}}
+}
+
+// update applies one message and reports whether a status changed, as
+// opposed to only byte counts.
+func (t *pullTracker) update(msg *jsonmessage.JSONMessage) bool {
+	switch {
+	case strings.HasPrefix(msg.Status, "Digest: "):
+		t.digest = strings.TrimPrefix(msg.Status, "Digest: ")
+		return false
+	case strings.HasPrefix(msg.Status, "Status: "):
+		t.result = strings.TrimPrefix(msg.Status, "Status: ")
+		t.progress.Status = t.result
+		return true
+	case msg.ID == "" || strings.HasPrefix(msg.Status, "Pulling from "):
+		// The ID of "Pulling from" is the tag, not a layer.
+		t.progress.Status = msg.Status
+		return true
+	}
+
+	layer, ok := t.layers[msg.ID]
+	if !ok {
+		layer = &LayerProgress{ID: msg.ID}
+		t.layers[msg.ID] = layer
+		t.progress.Layers = append(t.progress.Layers, layer)
+	}
+	changed := layer.Status != msg.Status
+	layer.Status = msg.Status
+	var current, total int64
+	if msg.Progress != nil {
+		current, total = msg.Progress.Current, msg.Progress.Total
+	}
+	switch msg.Status {
+	case "Downloading":
+		layer.Downloaded = current
+		if total > 0 {
+			layer.Size = total
+		}
+	case "Verifying Checksum", "Download complete":
+		layer.Downloaded = layer.Size
+	case "Extracting":
+		if total > 0 && layer.Size == 0 {
+			layer.Size = total
+		}
+		layer.Downloaded, layer.Extracted = layer.Size, current
+	case "Pull complete":
+		layer.Downloaded, layer.Extracted, layer.done = layer.Size, layer.Size, true
+	case "Already exists":
+		layer.done = true
+	}
+	t.summarize()
+	return changed
+}
+
+// summarize recomputes the totals from the layers.
+func (t *pullTracker) summarize() {
+	p := &t.progress
+	p.LayersDone, p.DownloadedBytes, p.TotalBytes = 0, 0, 0
+	var extracted int64
+	for _, layer := range p.Layers {
+		if layer.done {
+			p.LayersDone++
+		}
+		p.DownloadedBytes += layer.Downloaded
+		p.TotalBytes += layer.Size
+		extracted += layer.Extracted
+	}
+	if p.TotalBytes > 0 {
+		percent := float64(p.DownloadedBytes+extracted) / float64(2*p.TotalBytes) * 100
+		if percent > p.Percent {
+			p.Percent = percent
+		}
+	}
+}
+
+// pullImageProgress pulls an image, passing the aggregated progress to
+// onProgress if it is not nil, and returns the tracker with the digest and
+// final status Docker reported. Pull failures are reported inside the
+// progress stream, not by ImagePull.
+func pullImageProgress(ctx context.Context, ref string, options types.ImagePullOptions, onProgress func(*PullProgress) error) (*pullTracker, error) {
+	rd, err := dockerClient.ImagePull(ctx, ref, options)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	tracker := newPullTracker()
+	dec := json.NewDecoder(rd)
+	var lastSent time.Time
+	unsent := false
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := dec.Decode(&msg); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if msg.Error != nil {
+			return nil, pullStreamError(msg.Error.Code, msg.Error.Message)
+		}
+		if msg.ErrorMessage != "" {
+			return nil, pullStreamError(0, msg.ErrorMessage)
+		}
+		changed := tracker.update(&msg)
+		unsent = true
+		if onProgress != nil && (changed || time.Since(lastSent) >= pullProgressInterval) {
+			if err := onProgress(&tracker.progress); err != nil {
+				return nil, err
+			}
+			lastSent, unsent = time.Now(), false
+		}
+	}
+	if tracker.progress.Percent < 100 {
+		tracker.progress.Percent, unsent = 100, true
+	}
+	if onProgress != nil && unsent {
+		if err := onProgress(&tracker.progress); err != nil {
+			return nil, err
+		}
+	}
+	return tracker, nil
+}
+
+// pullStreamError classifies an error reported inside the pull stream,
+// which has no HTTP status of its own, so dockerErrorStatus maps a missing
+// image to 404 and refused credentials to 401. The daemon rarely sets a
+// code, so the message is checked too.
+func pullStreamError(code int, message string) error {
+	err := errors.New(message)
+	lower := strings.ToLower(message)
+	switch {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden ||
+		strings.Contains(lower, "unauthorized") || strings.Contains(lower, "denied") ||
+		strings.Contains(lower, "authentication required") || strings.Contains(lower, "no basic auth credentials"):
+		// "pull access denied" is also the answer for a private image that
+		// does not exist, so denial takes precedence.
+		return errdefs.Unauthorized(err)
+	case code == http.StatusNotFound || strings.Contains(lower, "not found") ||
+		strings.Contains(lower, "manifest unknown") || strings.Contains(lower, "does not exist"):
+		return errdefs.NotFound(err)
+	}
+	return err
+}
+
+// pullImageAndWait pulls an image and returns once the pull finished.
+func pullImageAndWait(ctx context.Context, ref string) error {
+	_, err := pullImageProgress(ctx, ref, types.ImagePullOptions{}, nil)
+	return err
+}
+
+// build validates the request and returns the reference, with the latest
+// tag if it names neither a tag nor a digest, and the pull options.
+func (r *PullRequest) build() (reference.Named, types.ImagePullOptions, error) {
+	var options types.ImagePullOptions
+	named, err := reference.ParseNormalizedNamed(strings.TrimSpace(r.Reference))
+	if err != nil {
+		return nil, options, fmt.Errorf("invalid reference %q: %v", r.Reference, err)
+	}
+	if r.Platform != "" && !platformPattern.MatchString(r.Platform) {
+		return nil, options, fmt.Errorf("invalid platform %q, expected os[/arch[/variant]]", r.Platform)
+	}
+	options.Platform = r.Platform
+	if r.Auth != nil {
+		auth, err := registry.EncodeAuthConfig(registry.AuthConfig{
+			Username:      r.Auth.Username,
+			Password:      r.Auth.Password,
+			ServerAddress: r.Auth.ServerAddress,
+			IdentityToken: r.Auth.IdentityToken,
+			RegistryToken: r.Auth.RegistryToken,
+		})
+		if err != nil {
+			return nil, options, err
+		}
+		options.RegistryAuth = auth
+	}
+	return reference.TagNameOnly(named), options, nil
+}
+
+// pullImage pulls an image and streams the progress as one JSON object per
+// line, or as server-sent events if the client accepts text/event-stream,
+// ending with the digest and ID of the image.
+func pullImage(c *gin.Context) {
+	var req PullRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	named, options, err := req.build()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ref := reference.FamiliarString(named)
+
+	ctx := c.Request.Context()
+	sse := strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+	enc := json.NewEncoder(c.Writer)
+	send := func(event string, v interface{}) error {
+		if sse {
+			c.SSEvent(event, v)
+		} else if err := enc.Encode(gin.H{event: v}); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return ctx.Err()
+	}
+	fail := func(err error) {
+		if sse {
+			c.SSEvent("error", gin.H{"error": err.Error()})
+		} else {
+			enc.Encode(gin.H{"error": err.Error()})
+		}
+	}
+
+	log.Infof("Pulling image %s", ref)
+	started := false
+	tracker, err := pullImageProgress(ctx, ref, options, func(progress *PullProgress) error {
+		if !started {
+			// Errors before the first message, e.g. a missing image or
+			// bad credentials, still get their own status, see
+			// pullStreamError.
+			started = true
+			if sse {
+				c.Header("Content-Type", "text/event-stream")
+				c.Header("Cache-Control", "no-cache")
+			} else {
+				c.Header("Content-Type", "application/x-ndjson")
+			}
+			c.Header("X-Accel-Buffering", "no")
+			c.Status(http.StatusOK)
+		}
+		return send("progress", progress)
+	})
+	if err != nil {
+		if !started {
+			respondDockerError(c, "pull image "+ref, err)
+			return
+		}
+		if ctx.Err() == nil {
+			// The status has been sent, so the error ends the stream instead.
+			log.Warnf("Failed to pull image %s: %v", ref, err)
+			fail(err)
+		}
+		return
+	}
+
+	result := PullResult{Reference: ref, Digest: tracker.digest, Status: tracker.result}
+	image, _, err := dockerClient.ImageInspectWithRaw(ctx, ref)
+	if err != nil {
+		log.Warnf("Failed to inspect pulled image %s: %v", ref, err)
+		fail(err)
+		return
+	}
+	result.ID = image.ID
+	if result.Digest == "" {
+		// Docker does not report the digest in every case, e.g. when the
+		// image was pulled by digest.
+		prefix := reference.FamiliarName(named) + "@"
+		for _, repoDigest := range image.RepoDigests {
+			if strings.HasPrefix(repoDigest, prefix) {
+				result.Digest = strings.TrimPrefix(repoDigest, prefix)
+				break
+			}
+		}
+	}
+	log.Infof("Pulled image %s (%s)", ref, result.Digest)
+	send("complete", result)
+}
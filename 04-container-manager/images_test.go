@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/gin-gonic/gin"
+)
+
+func TestPullTracker(t *testing.T) {
+	progress := func(current, total int64) *jsonmessage.JSONProgress {
+		return &jsonmessage.JSONProgress{Current: current, Total: total}
+	}
+	tracker := newPullTracker()
+	steps := []struct {
+		msg     jsonmessage.JSONMessage
+		changed bool
+		percent float64
+	}{
+		{jsonmessage.JSONMessage{ID: "latest", Status: "Pulling from library/nginx"}, true, 0},
+		{jsonmessage.JSONMessage{ID: "a", Status: "Pulling fs layer"}, true, 0},
+		{jsonmessage.JSONMessage{ID: "b", Status: "Already exists"}, true, 0},
+		{jsonmessage.JSONMessage{ID: "c", Status: "Pulling fs layer"}, true, 0},
+		{jsonmessage.JSONMessage{ID: "a", Status: "Downloading", Progress: progress(50, 100)}, true, 25},
+		{jsonmessage.JSONMessage{ID: "a", Status: "Downloading", Progress: progress(100, 100)}, false, 50},
+		// A layer whose size becomes known does not move the percentage back.
+		{jsonmessage.JSONMessage{ID: "c", Status: "Downloading", Progress: progress(0, 300)}, true, 50},
+		{jsonmessage.JSONMessage{ID: "a", Status: "Download complete"}, true, 50},
+		{jsonmessage.JSONMessage{ID: "a", Status: "Extracting", Progress: progress(50, 100)}, true, 50},
+		{jsonmessage.JSONMessage{ID: "c", Status: "Verifying Checksum"}, true, 56.25},
+		{jsonmessage.JSONMessage{ID: "a", Status: "Pull complete"}, true, 62.5},
+		{jsonmessage.JSONMessage{ID: "c", Status: "Extracting", Progress: progress(150, 300)}, true, 81.25},
+		{jsonmessage.JSONMessage{ID: "c", Status: "Pull complete"}, true, 100},
+		{jsonmessage.JSONMessage{Status: "Digest: sha256:0123"}, false, 100},
+		{jsonmessage.JSONMessage{Status: "Status: Downloaded newer image for nginx:latest"}, true, 100},
+	}
+	for i, step := range steps {
+		msg := step.msg
+		if changed := tracker.update(&msg); changed != step.changed {
+			t.Errorf("step %d (%s %s): changed = %v, want %v", i, msg.ID, msg.Status, changed, step.changed)
+		}
+		if tracker.progress.Percent != step.percent {
+			t.Errorf("step %d (%s %s): percent = %v, want %v", i, msg.ID, msg.Status, tracker.progress.Percent, step.percent)
+		}
+	}
+
+	p := tracker.progress
+	if len(p.Layers) != 3 || p.LayersDone != 3 || p.DownloadedBytes != 400 || p.TotalBytes != 400 {
+		t.Errorf("progress = %+v", p)
+	}
+	if tracker.digest != "sha256:0123" || tracker.result != "Downloaded newer image for nginx:latest" || p.Status != tracker.result {
+		t.Errorf("digest = %q, result = %q, status = %q", tracker.digest, tracker.result, p.Status)
+	}
+	// The tag in the ID of "Pulling from" is not a layer.
+	for _, layer := range p.Layers {
+		if layer.ID == "latest" {
+			t.Error("the tag was tracked as a layer")
+		}
+	}
+}
+
+func TestPullRequestBuild(t *testing.T) {
+	tests := []struct {
+		reference string
+		want      string
+	}{
+		{"nginx", "docker.io/library/nginx:latest"},
+		{" nginx:1.25 ", "docker.io/library/nginx:1.25"},
+		{"ghcr.io/org/app", "ghcr.io/org/app:latest"},
+		{"nginx@sha256:" + sha256Hex, "docker.io/library/nginx@sha256:" + sha256Hex},
+	}
+	for _, tt := range tests {
+		named, _, err := (&PullRequest{Reference: tt.reference}).build()
+		if err != nil {
+			t.Errorf("%q: %v", tt.reference, err)
+			continue
+		}
+		if named.String() != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.reference, named, tt.want)
+		}
+	}
+
+	for _, bad := range []PullRequest{
+		{},
+		{Reference: "Nginx"},
+		{Reference: "nginx:"},
+		{Reference: "nginx", Platform: "linux arm64"},
+		{Reference: "nginx", Platform: "linux/arm64/v8/extra"},
+	} {
+		if _, _, err := bad.build(); err == nil {
+			t.Errorf("%+v: expected an error", bad)
+		}
+	}
+
+	req := &PullRequest{Reference: "nginx", Platform: "linux/arm64/v8", Auth: &RegistryAuth{Username: "user", Password: "secret"}}
+	_, options, err := req.build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Platform != "linux/arm64/v8" {
+		t.Errorf("platform = %q", options.Platform)
+	}
+	data, err := base64.URLEncoding.DecodeString(options.RegistryAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var auth registry.AuthConfig
+	if err := json.Unmarshal(data, &auth); err != nil || auth.Username != "user" || auth.Password != "secret" {
+		t.Errorf("registry auth = %+v, %v", auth, err)
+	}
+}
+
+func TestPullStreamError(t *testing.T) {
+	tests := []struct {
+		code    int
+		message string
+		status  int
+	}{
+		{0, "manifest for nginx:nope not found: manifest unknown: manifest unknown", http.StatusNotFound},
+		{0, "pull access denied for private/app, repository does not exist or may require 'docker login': denied: requested access to the resource is denied", http.StatusUnauthorized},
+		{0, "Head \"https://registry.example.com/v2/app/manifests/latest\": unauthorized: authentication required", http.StatusUnauthorized},
+		{0, "Get \"https://registry.example.com/v2/\": no basic auth credentials", http.StatusUnauthorized},
+		{404, "something went wrong", http.StatusNotFound},
+		{403, "something went wrong", http.StatusUnauthorized},
+		{0, "failed to register layer: no space left on device", http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		err := pullStreamError(tt.code, tt.message)
+		if err.Error() != tt.message {
+			t.Errorf("message changed to %q", err)
+		}
+		if status := dockerErrorStatus(err); status != tt.status {
+			t.Errorf("%d %q: status = %d, want %d", tt.code, tt.message, status, tt.status)
+		}
+	}
+}
+
+func TestPullImageErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// The daemon answers 200 and reports the failure in the stream.
+	fakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/images/create") {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.URL.Query().Get("fromImage") {
+		case "missing":
+			fmt.Fprintln(w, `{"errorDetail":{"message":"manifest for missing:latest not found: manifest unknown"},"error":"manifest for missing:latest not found: manifest unknown"}`)
+		case "broken":
+			fmt.Fprintln(w, `{"status":"Pulling from library/broken","id":"latest"}`)
+			fmt.Fprintln(w, `{"errorDetail":{"message":"unexpected EOF"},"error":"unexpected EOF"}`)
+		}
+	})
+	router := gin.New()
+	router.POST("/images", pullImage)
+	pull := func(reference string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/images", strings.NewReader(`{"reference":"`+reference+`"}`)))
+		return rec
+	}
+
+	if rec := pull("missing"); rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "manifest unknown") {
+		t.Errorf("missing image: %d %s", rec.Code, rec.Body)
+	}
+	// Once progress has been sent, the error ends the stream instead.
+	rec := pull("broken")
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if rec.Code != http.StatusOK || len(lines) != 2 || lines[1] != `{"error":"unexpected EOF"}` {
+		t.Errorf("failed pull: %d %s", rec.Code, rec.Body)
+	}
+}
+
+const sha256Hex = "3b5cd1e1b1a9c4b7f5e0e8f4b8c9a0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5a6b7"
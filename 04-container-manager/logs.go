@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	timetypes "github.com/docker/docker/api/types/time"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/gin-gonic/gin"
+)
+
+// maxLogLine is the longest line kept in one piece; longer lines are split.
+const maxLogLine = 64 << 10
+
+// LogLine is one line of container output.
+type LogLine struct {
+	Stream    string `json:"stream"`
+	Timestamp string `json:"timestamp,omitempty"`
+	Line      string `json:"line"`
+}
+
+// lineSplitter turns chunks of one stream into lines, keeping an
+// incomplete last line until the rest of it arrives.
+type lineSplitter struct {
+	stream     string
+	timestamps bool
+	partial    []byte
+}
+
+func (s *lineSplitter) write(data []byte, emit func(LogLine) error) error {
+	s.partial = append(s.partial, data...)
+	for {
+		i, next := bytes.IndexByte(s.partial, '\n'), 0
+		switch {
+		case i >= 0 && i <= maxLogLine:
+			next = i + 1
+		case len(s.partial) < maxLogLine:
+			return nil
+		default:
+			i, next = maxLogLine, maxLogLine
+		}
+		line := string(s.partial[:i])
+		s.partial = s.partial[next:]
+		if err := emit(s.line(line)); err != nil {
+			return err
+		}
+	}
+}
+
+// flush emits an incomplete last line.
+func (s *lineSplitter) flush(emit func(LogLine) error) error {
+	if len(s.partial) == 0 {
+		return nil
+	}
+	line := string(s.partial)
+	s.partial = nil
+	return emit(s.line(line))
+}
+
+// line splits off the timestamp Docker puts in front of every line when
+// timestamps are requested.
+func (s *lineSplitter) line(text string) LogLine {
+	l := LogLine{Stream: s.stream}
+	if s.timestamps {
+		l.Timestamp, text, _ = strings.Cut(text, " ")
+	}
+	l.Line = strings.TrimSuffix(text, "\r")
+	return l
+}
+
+// demuxLogs reads container logs and passes every line to emit. Without a
+// TTY Docker multiplexes stdout and stderr into frames of an 8 byte header
+// (stream type, three zero bytes, big-endian payload length) followed by
+// the payload; with a TTY the output is a single raw stream.
+func demuxLogs(r io.Reader, tty, timestamps bool, emit func(LogLine) error) error {
+	stdout := &lineSplitter{stream: "stdout", timestamps: timestamps}
+	stderr := &lineSplitter{stream: "stderr", timestamps: timestamps}
+	flush := func() error {
+		if err := stdout.flush(emit); err != nil {
+			return err
+		}
+		return stderr.flush(emit)
+	}
+
+	if tty {
+		buf := make([]byte, 32<<10)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				if err := stdout.write(buf[:n], emit); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF {
+				return flush()
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err := readFrames(r, func(stream stdcopy.StdType, payload []byte) error {
+		if stream == stdcopy.Stderr {
+			return stderr.write(payload, emit)
+		}
+		return stdout.write(payload, emit)
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+// readFrames reads a multiplexed stream until it ends and passes the
+// payload of every stdout and stderr frame to fn. The payload buffer is
+// reused, so fn must not keep it.
+func readFrames(r io.Reader, fn func(stream stdcopy.StdType, payload []byte) error) error {
+	br := bufio.NewReader(r)
+	header := make([]byte, 8)
+	var payload []byte
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		size := binary.BigEndian.Uint32(header[4:])
+		if cap(payload) < int(size) {
+			payload = make([]byte, size)
+		}
+		payload = payload[:size]
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return err
+		}
+		switch stream := stdcopy.StdType(header[0]); stream {
+		case stdcopy.Stdout, stdcopy.Stderr:
+			if err := fn(stream, payload); err != nil {
+				return err
+			}
+		case stdcopy.Systemerr:
+			return fmt.Errorf("error from daemon in stream: %s", payload)
+		default:
+			return fmt.Errorf("unknown stream type %d in multiplexed stream", header[0])
+		}
+	}
+}
+
+// logsOptions reads the query parameters of GET /containers/:id/logs.
+func logsOptions(c *gin.Context) (types.ContainerLogsOptions, error) {
+	options := types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Tail: c.DefaultQuery("tail", "all")}
+	for key, dst := range map[string]*bool{
+		"stdout":     &options.ShowStdout,
+		"stderr":     &options.ShowStderr,
+		"timestamps": &options.Timestamps,
+		"follow":     &options.Follow,
+	} {
+		if c.Query(key) == "" {
+			continue
+		}
+		b, err := queryBool(c, key)
+		if err != nil {
+			return options, err
+		}
+		*dst = b
+	}
+	if !options.ShowStdout && !options.ShowStderr {
+		return options, fmt.Errorf("at least one of stdout and stderr must be selected")
+	}
+	if options.Tail != "all" {
+		if n, err := strconv.Atoi(options.Tail); err != nil || n < 0 {
+			return options, fmt.Errorf("invalid tail %q, expected a number of lines or all", options.Tail)
+		}
+	}
+	now := time.Now()
+	for key, dst := range map[string]*string{"since": &options.Since, "until": &options.Until} {
+		value := c.Query(key)
+		if value == "" {
+			continue
+		}
+		ts, err := timetypes.GetTimestamp(value, now)
+		if err != nil {
+			return options, fmt.Errorf("invalid %s %q, expected a duration, RFC 3339 time or Unix timestamp", key, value)
+		}
+		*dst = ts
+	}
+	return options, nil
+}
+
+// getContainerLogs streams a container's output as one JSON object per
+// line, or as server-sent events named after the stream if the client
+// accepts text/event-stream.
+func getContainerLogs(c *gin.Context) {
+	options, err := logsOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	// The stream format depends on whether the container has a TTY.
+	info, err := dockerClient.ContainerInspect(ctx, id)
+	if err != nil {
+		respondDockerError(c, "inspect container "+id, err)
+		return
+	}
+	rd, err := dockerClient.ContainerLogs(ctx, id, options)
+	if err != nil {
+		respondDockerError(c, "get logs of container "+id, err)
+		return
+	}
+	defer rd.Close()
+
+	sse := strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+	if sse {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+	}
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	enc := json.NewEncoder(c.Writer)
+	err = demuxLogs(rd, info.Config != nil && info.Config.Tty, options.Timestamps, func(line LogLine) error {
+		if sse {
+			c.SSEvent(line.Stream, line)
+		} else if err := enc.Encode(line); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return ctx.Err()
+	})
+	if err != nil && ctx.Err() == nil {
+		// The status has been sent, so the error ends the stream instead.
+		log.Warnf("Log stream of container %s ended: %v", id, err)
+		if sse {
+			c.SSEvent("error", gin.H{"error": err.Error()})
+		} else {
+			enc.Encode(gin.H{"error": err.Error()})
+		}
+	}
+}
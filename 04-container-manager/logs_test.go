@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// frame builds one frame of a multiplexed stream.
+func frame(stream stdcopy.StdType, payload string) []byte {
+	header := make([]byte, 8)
+	header[0] = byte(stream)
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+	return append(header, payload...)
+}
+
+func frames(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+type testFrame struct {
+	stream  stdcopy.StdType
+	payload string
+}
+
+func TestReadFrames(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		want  []testFrame
+		err   string
+	}{
+		{"empty", nil, nil, ""},
+		{
+			"stdout and stderr",
+			frames(frame(stdcopy.Stdout, "out"), frame(stdcopy.Stderr, "err"), frame(stdcopy.Stdout, "")),
+			[]testFrame{{stdcopy.Stdout, "out"}, {stdcopy.Stderr, "err"}, {stdcopy.Stdout, ""}},
+			"",
+		},
+		{
+			"truncated header",
+			frames(frame(stdcopy.Stdout, "out"), frame(stdcopy.Stdout, "x")[:5]),
+			[]testFrame{{stdcopy.Stdout, "out"}},
+			io.ErrUnexpectedEOF.Error(),
+		},
+		{
+			"truncated payload",
+			frame(stdcopy.Stderr, "complete line\n")[:12],
+			nil,
+			io.ErrUnexpectedEOF.Error(),
+		},
+		{
+			"daemon error",
+			frames(frame(stdcopy.Stdout, "out"), frame(stdcopy.Systemerr, "container gone")),
+			[]testFrame{{stdcopy.Stdout, "out"}},
+			"error from daemon in stream: container gone",
+		},
+		{"unknown stream", frame(7, "?"), nil, "unknown stream type 7 in multiplexed stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []testFrame
+			// One byte per read, so headers and payloads arrive in pieces.
+			err := readFrames(iotest.OneByteReader(bytes.NewReader(tt.input)), func(stream stdcopy.StdType, payload []byte) error {
+				got = append(got, testFrame{stream, string(payload)})
+				return nil
+			})
+			if (err == nil) != (tt.err == "") || (err != nil && err.Error() != tt.err) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err := readFrames(bytes.NewReader(frames(frame(stdcopy.Stdout, "a"), frame(stdcopy.Stdout, "b"))), func(stdcopy.StdType, []byte) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("err = %v after %d calls, want the callback's error after 1", err, calls)
+	}
+}
+
+func collectLogs(t *testing.T, input []byte, tty, timestamps bool) ([]LogLine, error) {
+	t.Helper()
+	var lines []LogLine
+	err := demuxLogs(iotest.HalfReader(bytes.NewReader(input)), tty, timestamps, func(l LogLine) error {
+		lines = append(lines, l)
+		return nil
+	})
+	return lines, err
+}
+
+func TestDemuxLogs(t *testing.T) {
+	input := frames(
+		frame(stdcopy.Stdout, "first li"),
+		frame(stdcopy.Stderr, "warn"),
+		frame(stdcopy.Stdout, "ne\r\nsecond\nthi"),
+		frame(stdcopy.Stderr, "ing\n"),
+		frame(stdcopy.Stdout, "rd"),
+	)
+	lines, err := collectLogs(t, input, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []LogLine{
+		{Stream: "stdout", Line: "first line"},
+		{Stream: "stdout", Line: "second"},
+		{Stream: "stderr", Line: "warning"},
+		// An unterminated last line is flushed at the end.
+		{Stream: "stdout", Line: "third"},
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("got %+v\nwant %+v", lines, want)
+	}
+
+	// The partial line is still emitted when the stream breaks off.
+	lines, err = collectLogs(t, frames(frame(stdcopy.Stdout, "ok\npart"), frame(stdcopy.Stdout, "x")[:3]), false, false)
+	if err != io.ErrUnexpectedEOF || len(lines) != 1 || lines[0].Line != "ok" {
+		t.Errorf("truncated stream: lines = %+v, err = %v", lines, err)
+	}
+}
+
+func TestDemuxLogsTTY(t *testing.T) {
+	// With a TTY the output is raw, so what looks like a frame header is
+	// part of the text.
+	input := append([]byte("2024-01-02T03:04:05.000000000Z $ ls\r\n2024-01-02T03:04:06.000000000Z "), frame(stdcopy.Stderr, "x")...)
+	lines, err := collectLogs(t, input, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []LogLine{
+		{Stream: "stdout", Timestamp: "2024-01-02T03:04:05.000000000Z", Line: "$ ls"},
+		{Stream: "stdout", Timestamp: "2024-01-02T03:04:06.000000000Z", Line: string(frame(stdcopy.Stderr, "x"))},
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("got %+v\nwant %+v", lines, want)
+	}
+}
+
+func TestLineSplitter(t *testing.T) {
+	s := &lineSplitter{stream: "stderr", timestamps: true}
+	var lines []LogLine
+	emit := func(l LogLine) error {
+		lines = append(lines, l)
+		return nil
+	}
+	for _, chunk := range []string{"2024-01-02T03:04:05Z he", "llo\n", "\n2024-01-02T03:04:06Z no-space-after\n", "notimestamp"} {
+		if err := s.write([]byte(chunk), emit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.flush(emit); err != nil {
+		t.Fatal(err)
+	}
+	want := []LogLine{
+		{Stream: "stderr", Timestamp: "2024-01-02T03:04:05Z", Line: "hello"},
+		{Stream: "stderr"},
+		{Stream: "stderr", Timestamp: "2024-01-02T03:04:06Z", Line: "no-space-after"},
+		{Stream: "stderr", Timestamp: "notimestamp"},
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("got %+v\nwant %+v", lines, want)
+	}
+	if err := s.flush(emit); err != nil || len(lines) != len(want) {
+		t.Errorf("second flush emitted %d lines, err %v", len(lines)-len(want), err)
+	}
+}
+
+func TestLineSplitterLongLines(t *testing.T) {
+	s := &lineSplitter{stream: "stdout"}
+	var lines []string
+	emit := func(l LogLine) error {
+		lines = append(lines, l.Line)
+		return nil
+	}
+	long := strings.Repeat("a", maxLogLine) + strings.Repeat("b", 10)
+	if err := s.write([]byte(long+"\nshort"), emit); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || lines[0] != long[:maxLogLine] || lines[1] != strings.Repeat("b", 10) {
+		t.Errorf("got %d lines of lengths %v", len(lines), lineLengths(lines))
+	}
+	if string(s.partial) != "short" {
+		t.Errorf("partial = %q, want the unterminated line kept", s.partial)
+	}
+}
+
+func lineLengths(lines []string) []int {
+	var lengths []int
+	for _, l := range lines {
+		lengths = append(lengths, len(l))
+	}
+	return lengths
+}
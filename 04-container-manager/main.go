@@ -32,8 +32,12 @@ func init() {
 		log.Fatal(err)
 	}
 	log.SetLevel(level)
+}
 
-	// Initialize Docker client
+// connectDocker creates the Docker client and checks that the daemon is
+// reachable. It runs in main rather than init so tests can run without a
+// daemon.
+func connectDocker() {
 	ctx := context.Background()
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
@@ -51,6 +55,8 @@ func init() {
 }
 
 func main() {
+	connectDocker()
+
 	// Create Gin router
 	router := gin.Default()
 
@@ -77,10 +83,20 @@ func main() {
 			containers.GET("/:id", getContainer)
 			containers.POST("/:id/start", startContainer)
 			containers.POST("/:id/stop", stopContainer)
+			containers.POST("/:id/restart", restartContainer)
+			containers.POST("/:id/kill", killContainer)
+			containers.POST("/:id/pause", pauseContainer)
+			containers.POST("/:id/unpause", unpauseContainer)
+			containers.POST("/:id/rename", renameContainer)
 			containers.DELETE("/:id", removeContainer)
 			containers.GET("/:id/stats", getContainerStats)
+			containers.GET("/:id/logs", getContainerLogs)
+			containers.POST("/:id/exec", createExec)
 		}
 
+		// Exec routes
+		v1.GET("/exec/:id/attach", attachExec)
+
 		// Image routes
 		images := v1.Group("/images")
 		{
@@ -101,6 +117,9 @@ func main() {
 		}
 	}
 
+	// Origins besides the API's own that may open WebSockets
+	allowedOrigins = parseOrigins(os.Getenv("ALLOWED_ORIGINS"))
+
 	// Get port from environment variable or use default
 	port := os.Getenv("API_PORT")
 	if port == "" {
@@ -126,30 +145,6 @@ func listContainers(c *gin.Context) {
 	c.JSON(http.StatusOK, containers)
 }
 
-func createContainer(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{"message": "Not implemented yet"})
-}
-
-func getContainer(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{"message": "Not implemented yet"})
-}
-
-func startContainer(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{"message": "Not implemented yet"})
-}
-
-func stopContainer(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{"message": "Not implemented yet"})
-}
-
-func removeContainer(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{"message": "Not implemented yet"})
-}
-
-func getContainerStats(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{"message": "Not implemented yet"})
-}
-
 func listImages(c *gin.Context) {
 	images, err := dockerClient.ImageList(context.Background(), types.ImageListOptions{All: true})
 	if err != nil {
@@ -161,10 +156,6 @@ func listImages(c *gin.Context) {
 	c.JSON(http.StatusOK, images)
 }
 
-func pullImage(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{"message": "Not implemented yet"})
-}
-
 func removeImage(c *gin.Context) {
 	c.JSON(http.StatusNotImplemented, gin.H{"message": "Not implemented yet"})
 }
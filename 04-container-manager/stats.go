@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// ContainerStats is the resource usage of a container computed from the
+// raw Docker stats the way `docker stats` does it.
+type ContainerStats struct {
+	ID   string    `json:"id"`
+	Name string    `json:"name"`
+	Read time.Time `json:"read"`
+	// CPUPercent is relative to one CPU, so 200 means two busy cores.
+	CPUPercent float64 `json:"cpu_percent"`
+	OnlineCPUs uint32  `json:"online_cpus"`
+	// MemoryUsage excludes the inactive page cache, which the kernel can
+	// reclaim at any time.
+	MemoryUsage   uint64  `json:"memory_usage"`
+	MemoryLimit   uint64  `json:"memory_limit"`
+	MemoryPercent float64 `json:"memory_percent"`
+	NetworkRx     uint64  `json:"network_rx_bytes"`
+	NetworkTx     uint64  `json:"network_tx_bytes"`
+	BlockRead     uint64  `json:"block_read_bytes"`
+	BlockWrite    uint64  `json:"block_write_bytes"`
+	PIDs          uint64  `json:"pids"`
+	PIDsLimit     uint64  `json:"pids_limit,omitempty"`
+	// Rates are set on streamed updates after the first one.
+	Rates *StatsRates `json:"rates,omitempty"`
+}
+
+// StatsRates are per-second rates since the previous streamed update.
+type StatsRates struct {
+	NetworkRx  float64 `json:"network_rx_bytes_per_sec"`
+	NetworkTx  float64 `json:"network_tx_bytes_per_sec"`
+	BlockRead  float64 `json:"block_read_bytes_per_sec"`
+	BlockWrite float64 `json:"block_write_bytes_per_sec"`
+}
+
+// computeStats derives ContainerStats from one raw Docker stats reading,
+// whose precpu_stats hold the previous CPU reading.
+func computeStats(raw *types.StatsJSON) ContainerStats {
+	stats := ContainerStats{
+		ID:          raw.ID,
+		Name:        strings.TrimPrefix(raw.Name, "/"),
+		Read:        raw.Read,
+		OnlineCPUs:  raw.CPUStats.OnlineCPUs,
+		MemoryLimit: raw.MemoryStats.Limit,
+		PIDs:        raw.PidsStats.Current,
+		PIDsLimit:   raw.PidsStats.Limit,
+	}
+
+	if stats.OnlineCPUs == 0 {
+		stats.OnlineCPUs = uint32(len(raw.CPUStats.CPUUsage.PercpuUsage))
+	}
+	cpuDelta := float64(raw.CPUStats.CPUUsage.TotalUsage) - float64(raw.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(raw.CPUStats.SystemUsage) - float64(raw.PreCPUStats.SystemUsage)
+	if cpuDelta > 0 && systemDelta > 0 {
+		stats.CPUPercent = cpuDelta / systemDelta * float64(stats.OnlineCPUs) * 100
+	}
+
+	// cgroup v1 reports total_inactive_file, cgroup v2 inactive_file.
+	stats.MemoryUsage = raw.MemoryStats.Usage
+	for _, key := range []string{"total_inactive_file", "inactive_file"} {
+		if v, ok := raw.MemoryStats.Stats[key]; ok && v < stats.MemoryUsage {
+			stats.MemoryUsage -= v
+			break
+		}
+	}
+	if stats.MemoryLimit > 0 {
+		stats.MemoryPercent = float64(stats.MemoryUsage) / float64(stats.MemoryLimit) * 100
+	}
+
+	for _, n := range raw.Networks {
+		stats.NetworkRx += n.RxBytes
+		stats.NetworkTx += n.TxBytes
+	}
+	for _, entry := range raw.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			stats.BlockRead += entry.Value
+		case "write":
+			stats.BlockWrite += entry.Value
+		}
+	}
+	return stats
+}
+
+// statsRates computes the rates between two readings. Counters that went
+// backwards, e.g. after a restart, count from zero.
+func statsRates(prev, cur *ContainerStats) *StatsRates {
+	seconds := cur.Read.Sub(prev.Read).Seconds()
+	if seconds <= 0 {
+		return nil
+	}
+	rate := func(prev, cur uint64) float64 {
+		if cur < prev {
+			prev = 0
+		}
+		return float64(cur-prev) / seconds
+	}
+	return &StatsRates{
+		NetworkRx:  rate(prev.NetworkRx, cur.NetworkRx),
+		NetworkTx:  rate(prev.NetworkTx, cur.NetworkTx),
+		BlockRead:  rate(prev.BlockRead, cur.BlockRead),
+		BlockWrite: rate(prev.BlockWrite, cur.BlockWrite),
+	}
+}
+
+// forwardStats decodes a raw Docker stats stream and passes every computed
+// update to send until the stream ends or send fails.
+func forwardStats(body io.Reader, send func(ContainerStats) error) error {
+	dec := json.NewDecoder(body)
+	var prev *ContainerStats
+	for {
+		var raw types.StatsJSON
+		if err := dec.Decode(&raw); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		stats := computeStats(&raw)
+		if prev != nil {
+			stats.Rates = statsRates(prev, &stats)
+		}
+		if err := send(stats); err != nil {
+			return err
+		}
+		prev = &stats
+	}
+}
+
+// getContainerStats returns a snapshot, or with stream=true an update
+// every second over a WebSocket if the request asks for an upgrade and as
+// server-sent events otherwise.
+func getContainerStats(c *gin.Context) {
+	stream, err := queryBool(c, "stream")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	id := c.Param("id")
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	// Without streaming, Docker takes two readings so CPU usage is known.
+	resp, err := dockerClient.ContainerStats(ctx, id, stream)
+	if err != nil {
+		respondDockerError(c, "get stats of container "+id, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if !stream {
+		var raw types.StatsJSON
+		if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+			log.Errorf("Failed to decode stats of container %s: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, computeStats(&raw))
+		return
+	}
+
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			// The upgrader has already answered the request.
+			log.Warnf("Failed to upgrade stats stream of container %s: %v", id, err)
+			return
+		}
+		// The connection is hijacked, so a closed socket is only noticed
+		// by reading from it.
+		go func() {
+			defer cancel()
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		err = forwardStats(resp.Body, func(stats ContainerStats) error {
+			return writeWebSocketJSON(conn, stats)
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Warnf("Stats stream of container %s ended: %v", id, err)
+			closeWebSocket(conn, websocket.CloseInternalServerErr, "stats stream failed")
+			return
+		}
+		closeWebSocket(conn, websocket.CloseNormalClosure, "")
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	err = forwardStats(resp.Body, func(stats ContainerStats) error {
+		c.SSEvent("stats", stats)
+		c.Writer.Flush()
+		return ctx.Err()
+	})
+	if err != nil && ctx.Err() == nil {
+		log.Warnf("Stats stream of container %s ended: %v", id, err)
+		c.SSEvent("error", gin.H{"error": err.Error()})
+	}
+}
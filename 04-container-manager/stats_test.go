@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func rawStats() *types.StatsJSON {
+	raw := &types.StatsJSON{Name: "/web", ID: "abc"}
+	raw.Read = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	raw.CPUStats.OnlineCPUs = 4
+	raw.CPUStats.CPUUsage.TotalUsage = 3_000_000_000
+	raw.CPUStats.SystemUsage = 20_000_000_000
+	raw.PreCPUStats.CPUUsage.TotalUsage = 1_000_000_000
+	raw.PreCPUStats.SystemUsage = 10_000_000_000
+	raw.MemoryStats.Usage = 600 << 20
+	raw.MemoryStats.Limit = 1 << 30
+	raw.MemoryStats.Stats = map[string]uint64{"inactive_file": 88 << 20}
+	raw.PidsStats.Current = 12
+	raw.Networks = map[string]types.NetworkStats{
+		"eth0": {RxBytes: 1000, TxBytes: 200},
+		"eth1": {RxBytes: 24, TxBytes: 56},
+	}
+	raw.BlkioStats.IoServiceBytesRecursive = []types.BlkioStatEntry{
+		{Op: "Read", Value: 4096},
+		{Op: "write", Value: 8192},
+		{Op: "Total", Value: 12288},
+		{Op: "read", Value: 4096},
+	}
+	return raw
+}
+
+func TestComputeStats(t *testing.T) {
+	stats := computeStats(rawStats())
+	want := ContainerStats{
+		ID:   "abc",
+		Name: "web",
+		Read: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		// 2s of CPU time in 10s of system time across 4 CPUs.
+		CPUPercent:    80,
+		OnlineCPUs:    4,
+		MemoryUsage:   512 << 20,
+		MemoryLimit:   1 << 30,
+		MemoryPercent: 50,
+		NetworkRx:     1024,
+		NetworkTx:     256,
+		BlockRead:     8192,
+		BlockWrite:    8192,
+		PIDs:          12,
+	}
+	if stats != want {
+		t.Errorf("got %+v\nwant %+v", stats, want)
+	}
+}
+
+func TestComputeStatsEdgeCases(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*types.StatsJSON)
+		check  func(ContainerStats) error
+	}{
+		{"cgroup v1 cache", func(raw *types.StatsJSON) {
+			raw.MemoryStats.Stats = map[string]uint64{"total_inactive_file": 100 << 20, "inactive_file": 1}
+		}, func(s ContainerStats) error {
+			return expect(s.MemoryUsage == 500<<20, "memory usage %d", s.MemoryUsage)
+		}},
+		{"cache larger than usage", func(raw *types.StatsJSON) {
+			raw.MemoryStats.Stats = map[string]uint64{"inactive_file": 700 << 20}
+		}, func(s ContainerStats) error {
+			return expect(s.MemoryUsage == 600<<20, "memory usage %d", s.MemoryUsage)
+		}},
+		{"no limit", func(raw *types.StatsJSON) { raw.MemoryStats.Limit = 0 }, func(s ContainerStats) error {
+			return expect(s.MemoryPercent == 0, "memory percent %v", s.MemoryPercent)
+		}},
+		{"CPUs from per-CPU usage", func(raw *types.StatsJSON) {
+			raw.CPUStats.OnlineCPUs = 0
+			raw.CPUStats.CPUUsage.PercpuUsage = []uint64{1, 2}
+		}, func(s ContainerStats) error {
+			return expect(s.OnlineCPUs == 2 && s.CPUPercent == 40, "%d CPUs, %v%%", s.OnlineCPUs, s.CPUPercent)
+		}},
+		// The first reading of a stream has no previous CPU reading.
+		{"no previous reading", func(raw *types.StatsJSON) { raw.PreCPUStats = types.CPUStats{} }, func(s ContainerStats) error {
+			return expect(math.Abs(s.CPUPercent-60) < 1e-9, "CPU %v%%", s.CPUPercent)
+		}},
+		{"stopped", func(raw *types.StatsJSON) { raw.CPUStats = types.CPUStats{} }, func(s ContainerStats) error {
+			return expect(s.CPUPercent == 0, "CPU %v%%", s.CPUPercent)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := rawStats()
+			tt.modify(raw)
+			if err := tt.check(computeStats(raw)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func expect(ok bool, format string, args ...interface{}) error {
+	if ok {
+		return nil
+	}
+	return fmt.Errorf("unexpected "+format, args...)
+}
+
+func TestStatsRates(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	prev := &ContainerStats{Read: start, NetworkRx: 1000, NetworkTx: 500, BlockRead: 4096, BlockWrite: 0}
+	cur := &ContainerStats{Read: start.Add(2 * time.Second), NetworkRx: 3000, NetworkTx: 100, BlockRead: 4096, BlockWrite: 8192}
+
+	got := statsRates(prev, cur)
+	// The transmit counter went backwards, so it counts from zero.
+	want := StatsRates{NetworkRx: 1000, NetworkTx: 50, BlockRead: 0, BlockWrite: 4096}
+	if got == nil || *got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := statsRates(cur, cur); got != nil {
+		t.Errorf("rates without elapsed time = %+v, want nil", got)
+	}
+	if got := statsRates(cur, prev); got != nil {
+		t.Errorf("rates going back in time = %+v, want nil", got)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsWriteTimeout bounds every write to a WebSocket so a stalled client
+// cannot block a stream forever.
+const wsWriteTimeout = 10 * time.Second
+
+// allowedOrigins lists the origins besides the API's own that may open
+// WebSockets, from ALLOWED_ORIGINS. "*" allows any origin.
+var allowedOrigins []string
+
+var upgrader = websocket.Upgrader{CheckOrigin: checkOrigin}
+
+// parseOrigins splits a comma-separated origin list.
+func parseOrigins(value string) []string {
+	var origins []string
+	for _, origin := range strings.Split(value, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, strings.TrimSuffix(origin, "/"))
+		}
+	}
+	return origins
+}
+
+// checkOrigin accepts requests without an Origin header (non-browser
+// clients), from the API's own origin and from allowedOrigins. Browsers
+// do not apply the same-origin policy to WebSockets, so without this check
+// any web page could drive the API through a visitor's browser.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeWebSocketJSON writes v as a text message.
+func writeWebSocketJSON(conn *websocket.Conn, v interface{}) error {
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return conn.WriteJSON(v)
+}
+
+// closeWebSocket sends a close frame with the given code and reason and
+// closes the connection.
+func closeWebSocket(conn *websocket.Conn, code int, reason string) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	conn.Close()
+}